@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"cloud.google.com/go/datastore"
@@ -15,6 +17,7 @@ import (
 	"google.golang.org/api/iterator"
 
 	"outlived"
+	"outlived/site"
 )
 
 func (c *maincmd) admin(ctx context.Context, args []string) error {
@@ -33,12 +36,41 @@ func (a admincmd) Subcmds() subcmd.Map {
 			"limit", subcmd.Int, 100, "limit on figures to return",
 		),
 		"list-users", a.listUsers, nil,
+		"mail-events", a.mailEvents, subcmd.Params(
+			"email", subcmd.String, "", "e-mail address of the user",
+		),
+		"migrate-users", a.migrateUsers, nil,
+		"preview-mail", a.previewMail, subcmd.Params(
+			"email", subcmd.String, "", "e-mail address of the user",
+			"date", subcmd.String, "", "date of the mail, YYYY-MM-DD",
+			"part", subcmd.String, "", "part of each message to print: text, html, or both (the default)",
+			"templates", subcmd.String, "", "path to directory containing mail templates overriding the built-in ones",
+		),
+		"resave-figures", a.resaveFigures, nil,
 		"get", a.get, nil,
 		"set", a.set, nil,
 		"scrape", a.scrape, subcmd.Params(
 			"month", subcmd.String, "", "3-letter month",
 			"day", subcmd.Int, 0, "day of month",
 			"limit", subcmd.Duration, time.Second, "rate limit",
+			"source", subcmd.String, "html", "where to get figure details: html, wikidata, or merge",
+			"record", subcmd.String, "", "directory in which to record HTTP responses (e.g. for test fixtures)",
+		),
+		"scrape-failures", a.scrapeFailures, subcmd.Params(
+			"run", subcmd.String, "", "ID of the scrape run (default: the most recent)",
+			"class", subcmd.String, "", "show only failures of this class: http, parse, nodates, pageviews, or other",
+			"summary", subcmd.Bool, false, "show only the number of failures in each class",
+			"json", subcmd.Bool, false, "print the failures as JSON",
+		),
+		"scrape-retry", a.scrapeRetry, subcmd.Params(
+			"run", subcmd.String, "", "ID of the scrape run (default: the most recent)",
+			"class", subcmd.String, "", "retry only failures of this class: http, parse, nodates, pageviews, or other",
+			"limit", subcmd.Duration, time.Second, "rate limit",
+			"source", subcmd.String, "", "where to get figure details: html, wikidata, or merge (default: the figure_source setting)",
+		),
+		"scrape-status", a.scrapeStatus, subcmd.Params(
+			"limit", subcmd.Int, 5, "how many recent runs to show",
+			"json", subcmd.Bool, false, "print the report as JSON",
 		),
 	)
 }
@@ -49,7 +81,7 @@ func (a admincmd) listFigures(ctx context.Context, diedStr string, limit int, _
 		return err
 	}
 
-	figs, err := outlived.FiguresDiedOn(ctx, a.c.dsClient, died.Month(), died.Day(), limit)
+	figs, err := a.c.figures.FiguresDiedOn(ctx, died.Month(), died.Day(), limit)
 	if err != nil {
 		return err
 	}
@@ -76,6 +108,216 @@ func (a admincmd) listUsers(ctx context.Context, _ []string) error {
 	}
 }
 
+// Function mailEvents prints the history of mail events
+// (bounces, complaints, and unsubscribes reported by the mail service)
+// for the given user.
+func (a admincmd) mailEvents(ctx context.Context, email string, _ []string) error {
+	if email == "" {
+		return errors.New("must specify -email")
+	}
+	canon, err := aesite.CanonicalizeEmail(email)
+	if err != nil {
+		return errors.Wrapf(err, "canonicalizing %s", email)
+	}
+
+	q := datastore.NewQuery("MailEvent").Ancestor(datastore.NameKey("User", canon, nil)).Order("Time")
+	var events []*outlived.MailEvent
+	_, err = a.c.dsClient.GetAll(ctx, q, &events)
+	if err != nil {
+		return errors.Wrap(err, "getting mail events")
+	}
+	for _, ev := range events {
+		fmt.Printf("%s %s (%s, to %s) %s\n", ev.Time.Format(time.RFC3339), ev.Type, ev.Source, ev.Addr, ev.Detail)
+	}
+	return nil
+}
+
+// Function migrateUsers rewrites every user in the datastore
+// into its current form.
+// The send cron job does this automatically on its first run after a deploy;
+// this command is for doing it sooner, or again.
+// See site.Server.MigrateUsers.
+func (a admincmd) migrateUsers(ctx context.Context, _ []string) error {
+	s, err := site.NewAdminServer(ctx, "", a.c.dsClient, a.c.figures)
+	if err != nil {
+		return errors.Wrap(err, "creating server")
+	}
+	n, err := s.MigrateUsers(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("migrated %d user(s)", n)
+	return nil
+}
+
+// Function previewMail prints the mail that the given user would get on the given date,
+// without sending anything.
+// See site.Server.PreviewMail.
+func (a admincmd) previewMail(ctx context.Context, email, dateStr, part, templateDir string, _ []string) error {
+	if email == "" || dateStr == "" {
+		return errors.New("must specify -email and -date")
+	}
+	switch part {
+	case "", "text", "html":
+	default:
+		return fmt.Errorf("unknown part %s", part)
+	}
+
+	today, err := outlived.ParseDate(dateStr)
+	if err != nil {
+		return errors.Wrapf(err, "parsing date %s", dateStr)
+	}
+
+	s, err := site.NewAdminServer(ctx, templateDir, a.c.dsClient, a.c.figures)
+	if err != nil {
+		return errors.Wrap(err, "creating server")
+	}
+
+	previews, err := s.PreviewMail(ctx, email, today)
+	if err != nil {
+		return err
+	}
+	return site.WriteMailPreviews(os.Stdout, previews, part)
+}
+
+// Function scrapeFailures lists the persons who could not be scraped in a scrape run,
+// grouped by the class of error.
+// See site.Server.ScrapeFailures.
+func (a admincmd) scrapeFailures(ctx context.Context, runID, classStr string, summary, asJSON bool, _ []string) error {
+	class, err := parseScrapeErrorClass(classStr)
+	if err != nil {
+		return err
+	}
+
+	s, err := site.NewAdminServer(ctx, "", a.c.dsClient, a.c.figures)
+	if err != nil {
+		return errors.Wrap(err, "creating server")
+	}
+
+	runID, failures, err := s.ScrapeFailures(ctx, runID, class)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(failures)
+	}
+
+	fmt.Printf("Run %s: %d failure(s)\n", runID, len(failures))
+
+	// Failures are sorted by class.
+	for i := 0; i < len(failures); {
+		j := i + 1
+		for j < len(failures) && failures[j].Class == failures[i].Class {
+			j++
+		}
+		fmt.Printf("%s: %d\n", failures[i].Class, j-i)
+		if !summary {
+			for _, f := range failures[i:j] {
+				fmt.Printf("  %s %s (%s): %s\n", f.Time.Format(time.RFC3339), f.Title, f.Href, f.Err)
+			}
+		}
+		i = j
+	}
+	return nil
+}
+
+// Function scrapeRetry scrapes again the persons who could not be scraped in a scrape run,
+// recording the new outcomes in the run.
+// See site.Server.RetryScrapeFailures.
+func (a admincmd) scrapeRetry(ctx context.Context, runID, classStr string, limit time.Duration, srcStr string, _ []string) error {
+	class, err := parseScrapeErrorClass(classStr)
+	if err != nil {
+		return err
+	}
+	var src outlived.Source
+	if srcStr != "" {
+		src, err = outlived.ParseSource(srcStr)
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := site.NewAdminServer(ctx, "", a.c.dsClient, a.c.figures)
+	if err != nil {
+		return errors.Wrap(err, "creating server")
+	}
+
+	client := &http.Client{
+		Transport: &rlroundtripper{
+			limiter: rate.NewLimiter(rate.Every(limit), 1),
+			rt:      http.DefaultTransport,
+		},
+	}
+	retried, fixed, err := s.RetryScrapeFailures(ctx, client, runID, class, src)
+	log.Printf("retried %d person(s), %d succeeded", retried, fixed)
+	return err
+}
+
+// Function parseScrapeErrorClass parses the -class flag,
+// which may be empty.
+func parseScrapeErrorClass(s string) (outlived.ScrapeErrorClass, error) {
+	if s == "" {
+		return "", nil
+	}
+	return outlived.ParseScrapeErrorClass(s)
+}
+
+// Function scrapeStatus shows the progress of the current scrape run
+// and the outcomes of past ones.
+// See site.Server.ScrapeRuns.
+func (a admincmd) scrapeStatus(ctx context.Context, limit int, asJSON bool, _ []string) error {
+	s, err := site.NewAdminServer(ctx, "", a.c.dsClient, a.c.figures)
+	if err != nil {
+		return errors.Wrap(err, "creating server")
+	}
+
+	reports, err := s.ScrapeRuns(ctx, limit)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reports)
+	}
+
+	for _, rep := range reports {
+		end := "running"
+		if rep.End != nil {
+			end = "ended " + rep.End.Format(time.RFC3339)
+		}
+		fmt.Printf("Run %s: started %s, %s\n", rep.ID, rep.Start.Format(time.RFC3339), end)
+		fmt.Printf("  days: %d enqueued, %d done, %d failed\n", rep.Days, rep.DaysDone, rep.DaysFailed)
+		fmt.Printf("  persons: %d found, %d attempted, %d succeeded, %d failed\n", rep.Persons, rep.Attempted, rep.Succeeded, rep.Failed)
+		for _, f := range rep.Failures {
+			fmt.Printf("    %s %s (%s): %s\n", f.Time.Format(time.RFC3339), f.Title, f.Href, f.Err)
+		}
+	}
+	return nil
+}
+
+// Function resaveFigures rewrites every figure in the datastore,
+// filling in fields (like DiedGregorian) added since it was stored
+// and converting years BC stored with historical numbering
+// (see outlived.Figure.Load).
+func (a admincmd) resaveFigures(ctx context.Context, _ []string) error {
+	var figures []*outlived.Figure
+	_, err := a.c.dsClient.GetAll(ctx, datastore.NewQuery("Figure"), &figures)
+	if err != nil {
+		return errors.Wrap(err, "getting figures")
+	}
+	err = a.c.figures.ReplaceFigures(ctx, figures)
+	if err != nil {
+		return errors.Wrap(err, "storing figures")
+	}
+	log.Printf("resaved %d figure(s)", len(figures))
+	return nil
+}
+
 func (a admincmd) get(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return errors.New("usage: outlived admin get VAR")
@@ -114,7 +356,12 @@ var daysInMonth = []int{
 	31,
 }
 
-func (a admincmd) scrape(ctx context.Context, monthStr string, onlyDay int, limit time.Duration, _ []string) error {
+func (a admincmd) scrape(ctx context.Context, monthStr string, onlyDay int, limit time.Duration, srcStr, recordDir string, _ []string) error {
+	src, err := outlived.ParseSource(srcStr)
+	if err != nil {
+		return err
+	}
+
 	var (
 		startMonth = time.January
 		endMonth   = time.December
@@ -122,10 +369,14 @@ func (a admincmd) scrape(ctx context.Context, monthStr string, onlyDay int, limi
 	if monthStr == "" && onlyDay != 0 {
 		return errors.New("must specify -month with -day")
 	}
+	var rt http.RoundTripper = http.DefaultTransport
+	if recordDir != "" {
+		rt = &outlived.RecordingTransport{Dir: recordDir}
+	}
 	client := &http.Client{
 		Transport: &rlroundtripper{
 			limiter: rate.NewLimiter(rate.Every(limit), 1),
-			rt:      http.DefaultTransport,
+			rt:      rt,
 		},
 	}
 	if monthStr != "" {
@@ -134,14 +385,14 @@ func (a admincmd) scrape(ctx context.Context, monthStr string, onlyDay int, limi
 			return err
 		}
 		if onlyDay != 0 {
-			return scrapeMonthDay(ctx, client, a.c.dsClient, d.Month(), onlyDay)
+			return scrapeMonthDay(ctx, client, a.c.figures, src, d.Month(), onlyDay)
 		}
 		startMonth = d.Month()
 		endMonth = d.Month()
 	}
 	for m := startMonth; m <= endMonth; m++ {
 		for d := 1; d <= daysInMonth[m]; d++ {
-			err := scrapeMonthDay(ctx, client, a.c.dsClient, m, d)
+			err := scrapeMonthDay(ctx, client, a.c.figures, src, m, d)
 			if err != nil {
 				return err
 			}
@@ -150,30 +401,10 @@ func (a admincmd) scrape(ctx context.Context, monthStr string, onlyDay int, limi
 	return nil
 }
 
-func scrapeMonthDay(ctx context.Context, client *http.Client, dsClient *datastore.Client, m time.Month, d int) error {
+func scrapeMonthDay(ctx context.Context, client *http.Client, figures outlived.FigureStore, src outlived.Source, m time.Month, d int) error {
 	return outlived.ScrapeDay(ctx, client, m, d, func(ctx context.Context, href, title, desc string) error {
 		log.Printf("scraping %s-%d", m, d)
-		err := outlived.ScrapePerson(ctx, client, href, title, desc,
-			func(
-				ctx context.Context,
-				title, desc, href, imgSrc, imgAlt string,
-				bornY, bornM, bornD, diedY, diedM, diedD, aliveDays, pageviews int,
-			) error {
-				log.Printf("updating person %s (href %s), %d pageviews", title, href, pageviews)
-				fig := &outlived.Figure{
-					Name:      title,
-					Desc:      desc,
-					Link:      href,
-					ImgSrc:    imgSrc,
-					ImgAlt:    imgAlt,
-					Born:      outlived.Date{Y: bornY, M: time.Month(bornM), D: bornD},
-					Died:      outlived.Date{Y: diedY, M: time.Month(diedM), D: diedD},
-					DaysAlive: aliveDays,
-					Pageviews: pageviews,
-					Updated:   time.Now(),
-				}
-				return outlived.ReplaceFigures(ctx, dsClient, []*outlived.Figure{fig})
-			})
+		_, err := outlived.UpdateFigure(ctx, client, figures, src, href, title, desc)
 		if err != nil {
 			log.Printf("ERROR: %s", err)
 		}
@@ -10,14 +10,18 @@ import (
 	"github.com/bobg/aesite"
 	"github.com/bobg/subcmd"
 	"google.golang.org/api/option"
+
+	"outlived"
+	"outlived/memds"
 )
 
 func main() {
 	var (
-		creds      = flag.String("creds", "", "path to credentials file")
-		test       = flag.Bool("test", false, "run in test mode")
-		projectID  = flag.String("project", "outlived-163105", "Google Cloud project ID")
-		locationID = flag.String("location", "us-central1", "location ID")
+		creds       = flag.String("creds", "", "path to credentials file")
+		test        = flag.Bool("test", false, "run in test mode")
+		projectID   = flag.String("project", "outlived-163105", "Google Cloud project ID")
+		locationID  = flag.String("location", "us-central1", "location ID")
+		figuresFile = flag.String("figures", "", "path to a file for storing figures (instead of the datastore; with -test, other data is kept in memory instead of in the datastore emulator)")
 	)
 	flag.Parse()
 
@@ -28,25 +32,36 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	var (
-		done <-chan struct{}
-		err  error
-	)
-	if *test {
-		done, err = aesite.DSTestWithDoneChan(ctx, *projectID)
-		if err != nil {
-			log.Fatalf("Starting test datastore service: %s", err)
-		}
-	}
-
 	var options []option.ClientOption
 	if *creds != "" {
 		options = append(options, option.WithCredentialsFile(*creds))
 	}
 
-	dsClient, err := datastore.NewClient(ctx, *projectID, options...)
-	if err != nil {
-		log.Fatalf("Creating datastore client: %s", err)
+	var (
+		dsClient *datastore.Client
+		done     <-chan struct{}
+		err      error
+	)
+	if *test && *figuresFile != "" {
+		// With figures in a file,
+		// everything else the site stores is small enough to keep in memory,
+		// so there is no need for the datastore emulator.
+		dsClient, err = memds.NewClient(ctx, *projectID)
+		if err != nil {
+			log.Fatalf("Starting in-memory datastore: %s", err)
+		}
+	} else {
+		if *test {
+			done, err = aesite.DSTestWithDoneChan(ctx, *projectID)
+			if err != nil {
+				log.Fatalf("Starting test datastore service: %s", err)
+			}
+		}
+
+		dsClient, err = datastore.NewClient(ctx, *projectID, options...)
+		if err != nil {
+			log.Fatalf("Creating datastore client: %s", err)
+		}
 	}
 
 	var ctClient *cloudtasks.Client
@@ -57,11 +72,21 @@ func main() {
 		}
 	}
 
+	var figures outlived.FigureStore = (*outlived.DSFigureStore)(dsClient)
+	if *figuresFile != "" {
+		figures, err = outlived.OpenFileFigureStore(*figuresFile)
+		if err != nil {
+			log.Fatalf("Opening figures file: %s", err)
+		}
+	}
+
 	c := &maincmd{
 		dsClient:   dsClient,
+		figures:    figures,
 		ctClient:   ctClient,
 		locationID: *locationID,
 		projectID:  *projectID,
+		test:       *test,
 	}
 
 	args := flag.Args()
@@ -82,14 +107,21 @@ func main() {
 
 type maincmd struct {
 	dsClient              *datastore.Client
+	figures               outlived.FigureStore
 	ctClient              *cloudtasks.Client
 	projectID, locationID string
+	test                  bool
 }
 
 func (c *maincmd) Subcmds() subcmd.Map {
 	return subcmd.Commands(
 		"serve", c.serve, subcmd.Params(
 			"content", subcmd.String, "web/public", "path to directory containing static content (for test mode)",
+			"templates", subcmd.String, "", "path to directory containing mail, page, and feed templates overriding the built-in ones",
+			"maildir", subcmd.String, "", "write outgoing mail to this Maildir instead of sending it (test mode only)",
+			"mbox", subcmd.String, "", "append outgoing mail to this mbox file instead of sending it (test mode only)",
+			"tasks", subcmd.String, "", "keep pending tasks in this file across restarts (test mode only)",
+			"queues", subcmd.String, "", "read task queue settings from this queue.yaml file (test mode only)",
 		),
 		"admin", c.admin, nil,
 	)
@@ -8,11 +8,39 @@ import (
 	"outlived/site"
 )
 
-func (c *maincmd) serve(ctx context.Context, contentDir string, args []string) error {
-	s, err := site.NewServer(ctx, contentDir, c.projectID, c.locationID, c.dsClient, c.ctClient)
+func (c *maincmd) serve(ctx context.Context, contentDir, templateDir, maildir, mbox, tasks, queues string, args []string) error {
+	if (maildir != "" || mbox != "") && !c.test {
+		return errors.New("-maildir and -mbox require -test")
+	}
+	if (tasks != "" || queues != "") && !c.test {
+		return errors.New("-tasks and -queues require -test")
+	}
+	if maildir != "" && mbox != "" {
+		return errors.New("cannot supply both -maildir and -mbox")
+	}
+
+	s, err := site.NewServer(ctx, contentDir, templateDir, c.projectID, c.locationID, c.dsClient, c.ctClient, c.figures)
 	if err != nil {
 		return errors.Wrap(err, "creating server")
 	}
+
+	switch {
+	case maildir != "":
+		err = s.DeliverToMaildir(maildir)
+		if err != nil {
+			return errors.Wrap(err, "setting up Maildir delivery")
+		}
+	case mbox != "":
+		s.DeliverToMbox(mbox)
+	}
+
+	if tasks != "" || queues != "" {
+		err = s.UseLocalTasks(tasks, queues)
+		if err != nil {
+			return errors.Wrap(err, "setting up local tasks")
+		}
+	}
+
 	s.Serve(ctx)
 
 	return nil
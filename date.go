@@ -5,22 +5,84 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// Date is a calendar date.
 type Date struct {
+	// Y is the astronomical year number:
+	// 1 is AD 1, 0 is 1 BC, -1 is 2 BC, and so on.
+	// See https://en.wikipedia.org/wiki/Astronomical_year_numbering.
 	Y int
+
 	M time.Month
 	D int
+
+	// Cal is the calendar in which the date is expressed.
+	// The zero value is Gregorian.
+	Cal Calendar
+
+	// Prec is how precisely the date is known.
+	// The zero value is DayPrecision.
+	// In a less precise date,
+	// the unknown parts are filled in with the earliest possible values:
+	// e.g., 1450s is 1 January 1450.
+	Prec Precision
+
+	// Circa means the date is approximate.
+	Circa bool
 }
 
-var dateRegex = regexp.MustCompile(`(\d+)-(\d+)-(\d+)`)
+// Precision is how precisely a Date is known.
+type Precision int
+
+const (
+	DayPrecision Precision = iota
+	MonthPrecision
+	YearPrecision
+	DecadePrecision
+)
+
+// Exact tells whether d is known to the day and is not circa.
+func (d Date) Exact() bool {
+	return d.Prec == DayPrecision && !d.Circa
+}
+
+// Calendar is a calendar in which a Date can be expressed.
+type Calendar int
+
+const (
+	// Gregorian is the (proleptic) Gregorian calendar.
+	Gregorian Calendar = iota
+
+	// Julian is the (proleptic) Julian calendar.
+	// Wikipedia gives most dates before 15 October 1582 in this calendar,
+	// and some later ones too (marked "O.S.", for Old Style),
+	// from countries that switched to the Gregorian calendar later.
+	Julian
+)
+
+func (c Calendar) String() string {
+	if c == Julian {
+		return "Julian"
+	}
+	return "Gregorian"
+}
+
+// GregorianStart is the first day of the Gregorian calendar.
+// The day before it was 4 October 1582 in the Julian calendar.
+var GregorianStart = Date{Y: 1582, M: time.October, D: 15}
+
+var dateRegex = regexp.MustCompile(`^([+-]?\d+)-(\d+)-(\d+)$`)
 
 var errDateParse = errors.New("bad dates")
 
 // Parses dates of the form "yyyy-mm-dd."
+// The year is astronomical and may be signed, as produced by YYYYMMDD:
+// 44 BC is "-0043".
 func ParseDate(s string) (Date, error) {
-	m := dateRegex.FindStringSubmatch(s)
+	m := dateRegex.FindStringSubmatch(strings.TrimSpace(s))
 	if len(m) != 4 {
 		return Date{}, errDateParse
 	}
@@ -36,9 +98,6 @@ func ParseDate(s string) (Date, error) {
 	if err != nil {
 		return Date{}, errDateParse
 	}
-	if y <= 0 {
-		return Date{}, errDateParse
-	}
 	if mon < 1 || mon > 12 {
 		return Date{}, errDateParse
 	}
@@ -57,25 +116,84 @@ func TimeDate(t time.Time) Date {
 	return Date{Y: t.Year(), M: t.Month(), D: t.Day()}
 }
 
+// Since returns the number of days from other to d.
+// The two dates may be in different calendars.
 func (d Date) Since(other Date) int {
-	t1 := time.Date(d.Y, d.M, d.D, 0, 0, 0, 0, time.Local)
-	t2 := time.Date(other.Y, other.M, other.D, 0, 0, 0, 0, time.Local)
-	return int(t1.Sub(t2) / (24 * time.Hour))
+	return d.JDN() - other.JDN()
 }
 
 // Returns years and days from other to d.
+// If the dates are in different calendars,
+// other is first converted to the calendar of d.
 // Note: other must be on or before d.
 func (d Date) YDSince(other Date) (years, days int) {
+	other = other.In(d.Cal)
 	years = d.Y - other.Y
 	if other.M > d.M || (other.M == d.M && other.D > d.D) {
 		years--
-		days = 1 + Date{other.Y, 12, 31}.Since(other) + d.Since(Date{d.Y, 1, 1})
+		days = 1 + Date{Y: other.Y, M: 12, D: 31, Cal: d.Cal}.Since(other) + d.Since(Date{Y: d.Y, M: 1, D: 1, Cal: d.Cal})
 	} else {
-		days = d.Since(Date{d.Y, other.M, other.D})
+		days = d.Since(Date{Y: d.Y, M: other.M, D: other.D, Cal: d.Cal})
 	}
 	return years, days
 }
 
+// JDN returns the Julian day number of d:
+// the number of days since 1 January 4713 BC in the Julian calendar.
+// It is the same for a given day regardless of the calendar it's expressed in.
+// See https://en.wikipedia.org/wiki/Julian_day#Converting_Gregorian_calendar_date_to_Julian_Day_Number.
+func (d Date) JDN() int {
+	var (
+		a = (14 - int(d.M)) / 12
+		y = d.Y + 4800 - a
+		m = int(d.M) + 12*a - 3
+	)
+	if d.Cal == Julian {
+		return d.D + (153*m+2)/5 + 365*y + y/4 - 32083
+	}
+	return d.D + (153*m+2)/5 + 365*y + y/4 - y/100 + y/400 - 32045
+}
+
+// JDNDate is the inverse of Date.JDN:
+// it produces the date in the given calendar with the given Julian day number.
+// See https://en.wikipedia.org/wiki/Julian_day#Julian_or_Gregorian_calendar_from_Julian_day_number.
+func JDNDate(jdn int, cal Calendar) Date {
+	var b, c int
+	if cal == Julian {
+		c = jdn + 32082
+	} else {
+		a := jdn + 32044
+		b = (4*a + 3) / 146097
+		c = a - 146097*b/4
+	}
+	var (
+		d = (4*c + 3) / 1461
+		e = c - 1461*d/4
+		m = (5*e + 2) / 153
+	)
+	return Date{
+		Y:   100*b + d - 4800 + m/10,
+		M:   time.Month(m + 3 - 12*(m/10)),
+		D:   e - (153*m+2)/5 + 1,
+		Cal: cal,
+	}
+}
+
+// AddDays returns the date n days after d
+// (or before, if n is negative),
+// in the same calendar.
+func (d Date) AddDays(n int) Date {
+	return JDNDate(d.JDN()+n, d.Cal)
+}
+
+// In converts d to the given calendar.
+func (d Date) In(cal Calendar) Date {
+	if d.Cal == cal {
+		return d
+	}
+	return JDNDate(d.JDN(), cal)
+}
+
 func (d Date) YDSinceStr(other Date) string {
 	years, days := d.YDSince(other)
 	if years == 0 && days == 0 {
@@ -105,32 +223,75 @@ func (d Date) YDSinceStr(other Date) string {
 	return fmt.Sprintf("%d years, %d days", years, days)
 }
 
+// String formats d as, e.g., "22 Feb 1732",
+// or less precisely, e.g. "Feb 1732", "1732", or "1730s",
+// with "c. " in front if d is circa.
+// A Julian date on or after the start of the Gregorian calendar
+// is marked "O.S." (Old Style).
 func (d Date) String() string {
-	m := d.M.String()
-	if d.Y > 0 {
-		return fmt.Sprintf("%d %s %d", d.D, m[:3], d.Y)
+	var circa, os string
+	if d.Circa {
+		circa = "c. "
 	}
-	return fmt.Sprintf("%d %s %d BC", d.D, m[:3], -d.Y)
+	if d.Cal == Julian && d.Since(GregorianStart) >= 0 {
+		os = " O.S."
+	}
+
+	year := strconv.Itoa(d.Y)
+	if d.Y <= 0 {
+		year = strconv.Itoa(1-d.Y) + " BC"
+	}
+	if d.Prec == DecadePrecision {
+		if d.Y > 0 {
+			year = strconv.Itoa(d.Y) + "s"
+		} else {
+			// The earliest year of the 40s BC is 49 BC.
+			year = strconv.Itoa(1-d.Y-9) + "s BC"
+		}
+	}
+
+	m := d.M.String()[:3]
+	switch d.Prec {
+	case MonthPrecision:
+		return fmt.Sprintf("%s%s %s%s", circa, m, year, os)
+	case YearPrecision, DecadePrecision:
+		return fmt.Sprintf("%s%s%s", circa, year, os)
+	}
+	return fmt.Sprintf("%s%d %s %s%s", circa, d.D, m, year, os)
 }
 
+// YYYYMMDD formats d in ISO 8601 style,
+// with an astronomical year of at least four digits,
+// signed if it is before 1 BC (year 0).
+// E.g. 15 Mar 44 BC is "-0043-03-15".
 func (d Date) YYYYMMDD() string {
-	return fmt.Sprintf("%d-%02d-%02d", d.Y, d.M, d.D)
+	if d.Y < 0 {
+		return fmt.Sprintf("-%04d-%02d-%02d", -d.Y, d.M, d.D)
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", d.Y, d.M, d.D)
 }
 
 func daysInMonth(y int, m time.Month) int {
+	return Gregorian.daysInMonth(y, m)
+}
+
+func (c Calendar) daysInMonth(y int, m time.Month) int {
 	switch m {
 	case 1, 3, 5, 7, 8, 10, 12:
 		return 31
 	case 4, 6, 9, 11:
 		return 30
 	}
-	if isLeapYear(y) {
+	if c.isLeapYear(y) {
 		return 29
 	}
 	return 28
 }
 
-func isLeapYear(y int) bool {
+func (c Calendar) isLeapYear(y int) bool {
+	if c == Julian {
+		return y%4 == 0
+	}
 	if y%400 == 0 {
 		return true
 	}
@@ -27,7 +27,7 @@ func TestDate(t *testing.T) {
 		{1904, 2, 1, 1904, 3, 1, 29, &yd{0, 29}},
 		{2000, 2, 1, 2000, 3, 1, 29, &yd{0, 29}},
 		{1966, 10, 22, 1969, 10, 28, 1102, &yd{3, 6}},
-		{1966, 10, 22, 1977, 8, 5, 3940, &yd{10, 286}},
+		{1966, 10, 22, 1977, 8, 5, 3940, &yd{10, 287}},
 		{2004, 4, 10, 2015, 1, 23, 3940, &yd{10, 288}},
 	}
 	for i, c := range cases {
@@ -48,3 +48,191 @@ func TestDate(t *testing.T) {
 		})
 	}
 }
+
+func TestCalendar(t *testing.T) {
+	cases := []struct {
+		d       Date
+		wantJDN int
+		wantG   Date
+		wantJ   Date
+	}{
+		{
+			d:       Date{Y: 2000, M: time.January, D: 1},
+			wantJDN: 2451545,
+			wantG:   Date{Y: 2000, M: time.January, D: 1},
+			wantJ:   Date{Y: 1999, M: time.December, D: 19, Cal: Julian},
+		},
+		{
+			d:       GregorianStart,
+			wantJDN: 2299161,
+			wantG:   GregorianStart,
+			wantJ:   Date{Y: 1582, M: time.October, D: 5, Cal: Julian},
+		},
+		{
+			// George Washington's birthday, O.S.
+			d:       Date{Y: 1732, M: time.February, D: 11, Cal: Julian},
+			wantJDN: 2353712,
+			wantG:   Date{Y: 1732, M: time.February, D: 22},
+			wantJ:   Date{Y: 1732, M: time.February, D: 11, Cal: Julian},
+		},
+		{
+			// A leap day in the Julian calendar only.
+			d:       Date{Y: 1700, M: time.February, D: 29, Cal: Julian},
+			wantJDN: 2342042,
+			wantG:   Date{Y: 1700, M: time.March, D: 11},
+			wantJ:   Date{Y: 1700, M: time.February, D: 29, Cal: Julian},
+		},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case_%d", i+1), func(t *testing.T) {
+			if got := c.d.JDN(); got != c.wantJDN {
+				t.Errorf("got JDN %d, want %d", got, c.wantJDN)
+			}
+			if got := c.d.In(Gregorian); got != c.wantG {
+				t.Errorf("got Gregorian %s, want %s", got, c.wantG)
+			}
+			if got := c.d.In(Julian); got != c.wantJ {
+				t.Errorf("got Julian %s, want %s", got, c.wantJ)
+			}
+			if got := JDNDate(c.wantJDN, c.d.Cal); got != c.d {
+				t.Errorf("got JDNDate %+v, want %+v", got, c.d)
+			}
+		})
+	}
+}
+
+func TestBC(t *testing.T) {
+	type yd struct{ y, d int }
+
+	cases := []struct {
+		born, died       Date
+		bornStr, diedStr string
+		wantDays         int
+		wantYD           yd
+	}{
+		{
+			born:     Date{Y: 0, M: time.December, D: 31},
+			died:     Date{Y: 1, M: time.January, D: 1},
+			bornStr:  "31 Dec 1 BC",
+			diedStr:  "1 Jan 1",
+			wantDays: 1,
+			wantYD:   yd{0, 1},
+		},
+		{
+			// 1 BC is a leap year.
+			born:     Date{Y: 0, M: time.January, D: 1},
+			died:     Date{Y: 1, M: time.January, D: 1},
+			bornStr:  "1 Jan 1 BC",
+			diedStr:  "1 Jan 1",
+			wantDays: 366,
+			wantYD:   yd{1, 0},
+		},
+		{
+			born:     Date{Y: -1, M: time.March, D: 1},
+			died:     Date{Y: 2, M: time.March, D: 1},
+			bornStr:  "1 Mar 2 BC",
+			diedStr:  "1 Mar 2",
+			wantDays: 3*365 + 1,
+			wantYD:   yd{3, 0},
+		},
+		{
+			// Julius Caesar.
+			born:     Date{Y: -99, M: time.July, D: 12, Cal: Julian},
+			died:     Date{Y: -43, M: time.March, D: 15, Cal: Julian},
+			bornStr:  "12 Jul 100 BC",
+			diedStr:  "15 Mar 44 BC",
+			wantDays: 20335,
+			wantYD:   yd{55, 246},
+		},
+		{
+			// Augustus.
+			born:     Date{Y: -62, M: time.September, D: 23, Cal: Julian},
+			died:     Date{Y: 14, M: time.August, D: 19, Cal: Julian},
+			bornStr:  "23 Sep 63 BC",
+			diedStr:  "19 Aug 14",
+			wantDays: 75*365 + 19 + 330,
+			wantYD:   yd{75, 330},
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case_%d", i+1), func(t *testing.T) {
+			if got := c.born.String(); got != c.bornStr {
+				t.Errorf("got born %s, want %s", got, c.bornStr)
+			}
+			if got := c.died.String(); got != c.diedStr {
+				t.Errorf("got died %s, want %s", got, c.diedStr)
+			}
+			if got := c.died.Since(c.born); got != c.wantDays {
+				t.Errorf("got %d days, want %d", got, c.wantDays)
+			}
+			y, d := c.died.YDSince(c.born)
+			if y != c.wantYD.y || d != c.wantYD.d {
+				t.Errorf("got yd %d,%d, want %d,%d", y, d, c.wantYD.y, c.wantYD.d)
+			}
+		})
+	}
+}
+
+func TestYYYYMMDD(t *testing.T) {
+	cases := []struct {
+		d    Date
+		want string
+	}{
+		{d: Date{Y: 1966, M: time.October, D: 22}, want: "1966-10-22"},
+		{d: Date{Y: 476, M: time.September, D: 4}, want: "0476-09-04"},
+		{d: Date{Y: 1, M: time.January, D: 1}, want: "0001-01-01"},
+		{d: Date{Y: 0, M: time.February, D: 29}, want: "0000-02-29"},
+		{d: Date{Y: -43, M: time.March, D: 15}, want: "-0043-03-15"},
+		{d: Date{Y: -12345, M: time.June, D: 1}, want: "-12345-06-01"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.want, func(t *testing.T) {
+			got := c.d.YYYYMMDD()
+			if got != c.want {
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+			parsed, err := ParseDate(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed != c.d {
+				t.Errorf("parsed %s, want %s", parsed, c.d)
+			}
+		})
+	}
+}
+
+func TestDateString(t *testing.T) {
+	cases := []struct {
+		d    Date
+		want string
+	}{
+		{d: Date{Y: 1620, M: time.March, D: 1}, want: "1 Mar 1620"},
+		{d: Date{Y: 1732, M: time.February, D: 11, Cal: Julian}, want: "11 Feb 1732 O.S."},
+		{d: Date{Y: 1450, M: time.March, D: 1, Cal: Julian, Prec: MonthPrecision}, want: "Mar 1450"},
+		{d: Date{Y: 1450, M: time.January, D: 1, Cal: Julian, Prec: YearPrecision, Circa: true}, want: "c. 1450"},
+		{d: Date{Y: 1450, M: time.January, D: 1, Cal: Julian, Prec: DecadePrecision}, want: "1450s"},
+		{d: Date{Y: -48, M: time.January, D: 1, Cal: Julian, Prec: DecadePrecision}, want: "40s BC"},
+		{d: Date{Y: -43, M: time.March, D: 15, Cal: Julian, Circa: true}, want: "c. 15 Mar 44 BC"},
+	}
+	for _, c := range cases {
+		t.Run(c.want, func(t *testing.T) {
+			if got := c.d.String(); got != c.want {
+				t.Errorf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseDateJunk(t *testing.T) {
+	for _, s := range []string{"1966-10-22x", "x1966-10-22", "1966-10-22-01", "1966-10-22T00:00:00Z", "--1966-10-22"} {
+		if d, err := ParseDate(s); err == nil {
+			t.Errorf("ParseDate(%q) = %s, want error", s, d)
+		}
+	}
+	if _, err := ParseDate(" 1966-10-22\n"); err != nil {
+		t.Errorf("ParseDate with surrounding space: %s", err)
+	}
+}
@@ -17,15 +17,89 @@ type Figure struct {
 	// This also serves as the figure's unique datastore key.
 	Link string
 
-	Name, Desc     string
-	Born, Died     Date
-	DaysAlive      int
+	// QID is the figure's Wikidata item ID (e.g. Q42), if known.
+	QID string
+
+	Name, Desc string
+	Born, Died Date
+	DaysAlive  int
+
+	// DiedGregorian is Died converted to the Gregorian calendar.
+	// FiguresDiedOn uses it to match today's (Gregorian) date.
+	// It is set by FigureStore.ReplaceFigures.
+	DiedGregorian Date
+
 	Pageviews      int
 	ImgSrc, ImgAlt string
 
 	Updated time.Time
 }
 
+// Figures stored before Date.Y was an astronomical year
+// numbered years BC historically
+// (-44 for 44 BC, with no year 0).
+// Figures stored since are saved with this property,
+// and loading one without it converts its years
+// (see Figure.Load).
+// Running "outlived admin resave-figures" stores the converted figures,
+// which updates the DaysAlive and DiedGregorian values used in queries.
+const astroYearsProp = "AstroYears"
+
+// Load implements datastore.PropertyLoadSaver.
+func (f *Figure) Load(props []datastore.Property) error {
+	var (
+		astro bool
+		rest  = make([]datastore.Property, 0, len(props))
+	)
+	for _, p := range props {
+		if p.Name == astroYearsProp {
+			astro = true
+			continue
+		}
+		rest = append(rest, p)
+	}
+	err := datastore.LoadStruct(f, rest)
+	if err != nil {
+		return err
+	}
+	if !astro {
+		f.fromHistoricalYears()
+	}
+	return nil
+}
+
+// Save implements datastore.PropertyLoadSaver.
+func (f *Figure) Save() ([]datastore.Property, error) {
+	props, err := datastore.SaveStruct(f)
+	if err != nil {
+		return nil, err
+	}
+	return append(props, datastore.Property{Name: astroYearsProp, Value: true, NoIndex: true}), nil
+}
+
+// Function fromHistoricalYears converts f's years BC
+// from historical to astronomical numbering
+// (see astroYearsProp).
+func (f *Figure) fromHistoricalYears() {
+	if f.Born.Y >= 0 && f.Died.Y >= 0 {
+		return
+	}
+	if f.Born.Y < 0 {
+		f.Born.Y++
+	}
+	if f.Died.Y < 0 {
+		f.Died.Y++
+	}
+	f.DaysAlive = f.Died.Since(f.Born)
+	f.DiedGregorian = f.Died.In(Gregorian)
+}
+
+// Approx tells whether f's birth or death date is not exactly known,
+// in which case DaysAlive is only an estimate.
+func (f *Figure) Approx() bool {
+	return !f.Born.Exact() || !f.Died.Exact()
+}
+
 func (f *Figure) YDAge() string {
 	y, d := f.Died.YDSince(f.Born)
 
@@ -50,19 +124,153 @@ func (f *Figure) YDAge() string {
 	return fmt.Sprintf("%s, %s", ystr, dstr)
 }
 
-func FiguresAliveFor(ctx context.Context, client *datastore.Client, days, limit int) ([]*Figure, error) {
-	q := datastore.NewQuery("Figure").Filter("DaysAlive =", days).Order("-Pageviews")
-	if limit > 0 {
-		q = q.Limit(limit)
+// Outliving is a figure
+// and the date on which someone outlives them.
+type Outliving struct {
+	Figure *Figure
+	Date   Date
+}
+
+// UpcomingOutlivings returns the next n figures
+// that someone born on the given date will outlive
+// after today but within the given number of days,
+// in chronological order
+// (and, among figures outlived on the same day, in descending order of pageviews).
+// On each Outliving's Date,
+// the person will have been alive one day longer than the figure.
+func UpcomingOutlivings(ctx context.Context, store FigureStore, born, today Date, days, n int) ([]Outliving, error) {
+	alive := today.Since(born)
+
+	// Someone alive for `alive` days today
+	// outlives a figure alive for `alive` days tomorrow.
+	figures, err := store.FiguresAliveForBetween(ctx, alive, alive+days-1, n)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting figures")
 	}
-	var figures []*Figure
-	_, err := client.GetAll(ctx, q, &figures)
-	return figures, errors.Wrap(err, "querying figures")
+
+	result := make([]Outliving, 0, len(figures))
+	for _, fig := range figures {
+		result = append(result, Outliving{
+			Figure: fig,
+			Date:   born.AddDays(fig.DaysAlive + 1),
+		})
+	}
+	return result, nil
+}
+
+// PopularOutlivings returns up to n of the most popular figures
+// (by pageviews)
+// that someone born on the given date will outlive
+// after today but within the given number of days,
+// in chronological order
+// (and, among figures outlived on the same day, in descending order of pageviews).
+// Unlike UpcomingOutlivings,
+// it prefers a popular figure late in the period
+// to a less popular one sooner.
+func PopularOutlivings(ctx context.Context, store FigureStore, born, today Date, days, n int) ([]Outliving, error) {
+	alive := today.Since(born)
+	figures, err := store.PopularFiguresAliveForBetween(ctx, alive, alive+days-1, n)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting figures")
+	}
+
+	result := make([]Outliving, 0, len(figures))
+	for _, fig := range figures {
+		result = append(result, Outliving{
+			Figure: fig,
+			Date:   born.AddDays(fig.DaysAlive + 1),
+		})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Figure.DaysAlive < result[j].Figure.DaysAlive
+	})
+	return result, nil
+}
+
+// FigureStore is a place where figures are stored and queried.
+type FigureStore interface {
+	// FiguresAliveFor returns figures that lived exactly the given number of days,
+	// in descending order of pageviews.
+	// Figures whose DaysAlive is only approximate (see Figure.Approx) are excluded.
+	// A limit of 0 means no limit.
+	FiguresAliveFor(ctx context.Context, days, limit int) ([]*Figure, error)
+
+	// FiguresAliveForAtMost returns up to limit figures that lived at most the given number of days,
+	// preferring the longest-lived ones,
+	// in descending order of pageviews.
+	// Figures whose DaysAlive is only approximate are included.
+	FiguresAliveForAtMost(ctx context.Context, days, limit int) ([]*Figure, error)
+
+	// FiguresAliveForBetween returns up to limit figures that lived between minDays and maxDays (inclusive),
+	// the shortest-lived first,
+	// and in descending order of pageviews among those with the same DaysAlive.
+	// Figures whose DaysAlive is only approximate are included.
+	// A limit of 0 means no limit.
+	FiguresAliveForBetween(ctx context.Context, minDays, maxDays, limit int) ([]*Figure, error)
+
+	// PopularFiguresAliveForBetween returns up to limit of the figures with the most pageviews
+	// that lived between minDays and maxDays (inclusive),
+	// in descending order of pageviews.
+	// Figures whose DaysAlive is only approximate are included.
+	// A limit of 0 means no limit.
+	PopularFiguresAliveForBetween(ctx context.Context, minDays, maxDays, limit int) ([]*Figure, error)
+
+	// FiguresDiedOn returns figures that died on the given month and day
+	// of the Gregorian calendar,
+	// in descending order of pageviews.
+	// Figures whose death date is not exactly known are excluded.
+	// A limit of 0 means no limit.
+	FiguresDiedOn(ctx context.Context, mon time.Month, day int, limit int) ([]*Figure, error)
+
+	// ReplaceFigures adds figures to the store,
+	// replacing any existing ones with the same Link.
+	// It sets the DiedGregorian field of each figure.
+	ReplaceFigures(ctx context.Context, figures []*Figure) error
+
+	// ExpireFigures removes figures that have not been updated recently.
+	// It returns the number of figures removed.
+	ExpireFigures(ctx context.Context) (int, error)
+
+	// LifespanStats summarizes the DaysAlive of all figures.
+	LifespanStats(ctx context.Context) (*LifespanStats, error)
 }
 
-func FiguresAliveForAtMost(ctx context.Context, client *datastore.Client, days, limit int) ([]*Figure, error) {
+// LifespanStats summarizes the lifespans of a set of figures.
+type LifespanStats struct {
+	Count        int
+	Mean, Median int // days
+}
+
+// Function lifespanStats computes LifespanStats from a list of DaysAlive values.
+// It sorts days.
+func lifespanStats(days []int) *LifespanStats {
+	result := &LifespanStats{Count: len(days)}
+	if len(days) == 0 {
+		return result
+	}
+	sort.Ints(days)
+	var sum int
+	for _, d := range days {
+		sum += d
+	}
+	result.Mean = (sum + len(days)/2) / len(days)
+	result.Median = days[(len(days)-1)/2]
+	return result
+}
+
+// DSFigureStore is a FigureStore in Google Cloud Datastore.
+type DSFigureStore datastore.Client
+
+var _ FigureStore = (*DSFigureStore)(nil)
+
+func (s *DSFigureStore) FiguresAliveFor(ctx context.Context, days, limit int) ([]*Figure, error) {
+	q := datastore.NewQuery("Figure").Filter("DaysAlive =", days).Order("-Pageviews")
+	return s.filter(ctx, q, limit, func(fig *Figure) bool { return !fig.Approx() })
+}
+
+func (s *DSFigureStore) FiguresAliveForAtMost(ctx context.Context, days, limit int) ([]*Figure, error) {
 	q := datastore.NewQuery("Figure").Filter("DaysAlive <=", days).Order("-DaysAlive").Order("-Pageviews")
-	it := client.Run(ctx, q)
+	it := (*datastore.Client)(s).Run(ctx, q)
 	var figures []*Figure
 	for len(figures) < limit {
 		var fig Figure
@@ -75,38 +283,108 @@ func FiguresAliveForAtMost(ctx context.Context, client *datastore.Client, days,
 		}
 		figures = append(figures, &fig)
 	}
-	sort.Slice(figures, func(i, j int) bool {
-		return figures[i].Pageviews > figures[j].Pageviews
-	})
+	sortByPageviews(figures)
 	return figures, nil
 }
 
-func FiguresDiedOn(ctx context.Context, client *datastore.Client, mon time.Month, day int, limit int) ([]*Figure, error) {
-	q := datastore.NewQuery("Figure").Filter("Died.M =", int(mon)).Filter("Died.D =", day).Order("-Pageviews")
+func (s *DSFigureStore) FiguresAliveForBetween(ctx context.Context, minDays, maxDays, limit int) ([]*Figure, error) {
+	q := datastore.NewQuery("Figure").Filter("DaysAlive >=", minDays).Filter("DaysAlive <=", maxDays).Order("DaysAlive").Order("-Pageviews")
 	if limit > 0 {
 		q = q.Limit(limit)
 	}
 	var figures []*Figure
-	_, err := client.GetAll(ctx, q, &figures)
+	_, err := (*datastore.Client)(s).GetAll(ctx, q, &figures)
 	return figures, errors.Wrap(err, "querying figures")
 }
 
-const multiLimit = 500
+func (s *DSFigureStore) PopularFiguresAliveForBetween(ctx context.Context, minDays, maxDays, limit int) ([]*Figure, error) {
+	client := (*datastore.Client)(s)
 
-func ReplaceFigures(ctx context.Context, client *datastore.Client, figures []*Figure) error {
-	// Remove duplicates from figures.
-	var (
-		seen    = make(map[string]struct{})
-		deduped []*Figure
-	)
-	for _, fig := range figures {
-		if _, ok := seen[fig.Link]; ok {
-			continue
+	// The most popular figures can be anywhere in the range,
+	// so scan it projecting only DaysAlive and Pageviews
+	// (from the same index as FiguresAliveForBetween),
+	// then get the chosen figures in full.
+	q := datastore.NewQuery("Figure").Filter("DaysAlive >=", minDays).Filter("DaysAlive <=", maxDays).Order("DaysAlive").Order("-Pageviews")
+	q = q.Project("DaysAlive", "Pageviews")
+	var projected []*Figure
+	keys, err := client.GetAll(ctx, q, &projected)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying figures")
+	}
+
+	idx := make([]int, len(keys))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return projected[idx[i]].Pageviews > projected[idx[j]].Pageviews
+	})
+	if limit > 0 && limit < len(idx) {
+		idx = idx[:limit]
+	}
+
+	figures := make([]*Figure, 0, len(idx))
+	for len(idx) > 0 {
+		var next []int
+		if len(idx) > MultiLimit {
+			idx, next = idx[:MultiLimit], idx[MultiLimit:]
 		}
-		seen[fig.Link] = struct{}{}
-		deduped = append(deduped, fig)
+		var (
+			batchKeys = make([]*datastore.Key, len(idx))
+			batch     = make([]*Figure, len(idx))
+		)
+		for i, j := range idx {
+			batchKeys[i] = keys[j]
+			batch[i] = new(Figure)
+		}
+		err = client.GetMulti(ctx, batchKeys, batch)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting figures")
+		}
+		figures = append(figures, batch...)
+		idx = next
+	}
+	return figures, nil
+}
+
+func (s *DSFigureStore) FiguresDiedOn(ctx context.Context, mon time.Month, day int, limit int) ([]*Figure, error) {
+	q := datastore.NewQuery("Figure").Filter("DiedGregorian.M =", int(mon)).Filter("DiedGregorian.D =", day).Order("-Pageviews")
+	return s.filter(ctx, q, limit, func(fig *Figure) bool { return fig.Died.Exact() })
+}
+
+// Function filter runs q and returns up to limit of the resulting figures for which keep is true.
+// (Precision is not indexed, so it can't be part of the query.)
+// A limit of 0 means no limit.
+func (s *DSFigureStore) filter(ctx context.Context, q *datastore.Query, limit int, keep func(*Figure) bool) ([]*Figure, error) {
+	it := (*datastore.Client)(s).Run(ctx, q)
+	var figures []*Figure
+	for limit == 0 || len(figures) < limit {
+		var fig Figure
+		_, err := it.Next(&fig)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "querying figures")
+		}
+		if keep(&fig) {
+			figures = append(figures, &fig)
+		}
+	}
+	return figures, nil
+}
+
+// MultiLimit is the datastore's limit on the number of entities
+// in one multi-operation (e.g. PutMulti or DeleteMulti).
+const MultiLimit = 500
+
+func (s *DSFigureStore) ReplaceFigures(ctx context.Context, figures []*Figure) error {
+	client := (*datastore.Client)(s)
+
+	figures = dedupFigures(figures)
+	for _, fig := range figures {
+		fig.DiedGregorian = fig.Died.In(Gregorian)
 	}
-	figures = deduped
 
 	// TODO(bobg): At least in testing mode, this call to Count (apparently) never returns.
 	// before, err := client.Count(ctx, allQ)
@@ -123,9 +401,9 @@ func ReplaceFigures(ctx context.Context, client *datastore.Client, figures []*Fi
 			nextKeys []*datastore.Key
 			nextFigs []*Figure
 		)
-		if len(figures) > multiLimit {
-			keys, nextKeys = keys[:multiLimit], keys[multiLimit:]
-			figures, nextFigs = figures[:multiLimit], figures[multiLimit:]
+		if len(figures) > MultiLimit {
+			keys, nextKeys = keys[:MultiLimit], keys[MultiLimit:]
+			figures, nextFigs = figures[:MultiLimit], figures[MultiLimit:]
 		}
 		_, err := client.PutMulti(ctx, keys, figures)
 		if err != nil {
@@ -139,7 +417,9 @@ func ReplaceFigures(ctx context.Context, client *datastore.Client, figures []*Fi
 
 const stale = 30 * 24 * time.Hour
 
-func ExpireFigures(ctx context.Context, client *datastore.Client) (int, error) {
+func (s *DSFigureStore) ExpireFigures(ctx context.Context) (int, error) {
+	client := (*datastore.Client)(s)
+
 	q := datastore.NewQuery("Figure")
 	q = q.Filter("Updated <", time.Now().Add(-stale)).KeysOnly()
 	keys, err := client.GetAll(ctx, q, nil)
@@ -152,8 +432,8 @@ func ExpireFigures(ctx context.Context, client *datastore.Client) (int, error) {
 	for len(keys) > 0 {
 		var nextKeys []*datastore.Key
 
-		if len(keys) > multiLimit {
-			keys, nextKeys = keys[:multiLimit], keys[multiLimit:]
+		if len(keys) > MultiLimit {
+			keys, nextKeys = keys[:MultiLimit], keys[MultiLimit:]
 		}
 		err = client.DeleteMulti(ctx, keys)
 		if err != nil {
@@ -164,3 +444,54 @@ func ExpireFigures(ctx context.Context, client *datastore.Client) (int, error) {
 	}
 	return count, nil
 }
+
+func (s *DSFigureStore) LifespanStats(ctx context.Context) (*LifespanStats, error) {
+	client := (*datastore.Client)(s)
+
+	q := datastore.NewQuery("Figure").Project("DaysAlive")
+	it := client.Run(ctx, q)
+
+	var days []int
+	for {
+		var fig Figure
+		_, err := it.Next(&fig)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "iterating over figures")
+		}
+		days = append(days, fig.DaysAlive)
+	}
+	return lifespanStats(days), nil
+}
+
+// Function dedupFigures removes figures with duplicate Links,
+// keeping the first of each.
+func dedupFigures(figures []*Figure) []*Figure {
+	var (
+		seen    = make(map[string]struct{})
+		deduped []*Figure
+	)
+	for _, fig := range figures {
+		if _, ok := seen[fig.Link]; ok {
+			continue
+		}
+		seen[fig.Link] = struct{}{}
+		deduped = append(deduped, fig)
+	}
+	return deduped
+}
+
+func sortByPageviews(figures []*Figure) {
+	sort.SliceStable(figures, func(i, j int) bool {
+		return figures[i].Pageviews > figures[j].Pageviews
+	})
+}
+
+func limitFigures(figures []*Figure, limit int) []*Figure {
+	if limit > 0 && len(figures) > limit {
+		return figures[:limit]
+	}
+	return figures
+}
@@ -0,0 +1,216 @@
+package outlived
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"outlived/memds"
+)
+
+func TestUpcomingOutlivings(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := OpenFileFigureStore(filepath.Join(t.TempDir(), "figures.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		born  = Date{Y: 2000, M: time.January, D: 1}
+		today = Date{Y: 2000, M: time.February, D: 1} // 31 days later
+	)
+
+	err = s.ReplaceFigures(ctx, []*Figure{
+		{Link: "outlived-today", DaysAlive: 30, Pageviews: 100},
+		{Link: "tomorrow", DaysAlive: 31, Pageviews: 10},
+		{Link: "tomorrow-popular", DaysAlive: 31, Pageviews: 20},
+		{Link: "in-a-week", DaysAlive: 37, Pageviews: 30},
+		{Link: "in-a-week-unpopular", DaysAlive: 37, Pageviews: 1},
+		{Link: "too-late", DaysAlive: 38, Pageviews: 100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := UpcomingOutlivings(ctx, s, born, today, 7, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		link string
+		date Date
+	}
+	var results []result
+	for _, o := range got {
+		results = append(results, result{link: o.Figure.Link, date: o.Date})
+	}
+	want := []result{
+		{link: "tomorrow-popular", date: Date{Y: 2000, M: time.February, D: 2}},
+		{link: "tomorrow", date: Date{Y: 2000, M: time.February, D: 2}},
+		{link: "in-a-week", date: Date{Y: 2000, M: time.February, D: 8}},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %v, want %v", results, want)
+	}
+
+	// The limit keeps the soonest figures, not the most popular ones.
+	got, err = UpcomingOutlivings(ctx, s, born, today, 7, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links []string
+	for _, o := range got {
+		links = append(links, o.Figure.Link)
+	}
+	if want := []string{"tomorrow-popular", "tomorrow"}; !reflect.DeepEqual(links, want) {
+		t.Errorf("with limit 2: got %v, want %v", links, want)
+	}
+}
+
+func TestPopularOutlivings(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fs, err := OpenFileFigureStore(filepath.Join(t.TempDir(), "figures.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := memds.NewClient(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var (
+		born  = Date{Y: 2000, M: time.January, D: 1}
+		today = Date{Y: 2000, M: time.February, D: 1} // 31 days later
+	)
+
+	stores := map[string]FigureStore{
+		"file":      fs,
+		"datastore": (*DSFigureStore)(client),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			err := s.ReplaceFigures(ctx, []*Figure{
+				{Link: "outlived-today", DaysAlive: 30, Pageviews: 10000},
+				{Link: "tomorrow", DaysAlive: 31, Pageviews: 10},
+				{Link: "tomorrow-popular", DaysAlive: 31, Pageviews: 20},
+				{Link: "in-ten-days", DaysAlive: 40, Pageviews: 5},
+				{Link: "in-fifty-days", DaysAlive: 80, Pageviews: 1000},
+				{Link: "too-late", DaysAlive: 91, Pageviews: 5000},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			type result struct {
+				link string
+				date Date
+			}
+			check := func(n int, want []result) {
+				t.Helper()
+				got, err := PopularOutlivings(ctx, s, born, today, 60, n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var results []result
+				for _, o := range got {
+					results = append(results, result{link: o.Figure.Link, date: o.Date})
+				}
+				if !reflect.DeepEqual(results, want) {
+					t.Errorf("with limit %d: got %v, want %v", n, results, want)
+				}
+			}
+
+			// The popular figure late in the period
+			// is chosen over the less popular ones sooner,
+			// but still comes in date order.
+			check(2, []result{
+				{link: "tomorrow-popular", date: Date{Y: 2000, M: time.February, D: 2}},
+				{link: "in-fifty-days", date: Date{Y: 2000, M: time.March, D: 22}},
+			})
+			check(10, []result{
+				{link: "tomorrow-popular", date: Date{Y: 2000, M: time.February, D: 2}},
+				{link: "tomorrow", date: Date{Y: 2000, M: time.February, D: 2}},
+				{link: "in-ten-days", date: Date{Y: 2000, M: time.February, D: 11}},
+				{link: "in-fifty-days", date: Date{Y: 2000, M: time.March, D: 22}},
+			})
+		})
+	}
+}
+
+func TestFigureLoadHistoricalYears(t *testing.T) {
+	fig := &Figure{
+		Link:      "Julius_Caesar",
+		Born:      Date{Y: -99, M: time.July, D: 12, Cal: Julian},
+		Died:      Date{Y: -43, M: time.March, D: 15, Cal: Julian},
+		DaysAlive: 20335,
+	}
+	props, err := fig.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Figure
+	if err = got.Load(props); err != nil {
+		t.Fatal(err)
+	}
+	if got.Born != fig.Born || got.Died != fig.Died {
+		t.Errorf("got %s–%s, want %s–%s", got.Born, got.Died, fig.Born, fig.Died)
+	}
+
+	// A figure stored before years BC were astronomical.
+	old, err := datastore.SaveStruct(&Figure{
+		Link:      "Julius_Caesar",
+		Born:      Date{Y: -100, M: time.July, D: 12, Cal: Julian},
+		Died:      Date{Y: -44, M: time.March, D: 15, Cal: Julian},
+		DaysAlive: 20334,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = Figure{}
+	if err = got.Load(old); err != nil {
+		t.Fatal(err)
+	}
+	if got.Born != fig.Born || got.Died != fig.Died || got.DaysAlive != fig.DaysAlive {
+		t.Errorf("got %s–%s (%d days), want %s–%s (%d days)", got.Born, got.Died, got.DaysAlive, fig.Born, fig.Died, fig.DaysAlive)
+	}
+	if want := fig.Died.In(Gregorian); got.DiedGregorian != want {
+		t.Errorf("got DiedGregorian %s, want %s", got.DiedGregorian, want)
+	}
+}
+
+func TestDSFigureStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := memds.NewClient(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	s := (*DSFigureStore)(client)
+	err = s.ReplaceFigures(ctx, storeTestFigures())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFigureStore(t, s)
+
+	n, err := s.ExpireFigures(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expired %d figure(s), want 1", n)
+	}
+	checkExpired(t, s)
+}
@@ -0,0 +1,213 @@
+package outlived
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FileFigureStore is a FigureStore kept in memory
+// and saved as JSON to a single file on every update.
+// It is meant for testing and development,
+// where together with package memds
+// (for everything else the site stores)
+// it removes the need for the Cloud Datastore emulator.
+type FileFigureStore struct {
+	path string
+
+	mu      sync.Mutex // protects figures
+	figures map[string]*Figure
+}
+
+var _ FigureStore = (*FileFigureStore)(nil)
+
+// OpenFileFigureStore opens the FileFigureStore at the given path,
+// loading any figures already saved there.
+// The file is created on the first update if it does not exist.
+func OpenFileFigureStore(path string) (*FileFigureStore, error) {
+	s := &FileFigureStore{
+		path:    path,
+		figures: make(map[string]*Figure),
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", path)
+	}
+	defer f.Close()
+
+	var figures []fileFigure
+	err = json.NewDecoder(f).Decode(&figures)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding %s", path)
+	}
+	for _, ff := range figures {
+		fig := ff.Figure
+		if !ff.AstroYears {
+			// Saved before years BC were astronomical.
+			fig.fromHistoricalYears()
+		}
+		// Figures saved before DiedGregorian existed lack it.
+		fig.DiedGregorian = fig.Died.In(Gregorian)
+		s.figures[fig.Link] = fig
+	}
+
+	return s, nil
+}
+
+func (s *FileFigureStore) FiguresAliveFor(ctx context.Context, days, limit int) ([]*Figure, error) {
+	figures := s.find(func(fig *Figure) bool { return fig.DaysAlive == days && !fig.Approx() })
+	sortByPageviews(figures)
+	return limitFigures(figures, limit), nil
+}
+
+func (s *FileFigureStore) FiguresAliveForAtMost(ctx context.Context, days, limit int) ([]*Figure, error) {
+	figures := s.find(func(fig *Figure) bool { return fig.DaysAlive <= days })
+	sort.SliceStable(figures, func(i, j int) bool {
+		if figures[i].DaysAlive != figures[j].DaysAlive {
+			return figures[i].DaysAlive > figures[j].DaysAlive
+		}
+		return figures[i].Pageviews > figures[j].Pageviews
+	})
+	if len(figures) > limit {
+		figures = figures[:limit]
+	}
+	sortByPageviews(figures)
+	return figures, nil
+}
+
+func (s *FileFigureStore) FiguresAliveForBetween(ctx context.Context, minDays, maxDays, limit int) ([]*Figure, error) {
+	figures := s.find(func(fig *Figure) bool { return fig.DaysAlive >= minDays && fig.DaysAlive <= maxDays })
+	sortByPageviews(figures)
+	sort.SliceStable(figures, func(i, j int) bool {
+		return figures[i].DaysAlive < figures[j].DaysAlive
+	})
+	return limitFigures(figures, limit), nil
+}
+
+func (s *FileFigureStore) PopularFiguresAliveForBetween(ctx context.Context, minDays, maxDays, limit int) ([]*Figure, error) {
+	figures := s.find(func(fig *Figure) bool { return fig.DaysAlive >= minDays && fig.DaysAlive <= maxDays })
+	sortByPageviews(figures)
+	return limitFigures(figures, limit), nil
+}
+
+func (s *FileFigureStore) FiguresDiedOn(ctx context.Context, mon time.Month, day int, limit int) ([]*Figure, error) {
+	figures := s.find(func(fig *Figure) bool {
+		return fig.DiedGregorian.M == mon && fig.DiedGregorian.D == day && fig.Died.Exact()
+	})
+	sortByPageviews(figures)
+	return limitFigures(figures, limit), nil
+}
+
+func (s *FileFigureStore) ReplaceFigures(ctx context.Context, figures []*Figure) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, fig := range dedupFigures(figures) {
+		fig.DiedGregorian = fig.Died.In(Gregorian)
+		f := *fig
+		s.figures[fig.Link] = &f
+	}
+	return s.save()
+}
+
+func (s *FileFigureStore) ExpireFigures(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-stale)
+
+	count := 0
+	for link, fig := range s.figures {
+		if fig.Updated.Before(cutoff) {
+			delete(s.figures, link)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return count, s.save()
+}
+
+func (s *FileFigureStore) LifespanStats(ctx context.Context) (*LifespanStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days := make([]int, 0, len(s.figures))
+	for _, fig := range s.figures {
+		days = append(days, fig.DaysAlive)
+	}
+	return lifespanStats(days), nil
+}
+
+// Type fileFigure is a Figure as saved in a FileFigureStore,
+// marked (like a Figure in the datastore, see astroYearsProp)
+// as having astronomical years.
+type fileFigure struct {
+	*Figure
+	AstroYears bool `json:",omitempty"`
+}
+
+// Function find returns copies of the figures satisfying pred,
+// in a stable order (by Link).
+func (s *FileFigureStore) find(pred func(*Figure) bool) []*Figure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var figures []*Figure
+	for _, fig := range s.figures {
+		if pred(fig) {
+			f := *fig
+			figures = append(figures, &f)
+		}
+	}
+	sort.Slice(figures, func(i, j int) bool {
+		return figures[i].Link < figures[j].Link
+	})
+	return figures
+}
+
+// Function save writes the figures to a temporary file
+// and renames it into place,
+// so a crash never leaves a partially written store behind.
+// Callers must hold s.mu.
+func (s *FileFigureStore) save() error {
+	figures := make([]fileFigure, 0, len(s.figures))
+	for _, fig := range s.figures {
+		figures = append(figures, fileFigure{Figure: fig, AstroYears: true})
+	}
+	sort.Slice(figures, func(i, j int) bool {
+		return figures[i].Link < figures[j].Link
+	})
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	enc := json.NewEncoder(tmp)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(figures)
+	if err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "encoding figures")
+	}
+	err = tmp.Close()
+	if err != nil {
+		return errors.Wrap(err, "closing temporary file")
+	}
+
+	err = os.Rename(tmp.Name(), s.path)
+	return errors.Wrapf(err, "renaming temporary file to %s", s.path)
+}
@@ -0,0 +1,166 @@
+package outlived
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileFigureStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "figures.json")
+
+	s, err := OpenFileFigureStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.ReplaceFigures(ctx, storeTestFigures())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFigureStore(t, s)
+
+	// Reopen and make sure everything persisted.
+	s, err = OpenFileFigureStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFigureStore(t, s)
+
+	n, err := s.ExpireFigures(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expired %d figure(s), want 1", n)
+	}
+
+	s, err = OpenFileFigureStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExpired(t, s)
+}
+
+// Function storeTestFigures gives the figures
+// used to test FigureStore implementations
+// (with checkFigureStore and checkExpired).
+func storeTestFigures() []*Figure {
+	now := time.Now()
+	return []*Figure{
+		{Link: "a", DaysAlive: 100, Pageviews: 10, Died: Date{Y: 1900, M: time.March, D: 1}, Updated: now},
+		{Link: "b", DaysAlive: 100, Pageviews: 30, Died: Date{Y: 1910, M: time.March, D: 1}, Updated: now},
+		{Link: "c", DaysAlive: 99, Pageviews: 20, Died: Date{Y: 1920, M: time.March, D: 2}, Updated: now},
+		{Link: "d", DaysAlive: 98, Pageviews: 40, Died: Date{Y: 1930, M: time.March, D: 1}, Updated: now.Add(-2 * stale)},
+		{Link: "e", DaysAlive: 50, Pageviews: 5, Died: Date{Y: 1700, M: time.February, D: 19, Cal: Julian}, Updated: now},         // 1 Mar 1700 Gregorian
+		{Link: "f", DaysAlive: 100, Pageviews: 50, Born: Date{Y: 1850, M: time.January, D: 1, Prec: YearPrecision}, Updated: now}, // approximate
+		{Link: "g", DaysAlive: 97, Pageviews: 60, Died: Date{Y: 1940, M: time.March, D: 1, Prec: MonthPrecision}, Updated: now},   // approximate
+		{Link: "a", DaysAlive: 1, Pageviews: 1, Updated: now},                                                                     // duplicate, ignored
+	}
+}
+
+func figureLinks(figures []*Figure) []string {
+	var result []string
+	for _, fig := range figures {
+		result = append(result, fig.Link)
+	}
+	return result
+}
+
+func checkFigureStore(t *testing.T, s FigureStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	got, err := s.FiguresAliveFor(ctx, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "a"}; !reflect.DeepEqual(figureLinks(got), want) {
+		t.Errorf("FiguresAliveFor: got %v, want %v", figureLinks(got), want)
+	}
+
+	got, err = s.FiguresAliveForAtMost(ctx, 99, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"g", "d", "c"}; !reflect.DeepEqual(figureLinks(got), want) {
+		t.Errorf("FiguresAliveForAtMost: got %v, want %v", figureLinks(got), want)
+	}
+
+	got, err = s.FiguresDiedOn(ctx, time.March, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"d", "b"}; !reflect.DeepEqual(figureLinks(got), want) {
+		t.Errorf("FiguresDiedOn: got %v, want %v", figureLinks(got), want)
+	}
+
+	stats, err := s.LifespanStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (LifespanStats{Count: 7, Mean: 92, Median: 99}); *stats != want {
+		t.Errorf("LifespanStats: got %+v, want %+v", *stats, want)
+	}
+}
+
+// Function checkExpired checks the figures in s
+// after ExpireFigures has removed the stale one.
+func checkExpired(t *testing.T, s FigureStore) {
+	t.Helper()
+
+	got, err := s.FiguresDiedOn(context.Background(), time.March, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "a", "e"}; !reflect.DeepEqual(figureLinks(got), want) {
+		t.Errorf("after expiring: got %v, want %v", figureLinks(got), want)
+	}
+}
+
+func TestFileFigureStoreHistoricalYears(t *testing.T) {
+	// A file saved before years BC were astronomical.
+	path := filepath.Join(t.TempDir(), "figures.json")
+	err := os.WriteFile(path, []byte(`[{"Link": "Julius_Caesar", "Born": {"Y": -100, "M": 7, "D": 12, "Cal": 1}, "Died": {"Y": -44, "M": 3, "D": 15, "Cal": 1}, "DaysAlive": 20334}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(s *FileFigureStore) {
+		t.Helper()
+		got := s.find(func(*Figure) bool { return true })
+		if len(got) != 1 {
+			t.Fatalf("got %d figures, want 1", len(got))
+		}
+		fig := got[0]
+		if want := (Date{Y: -43, M: time.March, D: 15, Cal: Julian}); fig.Died != want {
+			t.Errorf("got died %s, want %s", fig.Died, want)
+		}
+		if want := (Date{Y: -99, M: time.July, D: 12, Cal: Julian}); fig.Born != want {
+			t.Errorf("got born %s, want %s", fig.Born, want)
+		}
+		if fig.DaysAlive != 20335 {
+			t.Errorf("got DaysAlive %d, want 20335", fig.DaysAlive)
+		}
+	}
+
+	s, err := OpenFileFigureStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(s)
+
+	// Saving marks the years as astronomical, so they are not converted again.
+	err = s.ReplaceFigures(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenFileFigureStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(s)
+}
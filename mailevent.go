@@ -0,0 +1,63 @@
+package outlived
+
+import (
+	"fmt"
+	"time"
+)
+
+// MailEvent is something that happened to mail sent to a user,
+// as reported by the mail service.
+// It is stored in the datastore as a child of the User,
+// making a history of such events.
+type MailEvent struct {
+	Time   time.Time
+	Type   MailEventType
+	Addr   string
+	Source string // e.g. "mailgun"
+	Detail string `datastore:",noindex"`
+}
+
+// MailEventType is the type of a MailEvent.
+type MailEventType string
+
+const (
+	// MailEventBounced means the user's address permanently rejected mail.
+	MailEventBounced MailEventType = "bounced"
+
+	// MailEventComplained means the user reported mail as spam.
+	MailEventComplained MailEventType = "complained"
+
+	// MailEventUnsubscribed means the user unsubscribed through the mail service.
+	MailEventUnsubscribed MailEventType = "unsubscribed"
+)
+
+// ParseMailEventType parses a MailEventType.
+func ParseMailEventType(s string) (MailEventType, error) {
+	switch t := MailEventType(s); t {
+	case MailEventBounced, MailEventComplained, MailEventUnsubscribed:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown mail event type %s", s)
+}
+
+// Apply updates u according to ev,
+// so that u gets no further mail.
+// A bounce makes u unverified,
+// so that mail resumes only once u verifies a working address.
+// Other events make u inactive.
+// It reports whether u changed.
+func (ev *MailEvent) Apply(u *User) bool {
+	switch ev.Type {
+	case MailEventBounced:
+		if u.Verified {
+			u.Verified = false
+			return true
+		}
+	case MailEventComplained, MailEventUnsubscribed:
+		if u.Active {
+			u.Active = false
+			return true
+		}
+	}
+	return false
+}
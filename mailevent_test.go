@@ -0,0 +1,47 @@
+package outlived
+
+import "testing"
+
+func TestMailEventApply(t *testing.T) {
+	cases := []struct {
+		typ                      MailEventType
+		verified, active         bool
+		wantVerified, wantActive bool
+		wantChanged              bool
+	}{
+		{typ: MailEventBounced, verified: true, active: true, wantVerified: false, wantActive: true, wantChanged: true},
+		{typ: MailEventBounced, verified: false, active: true, wantVerified: false, wantActive: true},
+		{typ: MailEventComplained, verified: true, active: true, wantVerified: true, wantActive: false, wantChanged: true},
+		{typ: MailEventUnsubscribed, verified: true, active: true, wantVerified: true, wantActive: false, wantChanged: true},
+		{typ: MailEventUnsubscribed, verified: true, active: false, wantVerified: true, wantActive: false},
+	}
+	for _, c := range cases {
+		t.Run(string(c.typ), func(t *testing.T) {
+			var u User
+			u.Verified, u.Active = c.verified, c.active
+			ev := &MailEvent{Type: c.typ}
+			changed := ev.Apply(&u)
+			if changed != c.wantChanged {
+				t.Errorf("got changed %v, want %v", changed, c.wantChanged)
+			}
+			if u.Verified != c.wantVerified || u.Active != c.wantActive {
+				t.Errorf("got verified %v active %v, want %v %v", u.Verified, u.Active, c.wantVerified, c.wantActive)
+			}
+		})
+	}
+}
+
+func TestParseMailEventType(t *testing.T) {
+	for _, s := range []string{"bounced", "complained", "unsubscribed"} {
+		typ, err := ParseMailEventType(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(typ) != s {
+			t.Errorf("got %s, want %s", typ, s)
+		}
+	}
+	if _, err := ParseMailEventType("delivered"); err == nil {
+		t.Error("got no error for unknown type")
+	}
+}
@@ -0,0 +1,540 @@
+// Package memds is an in-memory implementation of the Cloud Datastore service.
+//
+// It is meant for testing and development,
+// where it lets the site (and its tests) run
+// without Cloud Datastore or its emulator.
+// The ordinary datastore client talks to it over an in-process gRPC connection
+// (see NewClient),
+// so code using the datastore needs no changes to use it.
+package memds
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/datastore"
+	pb "cloud.google.com/go/datastore/apiv1/datastorepb"
+	"github.com/pkg/errors"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// Server is an in-memory datastore.
+// Everything in it is lost when the process exits.
+//
+// It supports lookups;
+// queries with filters, sort orders, ancestors, projections, distinct-on,
+// offsets, limits, and cursors;
+// count aggregations;
+// and transactions,
+// which fail with Aborted
+// (so that the client retries them)
+// when an entity they read changes before they commit.
+//
+// Like Cloud Datastore,
+// it ignores unindexed property values in queries
+// and rejects queries with inequality filters on more than one property,
+// or whose first sort order is not on the inequality property.
+// Unlike Cloud Datastore,
+// it does not require composite indexes,
+// and its cursors are positions in the result list,
+// which shift if matching entities are added or removed.
+type Server struct {
+	pb.UnimplementedDatastoreServer
+
+	mu       sync.Mutex
+	entities map[string]*entry // keyed by keyString
+	txns     map[string]*txn   // keyed by transaction ID
+	version  int64             // incremented on each commit
+	nextID   int64             // the last ID allocated
+	nextTxn  int64             // the last transaction ID issued
+}
+
+type entry struct {
+	entity  *pb.Entity
+	version int64
+}
+
+// Type txn is a transaction in progress.
+type txn struct {
+	// The versions of the entities read in this transaction,
+	// keyed by keyString,
+	// with 0 for entities that did not exist.
+	reads map[string]int64
+}
+
+// NewServer creates a new, empty Server.
+func NewServer() *Server {
+	return &Server{
+		entities: make(map[string]*entry),
+		txns:     make(map[string]*txn),
+	}
+}
+
+// NewClient creates a new, empty Server
+// and returns a datastore client connected to it.
+// The server stops when the context is canceled.
+func NewClient(ctx context.Context, projectID string) (*datastore.Client, error) {
+	return NewServer().Client(ctx, projectID)
+}
+
+const bufSize = 1 << 20
+
+// Client returns a datastore client connected to s
+// over an in-process gRPC connection.
+// The connection's server stops when the context is canceled.
+func (s *Server) Client(ctx context.Context, projectID string) (*datastore.Client, error) {
+	lis := bufconn.Listen(bufSize)
+	gs := grpc.NewServer()
+	pb.RegisterDatastoreServer(gs, s)
+	go gs.Serve(lis)
+	go func() {
+		<-ctx.Done()
+		gs.Stop()
+	}()
+
+	dial := func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}
+	conn, err := grpc.NewClient("passthrough:///memds", grpc.WithContextDialer(dial), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to in-memory datastore")
+	}
+	client, err := datastore.NewClient(ctx, projectID, option.WithGRPCConn(conn))
+	return client, errors.Wrap(err, "creating datastore client")
+}
+
+// Lookup implements pb.DatastoreServer.
+func (s *Server) Lookup(ctx context.Context, req *pb.LookupRequest) (*pb.LookupResponse, error) {
+	if req.PropertyMask != nil {
+		return nil, status.Error(codes.Unimplemented, "property masks are not supported")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, txnID, err := s.readTxn(req.ReadOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.LookupResponse{Transaction: txnID}
+	for _, key := range req.Keys {
+		if err := checkKey(key, false); err != nil {
+			return nil, err
+		}
+		ks := keyString(key)
+		e := s.entities[ks]
+		if t != nil {
+			t.reads[ks] = e.getVersion()
+		}
+		if e == nil {
+			resp.Missing = append(resp.Missing, &pb.EntityResult{Entity: &pb.Entity{Key: key}, Version: s.version})
+			continue
+		}
+		resp.Found = append(resp.Found, &pb.EntityResult{Entity: e.entity, Version: e.version})
+	}
+	return resp, nil
+}
+
+// RunQuery implements pb.DatastoreServer.
+func (s *Server) RunQuery(ctx context.Context, req *pb.RunQueryRequest) (*pb.RunQueryResponse, error) {
+	q := req.GetQuery()
+	if q == nil {
+		return nil, status.Error(codes.Unimplemented, "GQL queries are not supported")
+	}
+	if req.PropertyMask != nil || req.ExplainOptions != nil {
+		return nil, status.Error(codes.Unimplemented, "property masks and explain options are not supported")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, txnID, err := s.readTxn(req.ReadOptions)
+	if err != nil {
+		return nil, err
+	}
+	batch, err := s.query(req.PartitionId.GetNamespaceId(), q, t)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RunQueryResponse{Batch: batch, Query: q, Transaction: txnID}, nil
+}
+
+// RunAggregationQuery implements pb.DatastoreServer.
+// Only count aggregations are supported.
+func (s *Server) RunAggregationQuery(ctx context.Context, req *pb.RunAggregationQueryRequest) (*pb.RunAggregationQueryResponse, error) {
+	aq := req.GetAggregationQuery()
+	if aq == nil {
+		return nil, status.Error(codes.Unimplemented, "GQL queries are not supported")
+	}
+	q := aq.GetNestedQuery()
+	if q == nil {
+		return nil, status.Error(codes.InvalidArgument, "aggregation query has no nested query")
+	}
+	if req.ExplainOptions != nil {
+		return nil, status.Error(codes.Unimplemented, "explain options are not supported")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, txnID, err := s.readTxn(req.ReadOptions)
+	if err != nil {
+		return nil, err
+	}
+	batch, err := s.query(req.PartitionId.GetNamespaceId(), q, t)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &pb.AggregationResult{AggregateProperties: make(map[string]*pb.Value)}
+	for i, agg := range aq.Aggregations {
+		count := agg.GetCount()
+		if count == nil {
+			return nil, status.Error(codes.Unimplemented, "only count aggregations are supported")
+		}
+		n := int64(len(batch.EntityResults))
+		if upTo := count.UpTo; upTo != nil && n > upTo.Value {
+			n = upTo.Value
+		}
+		alias := agg.Alias
+		if alias == "" {
+			alias = "property_" + strconv.Itoa(i+1)
+		}
+		result.AggregateProperties[alias] = &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: n}}
+	}
+
+	return &pb.RunAggregationQueryResponse{
+		Batch: &pb.AggregationResultBatch{
+			AggregationResults: []*pb.AggregationResult{result},
+			MoreResults:        pb.QueryResultBatch_NO_MORE_RESULTS,
+		},
+		Query:       aq,
+		Transaction: txnID,
+	}, nil
+}
+
+// BeginTransaction implements pb.DatastoreServer.
+func (s *Server) BeginTransaction(ctx context.Context, req *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &pb.BeginTransactionResponse{Transaction: s.beginTxn()}, nil
+}
+
+// Commit implements pb.DatastoreServer.
+func (s *Server) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.Mode == pb.CommitRequest_TRANSACTIONAL {
+		if id := req.GetTransaction(); id != nil {
+			t, ok := s.txns[string(id)]
+			if !ok {
+				return nil, status.Error(codes.InvalidArgument, "unknown transaction")
+			}
+			delete(s.txns, string(id))
+			for ks, v := range t.reads {
+				if s.entities[ks].getVersion() != v {
+					return nil, status.Error(codes.Aborted, "too much contention on these datastore entities")
+				}
+			}
+		}
+	}
+
+	// Check all the mutations before applying any,
+	// so that a commit either succeeds or has no effect.
+	var (
+		pending = make(map[string]*entry) // nil for deleted entities
+		version = s.version + 1
+		results = make([]*pb.MutationResult, 0, len(req.Mutations))
+	)
+	current := func(ks string) *entry {
+		if e, ok := pending[ks]; ok {
+			return e
+		}
+		return s.entities[ks]
+	}
+
+	for _, m := range req.Mutations {
+		if m.ConflictDetectionStrategy != nil || m.PropertyMask != nil || len(m.PropertyTransforms) > 0 {
+			return nil, status.Error(codes.Unimplemented, "conflict detection, property masks, and property transforms are not supported")
+		}
+
+		var (
+			entity *pb.Entity
+			result = &pb.MutationResult{Version: version}
+		)
+		switch op := m.Operation.(type) {
+		case *pb.Mutation_Insert:
+			entity = op.Insert
+		case *pb.Mutation_Update:
+			entity = op.Update
+		case *pb.Mutation_Upsert:
+			entity = op.Upsert
+		case *pb.Mutation_Delete:
+			if err := checkKey(op.Delete, false); err != nil {
+				return nil, err
+			}
+			pending[keyString(op.Delete)] = nil
+			results = append(results, result)
+			continue
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unknown mutation type %T", m.Operation)
+		}
+
+		entity = proto.Clone(entity).(*pb.Entity)
+		_, isUpdate := m.Operation.(*pb.Mutation_Update)
+		if err := checkKey(entity.Key, !isUpdate); err != nil {
+			return nil, err
+		}
+		if incomplete(entity.Key) {
+			s.allocateID(entity.Key, current)
+			result.Key = entity.Key
+		}
+
+		ks := keyString(entity.Key)
+		switch m.Operation.(type) {
+		case *pb.Mutation_Insert:
+			if current(ks) != nil {
+				return nil, status.Errorf(codes.AlreadyExists, "entity already exists: %s", ks)
+			}
+		case *pb.Mutation_Update:
+			if current(ks) == nil {
+				return nil, status.Errorf(codes.NotFound, "no entity to update: %s", ks)
+			}
+		}
+		pending[ks] = &entry{entity: entity, version: version}
+		results = append(results, result)
+	}
+
+	s.version = version
+	for ks, e := range pending {
+		if e == nil {
+			delete(s.entities, ks)
+		} else {
+			s.entities[ks] = e
+		}
+	}
+
+	return &pb.CommitResponse{MutationResults: results}, nil
+}
+
+// Rollback implements pb.DatastoreServer.
+func (s *Server) Rollback(ctx context.Context, req *pb.RollbackRequest) (*pb.RollbackResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A failed commit has already discarded its transaction,
+	// so there is no error for an unknown one.
+	delete(s.txns, string(req.Transaction))
+	return &pb.RollbackResponse{}, nil
+}
+
+// AllocateIds implements pb.DatastoreServer.
+func (s *Server) AllocateIds(ctx context.Context, req *pb.AllocateIdsRequest) (*pb.AllocateIdsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := &pb.AllocateIdsResponse{}
+	for _, key := range req.Keys {
+		if err := checkKey(key, true); err != nil {
+			return nil, err
+		}
+		if !incomplete(key) {
+			return nil, status.Error(codes.InvalidArgument, "cannot allocate an ID for a complete key")
+		}
+		key = proto.Clone(key).(*pb.Key)
+		s.allocateID(key, func(ks string) *entry { return s.entities[ks] })
+		resp.Keys = append(resp.Keys, key)
+	}
+	return resp, nil
+}
+
+// ReserveIds implements pb.DatastoreServer.
+func (s *Server) ReserveIds(ctx context.Context, req *pb.ReserveIdsRequest) (*pb.ReserveIdsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range req.Keys {
+		if err := checkKey(key, false); err != nil {
+			return nil, err
+		}
+		if id := key.Path[len(key.Path)-1].GetId(); id > s.nextID {
+			s.nextID = id
+		}
+	}
+	return &pb.ReserveIdsResponse{}, nil
+}
+
+// Method readTxn gives the transaction (if any)
+// that a read with the given options belongs to,
+// beginning a new one if the options ask for it
+// (in which case it also returns the new transaction's ID).
+// The caller must hold s.mu.
+func (s *Server) readTxn(opts *pb.ReadOptions) (*txn, []byte, error) {
+	switch c := opts.GetConsistencyType().(type) {
+	case *pb.ReadOptions_Transaction:
+		t, ok := s.txns[string(c.Transaction)]
+		if !ok {
+			return nil, nil, status.Error(codes.InvalidArgument, "unknown transaction")
+		}
+		return t, nil, nil
+
+	case *pb.ReadOptions_NewTransaction:
+		id := s.beginTxn()
+		return s.txns[string(id)], id, nil
+
+	case *pb.ReadOptions_ReadTime:
+		return nil, nil, status.Error(codes.Unimplemented, "reads at a given time are not supported")
+	}
+	return nil, nil, nil
+}
+
+// Method beginTxn starts a transaction and returns its ID.
+// The caller must hold s.mu.
+func (s *Server) beginTxn() []byte {
+	s.nextTxn++
+	id := binary.BigEndian.AppendUint64(nil, uint64(s.nextTxn))
+	s.txns[string(id)] = &txn{reads: make(map[string]int64)}
+	return id
+}
+
+// Method allocateID sets the ID of the incomplete key
+// to one not already in use
+// (according to the given function, which gives the entry for a keyString).
+// The caller must hold s.mu.
+func (s *Server) allocateID(key *pb.Key, current func(string) *entry) {
+	last := key.Path[len(key.Path)-1]
+	for {
+		s.nextID++
+		last.IdType = &pb.Key_PathElement_Id{Id: s.nextID}
+		if current(keyString(key)) == nil {
+			return
+		}
+	}
+}
+
+func (e *entry) getVersion() int64 {
+	if e == nil {
+		return 0
+	}
+	return e.version
+}
+
+// Function checkKey checks that a key has a path
+// whose elements all have kinds
+// and (except possibly the last, if allowIncomplete is true) IDs or names.
+func checkKey(key *pb.Key, allowIncomplete bool) error {
+	if key == nil || len(key.Path) == 0 {
+		return status.Error(codes.InvalidArgument, "key has no path")
+	}
+	for i, elem := range key.Path {
+		if elem.Kind == "" {
+			return status.Error(codes.InvalidArgument, "key path element has no kind")
+		}
+		if elem.IdType == nil && (i < len(key.Path)-1 || !allowIncomplete) {
+			return status.Error(codes.InvalidArgument, "key path is incomplete")
+		}
+	}
+	return nil
+}
+
+func incomplete(key *pb.Key) bool {
+	return key.Path[len(key.Path)-1].IdType == nil
+}
+
+// Function keyString gives a string uniquely identifying a complete key
+// (within a project and database).
+func keyString(key *pb.Key) string {
+	var buf strings.Builder
+	buf.WriteString(strconv.Quote(key.PartitionId.GetNamespaceId()))
+	for _, elem := range key.Path {
+		buf.WriteByte('/')
+		buf.WriteString(strconv.Quote(elem.Kind))
+		buf.WriteByte(',')
+		switch id := elem.IdType.(type) {
+		case *pb.Key_PathElement_Id:
+			buf.WriteString(strconv.FormatInt(id.Id, 10))
+		case *pb.Key_PathElement_Name:
+			buf.WriteString(strconv.Quote(id.Name))
+		}
+	}
+	return buf.String()
+}
+
+// Function compareKeys orders keys the way Cloud Datastore does:
+// parents before their children,
+// and within a kind, IDs (in numeric order) before names.
+func compareKeys(a, b *pb.Key) int {
+	if c := strings.Compare(a.PartitionId.GetNamespaceId(), b.PartitionId.GetNamespaceId()); c != 0 {
+		return c
+	}
+	for i := 0; i < len(a.Path) && i < len(b.Path); i++ {
+		ae, be := a.Path[i], b.Path[i]
+		if c := strings.Compare(ae.Kind, be.Kind); c != 0 {
+			return c
+		}
+		aID, aIsID := ae.IdType.(*pb.Key_PathElement_Id)
+		bID, bIsID := be.IdType.(*pb.Key_PathElement_Id)
+		switch {
+		case aIsID && bIsID:
+			if c := compareInts(aID.Id, bID.Id); c != 0 {
+				return c
+			}
+		case aIsID:
+			return -1
+		case bIsID:
+			return 1
+		default:
+			if c := strings.Compare(ae.GetName(), be.GetName()); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInts(int64(len(a.Path)), int64(len(b.Path)))
+}
+
+// Function hasAncestor tells whether anc is key or one of its ancestors.
+func hasAncestor(key, anc *pb.Key) bool {
+	if key.PartitionId.GetNamespaceId() != anc.PartitionId.GetNamespaceId() || len(anc.Path) > len(key.Path) {
+		return false
+	}
+	for i, elem := range anc.Path {
+		if !proto.Equal(elem, key.Path[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func encodeCursor(pos int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(pos))
+}
+
+func decodeCursor(c []byte) (int, error) {
+	if len(c) != 8 {
+		return 0, status.Error(codes.InvalidArgument, "invalid cursor")
+	}
+	return int(binary.BigEndian.Uint64(c)), nil
+}
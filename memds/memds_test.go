@@ -0,0 +1,280 @@
+package memds
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testDate struct {
+	Y, M, D int
+}
+
+type testEntity struct {
+	Name  string
+	N     int
+	Tags  []string
+	Born  testDate
+	Note  string `datastore:",noindex"`
+	Stamp time.Time
+}
+
+func newTestClient(t *testing.T) *datastore.Client {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	client, err := NewClient(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestGetPutDelete(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	key := datastore.NameKey("Thing", "a", nil)
+	var got testEntity
+	if err := client.Get(ctx, key, &got); !errors.Is(err, datastore.ErrNoSuchEntity) {
+		t.Fatalf("got error %v, want ErrNoSuchEntity", err)
+	}
+
+	want := testEntity{Name: "a", N: 1, Tags: []string{"x", "y"}, Born: testDate{Y: 1900, M: 1, D: 2}, Note: "note", Stamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if _, err := client.Put(ctx, key, &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Get(ctx, key, &got); err != nil {
+		t.Fatal(err)
+	}
+	got.Stamp = got.Stamp.UTC()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Inserting over an existing entity fails,
+	// as does updating a missing one.
+	_, err := client.Mutate(ctx, datastore.NewInsert(key, &want))
+	var me datastore.MultiError
+	if errors.As(err, &me) && len(me) == 1 {
+		err = me[0]
+	}
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("got error %v inserting an existing entity, want AlreadyExists", err)
+	}
+	_, err = client.Mutate(ctx, datastore.NewUpdate(datastore.NameKey("Thing", "b", nil), &want))
+	if errors.As(err, &me) && len(me) == 1 {
+		err = me[0]
+	}
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v updating a missing entity, want NotFound", err)
+	}
+
+	if err := client.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Get(ctx, key, &got); !errors.Is(err, datastore.ErrNoSuchEntity) {
+		t.Errorf("got error %v after deleting, want ErrNoSuchEntity", err)
+	}
+
+	// Incomplete keys get distinct IDs.
+	keys, err := client.PutMulti(ctx, []*datastore.Key{datastore.IncompleteKey("Thing", key), datastore.IncompleteKey("Thing", key)}, []*testEntity{{Name: "c"}, {Name: "d"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys[0].ID == 0 || keys[0].ID == keys[1].ID || !keys[0].Parent.Equal(key) {
+		t.Errorf("got keys %v and %v", keys[0], keys[1])
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	parent := datastore.NameKey("Parent", "p", nil)
+	entities := map[*datastore.Key]*testEntity{
+		datastore.NameKey("Thing", "a", nil):    {Name: "a", N: 3, Tags: []string{"x", "y"}, Born: testDate{Y: 1900, M: 5, D: 1}, Note: "n"},
+		datastore.NameKey("Thing", "b", nil):    {Name: "b", N: 1, Tags: []string{"y"}, Born: testDate{Y: 1800, M: 1, D: 1}, Note: "n"},
+		datastore.NameKey("Thing", "c", nil):    {Name: "c", N: 2, Tags: []string{"z"}, Born: testDate{Y: 1900, M: 1, D: 1}, Note: "m"},
+		datastore.NameKey("Thing", "d", parent): {Name: "d", N: 5, Born: testDate{Y: 2000, M: 1, D: 1}},
+		datastore.NameKey("Other", "e", parent): {Name: "e", N: 4},
+	}
+	for key, e := range entities {
+		if _, err := client.Put(ctx, key, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(q *datastore.Query) []string {
+		t.Helper()
+		var got []*testEntity
+		if _, err := client.GetAll(ctx, q, &got); err != nil {
+			t.Fatal(err)
+		}
+		var result []string
+		for _, e := range got {
+			result = append(result, e.Name)
+		}
+		return result
+	}
+
+	cases := []struct {
+		name string
+		q    *datastore.Query
+		want []string
+	}{
+		{"all", datastore.NewQuery("Thing"), []string{"d", "a", "b", "c"}}, // in key order
+		{"order", datastore.NewQuery("Thing").Order("-N"), []string{"d", "a", "c", "b"}},
+		{"equal", datastore.NewQuery("Thing").FilterField("N", "=", 2), []string{"c"}},
+		{"range", datastore.NewQuery("Thing").FilterField("N", ">=", 2).FilterField("N", "<", 5).Order("N"), []string{"c", "a"}},
+		{"array", datastore.NewQuery("Thing").FilterField("Tags", "=", "y").Order("N"), []string{"b", "a"}},
+		{"in", datastore.NewQuery("Thing").FilterField("Name", "in", []any{"a", "c", "q"}), []string{"a", "c"}},
+		{"not equal", datastore.NewQuery("Thing").FilterField("Name", "!=", "a"), []string{"b", "c", "d"}}, // in Name order
+		{"nested", datastore.NewQuery("Thing").FilterField("Born.Y", "=", 1900).Order("Born.M"), []string{"c", "a"}},
+		{"nested order", datastore.NewQuery("Thing").Order("-Born.Y").Order("Born.M"), []string{"d", "c", "a", "b"}},
+		{"unindexed", datastore.NewQuery("Thing").FilterField("Note", "=", "n"), nil},
+		{"limit", datastore.NewQuery("Thing").Order("N").Limit(2), []string{"b", "c"}},
+		{"offset", datastore.NewQuery("Thing").Order("N").Offset(1).Limit(2), []string{"c", "a"}},
+		{"ancestor", datastore.NewQuery("Thing").Ancestor(parent), []string{"d"}},
+		{"kindless ancestor", datastore.NewQuery("").Ancestor(parent), []string{"e", "d"}},
+		{"missing order property", datastore.NewQuery("Other").Order("Born.Q"), nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := names(c.q); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	t.Run("keys only", func(t *testing.T) {
+		keys, err := client.GetAll(ctx, datastore.NewQuery("Thing").FilterField("N", ">", 2).KeysOnly(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2 || keys[0].Name != "a" || keys[1].Name != "d" {
+			t.Errorf("got keys %v, want a and d", keys)
+		}
+	})
+
+	t.Run("projection", func(t *testing.T) {
+		var got []testEntity
+		_, err := client.GetAll(ctx, datastore.NewQuery("Thing").Project("Tags").DistinctOn("Tags"), &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tags []string
+		for _, e := range got {
+			tags = append(tags, e.Tags...)
+		}
+		if want := []string{"x", "y", "z"}; !reflect.DeepEqual(tags, want) {
+			t.Errorf("got tags %v, want %v", tags, want)
+		}
+	})
+
+	t.Run("count", func(t *testing.T) {
+		res, err := client.RunAggregationQuery(ctx, datastore.NewQuery("Thing").FilterField("N", "<", 4).NewAggregationQuery().WithCount("count"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res["count"].(interface{ GetIntegerValue() int64 }).GetIntegerValue(); got != 3 {
+			t.Errorf("got count %d, want 3", got)
+		}
+	})
+
+	t.Run("cursor", func(t *testing.T) {
+		it := client.Run(ctx, datastore.NewQuery("Thing").Order("N").Limit(2))
+		for {
+			var e testEntity
+			if _, err := it.Next(&e); err != nil {
+				break
+			}
+		}
+		cursor, err := it.Cursor()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := names(datastore.NewQuery("Thing").Order("N").Start(cursor)), []string{"a", "d"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v after cursor, want %v", got, want)
+		}
+	})
+
+	t.Run("inequalities", func(t *testing.T) {
+		for _, q := range []*datastore.Query{
+			datastore.NewQuery("Thing").FilterField("N", ">", 1).FilterField("Name", "<", "c").KeysOnly(),
+			datastore.NewQuery("Thing").FilterField("N", ">", 1).Order("Name").KeysOnly(),
+		} {
+			if _, err := client.GetAll(ctx, q, nil); status.Code(err) != codes.InvalidArgument {
+				t.Errorf("got error %v, want InvalidArgument", err)
+			}
+		}
+	})
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	key := datastore.NameKey("Thing", "a", nil)
+	if _, err := client.Put(ctx, key, &testEntity{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := client.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e testEntity
+	if err := tx.Get(key, &e); err != nil {
+		t.Fatal(err)
+	}
+
+	// A change made after the transaction read the entity
+	// makes it fail to commit.
+	if _, err := client.Put(ctx, key, &testEntity{N: 2}); err != nil {
+		t.Fatal(err)
+	}
+	e.N = 10
+	if _, err := tx.Put(key, &e); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Commit(); !errors.Is(err, datastore.ErrConcurrentTransaction) {
+		t.Fatalf("got error %v, want ErrConcurrentTransaction", err)
+	}
+
+	// RunInTransaction retries.
+	var tries int
+	_, err = client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		tries++
+		var e testEntity
+		if err := tx.Get(key, &e); err != nil {
+			return err
+		}
+		if tries == 1 {
+			if _, err := client.Put(ctx, key, &testEntity{N: 3}); err != nil {
+				return err
+			}
+		}
+		e.N++
+		_, err := tx.Put(key, &e)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Get(ctx, key, &e); err != nil {
+		t.Fatal(err)
+	}
+	if tries != 2 || e.N != 4 {
+		t.Errorf("got N=%d after %d tries, want N=4 after 2", e.N, tries)
+	}
+}
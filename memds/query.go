@@ -0,0 +1,544 @@
+package memds
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strings"
+
+	pb "cloud.google.com/go/datastore/apiv1/datastorepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// The name by which queries refer to an entity's key.
+const keyProperty = "__key__"
+
+// Type row is one result of a query.
+type row struct {
+	entry *entry
+	// The entity to return,
+	// which differs from entry.entity in projection queries.
+	entity *pb.Entity
+	// The values by which to sort the row,
+	// one per sort order.
+	sortVals []*pb.Value
+}
+
+// Method query runs q over the entities in the given namespace,
+// recording what it reads in t if it is non-nil.
+// The caller must hold s.mu.
+func (s *Server) query(ns string, q *pb.Query, t *txn) (*pb.QueryResultBatch, error) {
+	inequality, err := checkQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	orders := q.Order
+	if inequality != "" && len(orders) == 0 {
+		// As in Cloud Datastore,
+		// results are in the order of the inequality filter property.
+		orders = []*pb.PropertyOrder{{Property: &pb.PropertyReference{Name: inequality}}}
+	}
+
+	var kind string
+	if len(q.Kind) > 0 {
+		kind = q.Kind[0].Name
+	}
+
+	var rows []*row
+	for ks, e := range s.entities {
+		key := e.entity.Key
+		if key.PartitionId.GetNamespaceId() != ns {
+			continue
+		}
+		if kind != "" && key.Path[len(key.Path)-1].Kind != kind {
+			continue
+		}
+		if q.Filter != nil {
+			ok, err := matchFilter(e.entity, q.Filter)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		r := &row{entry: e, entity: e.entity}
+		if !r.setSortVals(orders) {
+			// Entities lacking an indexed value for a sort property
+			// are not in the results.
+			continue
+		}
+		rows = append(rows, r)
+		if t != nil {
+			t.reads[ks] = e.version
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return compareRows(rows[i], rows[j], orders) < 0
+	})
+
+	resultType := pb.EntityResult_FULL
+	if len(q.Projection) > 0 {
+		resultType = pb.EntityResult_PROJECTION
+		if len(q.Projection) == 1 && q.Projection[0].Property.GetName() == keyProperty {
+			resultType = pb.EntityResult_KEY_ONLY
+		}
+		rows = project(rows, q.Projection)
+	}
+	if len(q.DistinctOn) > 0 {
+		rows = distinct(rows, q.DistinctOn)
+	}
+
+	start, end := 0, len(rows)
+	if len(q.StartCursor) > 0 {
+		c, err := decodeCursor(q.StartCursor)
+		if err != nil {
+			return nil, err
+		}
+		start = min(c, end)
+	}
+	if len(q.EndCursor) > 0 {
+		c, err := decodeCursor(q.EndCursor)
+		if err != nil {
+			return nil, err
+		}
+		end = max(start, min(c, end))
+	}
+
+	batch := &pb.QueryResultBatch{
+		EntityResultType: resultType,
+		SnapshotVersion:  s.version,
+	}
+
+	pos := start
+	for batch.SkippedResults < q.Offset && pos < end {
+		batch.SkippedResults++
+		pos++
+	}
+	if batch.SkippedResults > 0 {
+		batch.SkippedCursor = encodeCursor(pos)
+	}
+
+	limit := -1
+	if q.Limit != nil {
+		limit = int(q.Limit.Value)
+	}
+	for pos < end && (limit < 0 || len(batch.EntityResults) < limit) {
+		r := rows[pos]
+		pos++
+		batch.EntityResults = append(batch.EntityResults, &pb.EntityResult{
+			Entity:  r.entity,
+			Version: r.entry.version,
+			Cursor:  encodeCursor(pos),
+		})
+	}
+	batch.EndCursor = encodeCursor(pos)
+
+	switch {
+	case pos < end:
+		batch.MoreResults = pb.QueryResultBatch_MORE_RESULTS_AFTER_LIMIT
+	case end < len(rows):
+		batch.MoreResults = pb.QueryResultBatch_MORE_RESULTS_AFTER_CURSOR
+	default:
+		batch.MoreResults = pb.QueryResultBatch_NO_MORE_RESULTS
+	}
+
+	return batch, nil
+}
+
+// Function checkQuery rejects the queries that Cloud Datastore rejects
+// for reasons other than missing indexes,
+// and the ones this package does not support.
+// It returns the name of the property with inequality filters, if any.
+func checkQuery(q *pb.Query) (string, error) {
+	if len(q.Kind) > 1 {
+		return "", status.Error(codes.InvalidArgument, "a query may have at most one kind")
+	}
+	if q.FindNearest != nil {
+		return "", status.Error(codes.Unimplemented, "nearest-neighbor queries are not supported")
+	}
+
+	var inequality string
+	err := walkFilters(q.Filter, func(f *pb.PropertyFilter) error {
+		switch f.Op {
+		case pb.PropertyFilter_LESS_THAN, pb.PropertyFilter_LESS_THAN_OR_EQUAL,
+			pb.PropertyFilter_GREATER_THAN, pb.PropertyFilter_GREATER_THAN_OR_EQUAL,
+			pb.PropertyFilter_NOT_EQUAL, pb.PropertyFilter_NOT_IN:
+
+			name := f.Property.GetName()
+			if inequality != "" && inequality != name {
+				return status.Errorf(codes.InvalidArgument, "inequality filters on more than one property (%s and %s)", inequality, name)
+			}
+			inequality = name
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if inequality != "" && len(q.Order) > 0 && q.Order[0].Property.GetName() != inequality {
+		return "", status.Errorf(codes.InvalidArgument, "the first sort order must be on the inequality filter property %s", inequality)
+	}
+	return inequality, nil
+}
+
+func walkFilters(f *pb.Filter, fn func(*pb.PropertyFilter) error) error {
+	switch f := f.GetFilterType().(type) {
+	case *pb.Filter_PropertyFilter:
+		return fn(f.PropertyFilter)
+	case *pb.Filter_CompositeFilter:
+		for _, sub := range f.CompositeFilter.Filters {
+			if err := walkFilters(sub, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func matchFilter(e *pb.Entity, f *pb.Filter) (bool, error) {
+	switch f := f.FilterType.(type) {
+	case *pb.Filter_PropertyFilter:
+		return matchPropertyFilter(e, f.PropertyFilter)
+
+	case *pb.Filter_CompositeFilter:
+		isAnd := f.CompositeFilter.Op == pb.CompositeFilter_AND
+		for _, sub := range f.CompositeFilter.Filters {
+			ok, err := matchFilter(e, sub)
+			if err != nil {
+				return false, err
+			}
+			if ok != isAnd {
+				return ok, nil
+			}
+		}
+		return isAnd, nil
+	}
+	return false, status.Errorf(codes.InvalidArgument, "unknown filter type %T", f.FilterType)
+}
+
+func matchPropertyFilter(e *pb.Entity, f *pb.PropertyFilter) (bool, error) {
+	if f.Op == pb.PropertyFilter_HAS_ANCESTOR {
+		anc := f.Value.GetKeyValue()
+		if anc == nil {
+			return false, status.Error(codes.InvalidArgument, "ancestor filter value is not a key")
+		}
+		return hasAncestor(e.Key, anc), nil
+	}
+
+	var inList []*pb.Value
+	if f.Op == pb.PropertyFilter_IN || f.Op == pb.PropertyFilter_NOT_IN {
+		arr := f.Value.GetArrayValue()
+		if arr == nil {
+			return false, status.Errorf(codes.InvalidArgument, "%s filter value is not an array", f.Op)
+		}
+		inList = arr.Values
+	}
+
+	// A filter matches if any of the property's values satisfies it.
+	for _, v := range propertyValues(e, f.Property.GetName()) {
+		c := compareValues(v, f.Value)
+		var ok bool
+		switch f.Op {
+		case pb.PropertyFilter_LESS_THAN:
+			ok = c < 0
+		case pb.PropertyFilter_LESS_THAN_OR_EQUAL:
+			ok = c <= 0
+		case pb.PropertyFilter_GREATER_THAN:
+			ok = c > 0
+		case pb.PropertyFilter_GREATER_THAN_OR_EQUAL:
+			ok = c >= 0
+		case pb.PropertyFilter_EQUAL:
+			ok = c == 0
+		case pb.PropertyFilter_NOT_EQUAL:
+			ok = c != 0
+		case pb.PropertyFilter_IN:
+			ok = containsValue(inList, v)
+		case pb.PropertyFilter_NOT_IN:
+			ok = !containsValue(inList, v)
+		default:
+			return false, status.Errorf(codes.InvalidArgument, "unknown filter operator %s", f.Op)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func containsValue(vals []*pb.Value, v *pb.Value) bool {
+	for _, other := range vals {
+		if compareValues(v, other) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Function propertyValues gives the indexed values of the named property of e.
+// Array values are flattened,
+// and a dotted name like "A.B" refers to property B of entity-valued property A.
+func propertyValues(e *pb.Entity, name string) []*pb.Value {
+	if name == keyProperty {
+		return []*pb.Value{{ValueType: &pb.Value_KeyValue{KeyValue: e.Key}}}
+	}
+	return indexedValues(e.Properties, name)
+}
+
+func indexedValues(props map[string]*pb.Value, name string) []*pb.Value {
+	var result []*pb.Value
+	if v, ok := props[name]; ok {
+		result = append(result, flatten(v)...)
+	}
+	for i := strings.IndexByte(name, '.'); i >= 0; i = nextDot(name, i) {
+		v, ok := props[name[:i]]
+		if !ok {
+			continue
+		}
+		for _, elem := range flatten(v) {
+			if sub := elem.GetEntityValue(); sub != nil {
+				result = append(result, indexedValues(sub.Properties, name[i+1:])...)
+			}
+		}
+	}
+	return result
+}
+
+func nextDot(s string, i int) int {
+	j := strings.IndexByte(s[i+1:], '.')
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+// Function flatten gives the indexed values in v:
+// its elements if it is an array,
+// otherwise v itself.
+func flatten(v *pb.Value) []*pb.Value {
+	if arr := v.GetArrayValue(); arr != nil {
+		var result []*pb.Value
+		for _, elem := range arr.Values {
+			if !elem.ExcludeFromIndexes {
+				result = append(result, elem)
+			}
+		}
+		return result
+	}
+	if v.ExcludeFromIndexes {
+		return nil
+	}
+	return []*pb.Value{v}
+}
+
+// Method setSortVals sets r's sort values for the given sort orders.
+// For an ascending order on a property with several values,
+// the smallest is used,
+// and for a descending order the largest.
+// It returns false if r's entity lacks a value for any of them.
+func (r *row) setSortVals(orders []*pb.PropertyOrder) bool {
+	r.sortVals = nil
+	for _, o := range orders {
+		vals := propertyValues(r.entity, o.Property.GetName())
+		if len(vals) == 0 {
+			return false
+		}
+		best := vals[0]
+		for _, v := range vals[1:] {
+			c := compareValues(v, best)
+			if (o.Direction == pb.PropertyOrder_DESCENDING && c > 0) || (o.Direction != pb.PropertyOrder_DESCENDING && c < 0) {
+				best = v
+			}
+		}
+		r.sortVals = append(r.sortVals, best)
+	}
+	return true
+}
+
+func compareRows(a, b *row, orders []*pb.PropertyOrder) int {
+	for i, o := range orders {
+		c := compareValues(a.sortVals[i], b.sortVals[i])
+		if o.Direction == pb.PropertyOrder_DESCENDING {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareKeys(a.entity.Key, b.entity.Key)
+}
+
+// Function project replaces the entities in rows with ones
+// having only the given properties.
+// As in Cloud Datastore,
+// an entity lacking any of the properties is dropped,
+// and one with several values for a property
+// gives a row for each.
+func project(rows []*row, projection []*pb.Projection) []*row {
+	var result []*row
+	for _, r := range rows {
+		partials := []*pb.Entity{{Key: r.entity.Key}}
+		for _, p := range projection {
+			name := p.Property.GetName()
+			if name == keyProperty {
+				continue
+			}
+			vals := propertyValues(r.entity, name)
+			var next []*pb.Entity
+			for _, partial := range partials {
+				for _, v := range vals {
+					e := proto.Clone(partial).(*pb.Entity)
+					if e.Properties == nil {
+						e.Properties = make(map[string]*pb.Value)
+					}
+					e.Properties[name] = v
+					next = append(next, e)
+				}
+			}
+			partials = next
+		}
+		for _, e := range partials {
+			result = append(result, &row{entry: r.entry, entity: e, sortVals: r.sortVals})
+		}
+	}
+	return result
+}
+
+// Function distinct keeps only the first of the rows
+// having each combination of values for the given properties.
+func distinct(rows []*row, props []*pb.PropertyReference) []*row {
+	var (
+		result []*row
+		seen   = make(map[string]bool)
+		opts   = proto.MarshalOptions{Deterministic: true}
+	)
+	for _, r := range rows {
+		var buf bytes.Buffer
+		for _, p := range props {
+			for _, v := range propertyValues(r.entity, p.GetName()) {
+				b, _ := opts.Marshal(v)
+				buf.Write(b)
+			}
+			buf.WriteByte(0)
+		}
+		if seen[buf.String()] {
+			continue
+		}
+		seen[buf.String()] = true
+		result = append(result, r)
+	}
+	return result
+}
+
+// Function valueRank gives the position of v's type
+// in the order Firestore in Datastore mode uses
+// to compare values of different types.
+func valueRank(v *pb.Value) int {
+	switch v.ValueType.(type) {
+	case *pb.Value_NullValue:
+		return 0
+	case *pb.Value_BooleanValue:
+		return 1
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		return 2
+	case *pb.Value_TimestampValue:
+		return 3
+	case *pb.Value_StringValue:
+		return 4
+	case *pb.Value_BlobValue:
+		return 5
+	case *pb.Value_KeyValue:
+		return 6
+	case *pb.Value_GeoPointValue:
+		return 7
+	case *pb.Value_ArrayValue:
+		return 8
+	case *pb.Value_EntityValue:
+		return 9
+	}
+	return 0
+}
+
+func compareValues(a, b *pb.Value) int {
+	if ra, rb := valueRank(a), valueRank(b); ra != rb {
+		return compareInts(int64(ra), int64(rb))
+	}
+
+	switch av := a.ValueType.(type) {
+	case *pb.Value_BooleanValue:
+		return compareInts(boolInt(av.BooleanValue), boolInt(b.GetBooleanValue()))
+
+	case *pb.Value_IntegerValue:
+		if bv, ok := b.ValueType.(*pb.Value_IntegerValue); ok {
+			return compareInts(av.IntegerValue, bv.IntegerValue)
+		}
+		return compareFloats(float64(av.IntegerValue), b.GetDoubleValue())
+
+	case *pb.Value_DoubleValue:
+		if bv, ok := b.ValueType.(*pb.Value_IntegerValue); ok {
+			return compareFloats(av.DoubleValue, float64(bv.IntegerValue))
+		}
+		return compareFloats(av.DoubleValue, b.GetDoubleValue())
+
+	case *pb.Value_TimestampValue:
+		at, bt := av.TimestampValue, b.GetTimestampValue()
+		if c := compareInts(at.GetSeconds(), bt.GetSeconds()); c != 0 {
+			return c
+		}
+		return compareInts(int64(at.GetNanos()), int64(bt.GetNanos()))
+
+	case *pb.Value_StringValue:
+		return strings.Compare(av.StringValue, b.GetStringValue())
+
+	case *pb.Value_BlobValue:
+		return bytes.Compare(av.BlobValue, b.GetBlobValue())
+
+	case *pb.Value_KeyValue:
+		return compareKeys(av.KeyValue, b.GetKeyValue())
+
+	case *pb.Value_GeoPointValue:
+		ag, bg := av.GeoPointValue, b.GetGeoPointValue()
+		if c := compareFloats(ag.GetLatitude(), bg.GetLatitude()); c != 0 {
+			return c
+		}
+		return compareFloats(ag.GetLongitude(), bg.GetLongitude())
+
+	case *pb.Value_ArrayValue, *pb.Value_EntityValue:
+		// These are not indexed,
+		// so their order does not matter,
+		// only whether they are equal.
+		if proto.Equal(a, b) {
+			return 0
+		}
+		opts := proto.MarshalOptions{Deterministic: true}
+		ab, _ := opts.Marshal(a)
+		bb, _ := opts.Marshal(b)
+		if c := bytes.Compare(ab, bb); c != 0 {
+			return c
+		}
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a) || a < b:
+		return -1
+	case math.IsNaN(b) || a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,139 @@
+package outlived
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/text/message"
+)
+
+// Milestone is a notable day in someone's life,
+// like being 10,000 days old.
+type Milestone struct {
+	// Key identifies the milestone among all of a person's milestones,
+	// e.g. "days-10000" or "birthday-30".
+	Key string
+
+	Title, Desc string
+}
+
+// MilestoneRule computes the milestone, if any,
+// reached on the given day by someone born on the given date.
+// A rule whose milestone can move from day to day
+// (like MedianLifespanMilestone)
+// counts it as reached if it was crossed at any time after lastSent,
+// the day before today on which the person last got mail
+// (see Milestones).
+// The stats are the lifespans of the known figures
+// and may be nil if they are unavailable.
+type MilestoneRule func(born, today, lastSent Date, stats *LifespanStats) *Milestone
+
+// MilestoneRules are the rules consulted by Milestones.
+var MilestoneRules = []MilestoneRule{
+	RoundDaysMilestone,
+	BirthdayMilestone,
+	MedianLifespanMilestone,
+	MeanLifespanMilestone,
+}
+
+// Milestones returns the milestones reached on the given day
+// by someone born on the given date
+// who last got mail on lastSent (see User.LastSent),
+// according to MilestoneRules.
+// If lastSent is the zero Date, or is not before today,
+// it is taken to be the day before today.
+func Milestones(born, today, lastSent Date, stats *LifespanStats) []*Milestone {
+	if lastSent == (Date{}) || today.Since(lastSent) <= 0 {
+		lastSent = today.AddDays(-1)
+	}
+	var result []*Milestone
+	for _, rule := range MilestoneRules {
+		if m := rule(born, today, lastSent, stats); m != nil {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// RoundDays is the interval in days between the milestones of RoundDaysMilestone.
+const RoundDays = 10000
+
+// RoundDaysMilestone is the milestone of being a multiple of RoundDays days old.
+func RoundDaysMilestone(born, today, _ Date, _ *LifespanStats) *Milestone {
+	since := today.Since(born)
+	if since <= 0 || since%RoundDays != 0 {
+		return nil
+	}
+	return &Milestone{
+		Key:   fmt.Sprintf("days-%d", since),
+		Title: fmt.Sprintf("You are %s days old!", FormatNum(since)),
+		Desc:  fmt.Sprintf("As of today you have been alive for %s days.", FormatNum(since)),
+	}
+}
+
+// BirthdayMilestone is the milestone of a birthday.
+// Someone born on 29 February has their birthday on 1 March in common years.
+func BirthdayMilestone(born, today, _ Date, _ *LifespanStats) *Milestone {
+	born = born.In(today.Cal)
+	years := today.Y - born.Y
+	if years <= 0 {
+		return nil
+	}
+	bday := Date{Y: today.Y, M: born.M, D: born.D, Cal: today.Cal}
+	if born.M == time.February && born.D == 29 && !today.Cal.isLeapYear(today.Y) {
+		bday = Date{Y: today.Y, M: time.March, D: 1, Cal: today.Cal}
+	}
+	if today.Since(bday) != 0 {
+		return nil
+	}
+	return &Milestone{
+		Key:   fmt.Sprintf("birthday-%d", years),
+		Title: "Happy birthday!",
+		Desc:  fmt.Sprintf("You are %d years old today, which is %s days.", years, FormatNum(today.Since(born))),
+	}
+}
+
+// MedianLifespanMilestone is the milestone of outliving
+// the median lifespan of the known figures,
+// i.e. outliving half of them.
+// Since the median changes as figures are added,
+// it is reached by crossing it at any time after lastSent.
+func MedianLifespanMilestone(born, today, lastSent Date, stats *LifespanStats) *Milestone {
+	if stats == nil || stats.Count == 0 || !outlivedSince(born, today, lastSent, stats.Median) {
+		return nil
+	}
+	return &Milestone{
+		Key:   fmt.Sprintf("median-%d", stats.Median),
+		Title: "You have outlived half of the famous!",
+		Desc:  fmt.Sprintf("Today you passed the median lifespan, %s days, of the %s figures known to Outlived.", FormatNum(stats.Median), FormatNum(stats.Count)),
+	}
+}
+
+// MeanLifespanMilestone is the milestone of outliving
+// the mean lifespan of the known figures.
+// Like the median (see MedianLifespanMilestone),
+// it is reached by crossing it at any time after lastSent.
+func MeanLifespanMilestone(born, today, lastSent Date, stats *LifespanStats) *Milestone {
+	if stats == nil || stats.Count == 0 || !outlivedSince(born, today, lastSent, stats.Mean) {
+		return nil
+	}
+	return &Milestone{
+		Key:   fmt.Sprintf("mean-%d", stats.Mean),
+		Title: "You have outlived the average famous person!",
+		Desc:  fmt.Sprintf("Today you passed the mean lifespan, %s days, of the %s figures known to Outlived.", FormatNum(stats.Mean), FormatNum(stats.Count)),
+	}
+}
+
+// Function outlivedSince tells whether someone born on the given date
+// outlived a lifespan of the given number of days
+// after the day lastSent and no later than today.
+// On a given day, someone has outlived the lifespans
+// shorter than the number of days since their birth.
+func outlivedSince(born, today, lastSent Date, days int) bool {
+	return lastSent.Since(born)-1 < days && days <= today.Since(born)-1
+}
+
+// FormatNum formats n with thousands separators (e.g. "10,000").
+func FormatNum(n int) string {
+	return message.NewPrinter(message.MatchLanguage("en")).Sprintf("%v", n)
+}
@@ -0,0 +1,121 @@
+package outlived
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMilestones(t *testing.T) {
+	var (
+		born  = Date{Y: 2000, M: time.January, D: 1}
+		stats = &LifespanStats{Count: 3, Mean: 500, Median: 400}
+	)
+
+	cases := []struct {
+		name     string
+		today    Date
+		lastSent Date
+		stats    *LifespanStats
+		want     []string
+	}{
+		{name: "birth", today: born},
+		{name: "ordinary", today: born.AddDays(123), stats: stats},
+		{name: "10000_days", today: born.AddDays(10000), want: []string{"days-10000"}},
+		{name: "20000_days", today: born.AddDays(20000), want: []string{"days-20000"}},
+		{name: "birthday", today: Date{Y: 2030, M: time.January, D: 1}, want: []string{"birthday-30"}},
+		{name: "median", today: born.AddDays(401), stats: stats, want: []string{"median-400"}},
+		{name: "median_no_stats", today: born.AddDays(401)},
+		{name: "mean", today: born.AddDays(501), stats: stats, want: []string{"mean-500"}},
+		{name: "mean_is_median", today: born.AddDays(401), stats: &LifespanStats{Count: 1, Mean: 400, Median: 400}, want: []string{"median-400", "mean-400"}},
+		{name: "empty_stats", today: born.AddDays(1), stats: &LifespanStats{}},
+		{name: "median_since_last_sent", today: born.AddDays(405), lastSent: born.AddDays(398), stats: stats, want: []string{"median-400"}},
+		{name: "median_before_last_sent", today: born.AddDays(405), lastSent: born.AddDays(401), stats: stats},
+		{name: "median_last_sent_today", today: born.AddDays(401), lastSent: born.AddDays(401), stats: stats, want: []string{"median-400"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got []string
+			for _, m := range Milestones(born, c.today, c.lastSent, c.stats) {
+				got = append(got, m.Key)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// The median and mean change as figures are added,
+// so a milestone based on one may be crossed without being reached exactly on any day.
+func TestMovingStatMilestone(t *testing.T) {
+	born := Date{Y: 2000, M: time.January, D: 1}
+
+	// At the first send the median is 403 days, not yet outlived.
+	var (
+		first = born.AddDays(400)
+		stats = &LifespanStats{Count: 3, Mean: 500, Median: 403}
+	)
+	if got := Milestones(born, first, first.AddDays(-1), stats); len(got) != 0 {
+		t.Fatalf("got %d milestone(s) at first send, want none", len(got))
+	}
+
+	// By the second send, two days later,
+	// the median has moved to 401 days, which has now been outlived,
+	// though never on the day it was the median.
+	var (
+		second = first.AddDays(2)
+		moved  = &LifespanStats{Count: 4, Mean: 500, Median: 401}
+	)
+	var got []string
+	for _, m := range Milestones(born, second, first, moved) {
+		got = append(got, m.Key)
+	}
+	if want := []string{"median-401"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v at second send, want %v", got, want)
+	}
+}
+
+func TestLeapDayBirthday(t *testing.T) {
+	born := Date{Y: 2000, M: time.February, D: 29}
+
+	cases := []struct {
+		today Date
+		want  string
+	}{
+		{today: Date{Y: 2001, M: time.February, D: 28}},
+		{today: Date{Y: 2001, M: time.March, D: 1}, want: "birthday-1"},
+		{today: Date{Y: 2004, M: time.February, D: 29}, want: "birthday-4"},
+		{today: Date{Y: 2004, M: time.March, D: 1}},
+		{today: Date{Y: 2100, M: time.March, D: 1}, want: "birthday-100"}, // 2100 is not a leap year
+	}
+	for _, c := range cases {
+		t.Run(c.today.YYYYMMDD(), func(t *testing.T) {
+			var got string
+			if m := BirthdayMilestone(born, c.today, Date{}, nil); m != nil {
+				got = m.Key
+			}
+			if got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLifespanStats(t *testing.T) {
+	cases := []struct {
+		days []int
+		want LifespanStats
+	}{
+		{},
+		{days: []int{7}, want: LifespanStats{Count: 1, Mean: 7, Median: 7}},
+		{days: []int{30, 10, 20}, want: LifespanStats{Count: 3, Mean: 20, Median: 20}},
+		{days: []int{1, 100, 2, 3}, want: LifespanStats{Count: 4, Mean: 27, Median: 2}},
+	}
+	for _, c := range cases {
+		got := lifespanStats(c.days)
+		if *got != c.want {
+			t.Errorf("lifespanStats(%v) = %+v, want %+v", c.days, *got, c.want)
+		}
+	}
+}
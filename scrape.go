@@ -35,8 +35,25 @@ var (
 		"December",
 	}
 
-	dateRegex1 = regexp.MustCompile(`(January|February|March|April|May|June|July|August|September|October|November|December)\s+(\d+),\s+(\d+)(.*BC)?`)
-	dateRegex2 = regexp.MustCompile(`(\d+)\s+(January|February|March|April|May|June|July|August|September|October|November|December)\s+(\d+)(.*BC)?`)
+	// In descending order of precision.
+	dateRegexes = []*regexp.Regexp{
+		regexp.MustCompile(circaRegexStr + `(?P<mon>` + monthRegexStr + `)\s+(?P<day>\d+),\s+` + yearRegexStr),
+		regexp.MustCompile(circaRegexStr + `(?P<day>\d+)\s+(?P<mon>` + monthRegexStr + `)\s+` + yearRegexStr),
+		regexp.MustCompile(circaRegexStr + `(?P<mon>` + monthRegexStr + `)\s+` + yearRegexStr),
+		regexp.MustCompile(circaRegexStr + `\b` + yearRegexStr),
+	}
+
+	circaRegexStr = `(?P<circa>\b(?:c\.|ca\.|circa)\s*)?`
+	monthRegexStr = `January|February|March|April|May|June|July|August|September|October|November|December`
+
+	// The year, with an optional s making it a decade (as in 1450s),
+	// an optional dual-dating suffix (as in 1731/32),
+	// an optional Old Style or New Style marker (as in "1620 (O.S.)"),
+	// possibly introducing the date in the other style (as in "1732 [O.S. 11 February 1731]"),
+	// and an optional BC.
+	yearRegexStr = `(?P<year>\d+)(?P<decade>s\b)?(?:/(?P<dual>\d{1,4}))?(?P<osns>\s*[\[(]?\s*[ON]\.\s*S\.(?:\s*\d)?)?(?P<bc>.*BC)?`
+
+	osnsRegex = regexp.MustCompile(`([ON])\.\s*S\.(\s*\d)?`)
 
 	bRegex = regexp.MustCompile(`\(b\.\s*\d+\)$`)
 
@@ -139,103 +156,174 @@ func ScrapeDay(ctx context.Context, client *http.Client, m time.Month, d int, on
 	})
 }
 
-func ScrapePerson(
-	ctx context.Context,
-	client *http.Client,
-	href, title, desc string,
-	onPerson func(
-		ctx context.Context,
-		title, desc, href, imgSrc, imgAlt string,
-		bornY, bornM, bornD, diedY, diedM, diedD, aliveDays, pageviews int,
-	) error,
-) error {
+// Parser names the strategy that found a figure's details.
+type Parser string
+
+const (
+	// ParserInfobox means the details came from the infobox on the figure's Wikipedia page.
+	ParserInfobox Parser = "infobox"
+
+	// ParserIntro means the details came from the bolded name and parenthesized dates
+	// in one of the first paragraphs of the page's first section.
+	ParserIntro Parser = "intro"
+
+	// ParserBold means the details came from the first bolded occurrence of the figure's name
+	// anywhere on the page
+	// (for pages without an infobox or sections).
+	ParserBold Parser = "bold"
+
+	// ParserWikidata means the details came from the figure's Wikidata entity.
+	ParserWikidata Parser = "wikidata"
+)
+
+// PersonResult is the result of scraping a single figure.
+type PersonResult struct {
+	// Figure is the figure that was found.
+	Figure *Figure
+
+	// Parser is the strategy that found the figure's details.
+	// When merging sources (see SourceMerge),
+	// this is the strategy used on the Wikipedia page.
+	Parser Parser
+
+	// Href is the figure's href,
+	// after following any redirect reported in the Content-Location header.
+	// It is the same as Figure.Link.
+	Href string
+
+	// OrigHref and OrigTitle are the href and title that were requested
+	// (as found by ScrapeDay).
+	OrigHref, OrigTitle string
+
+	// Warnings describe any adjustments or oddities encountered along the way.
+	Warnings []string
+}
+
+func (r *PersonResult) warnf(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// ScrapePerson gets the details of the figure at the given Wikipedia href
+// (as found by ScrapeDay)
+// by parsing the figure's Wikipedia page.
+func ScrapePerson(ctx context.Context, client *http.Client, href, title, desc string) (*PersonResult, error) {
+	res := &PersonResult{
+		OrigHref:  href,
+		OrigTitle: title,
+	}
+
 	resp, updHref, err := getWikiHTML(ctx, client, href)
 	if err != nil {
-		return errors.Wrapf(err, "getting %s", href)
+		return nil, classify(ScrapeErrorHTTP, errors.Wrapf(err, "getting %s", href))
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, classify(ScrapeErrorHTTP, fmt.Errorf("getting %s: status %d", href, resp.StatusCode))
+	}
 	if updHref != href {
-		log.Printf("updating href %s -> %s", href, updHref)
+		res.warnf("updated href %s -> %s", href, updHref)
 		href = updHref
 	}
 
 	tree, err := html.Parse(resp.Body)
 	if err != nil {
-		return errors.Wrapf(err, "parsing HTML of %s", href)
+		return nil, classify(ScrapeErrorParse, errors.Wrapf(err, "parsing HTML of %s", href))
 	}
 
-	fullname, imgSrc, imgAlt, bornY, bornM, bornD, diedY, diedM, diedD, err := parsePerson(ctx, tree, href, title)
+	p, err := parsePerson(ctx, tree, href, title)
 	if err != nil {
-		return errors.Wrapf(err, "parsing content of %s", href)
+		return nil, classifyParseError(errors.Wrapf(err, "parsing content of %s", href))
 	}
 
-	if fullname != "" && fullname != title {
-		log.Printf("updating %s -> %s", title, fullname)
-		title = fullname
+	if p.fullname != "" && p.fullname != title {
+		res.warnf("updated title %s -> %s", title, p.fullname)
+		title = p.fullname
 		if ind := strings.Index(title, "\n"); ind > 0 {
 			title = strings.TrimSpace(title[:ind])
-			log.Printf("truncating to %s", title)
+			res.warnf("truncated title to %s", title)
 		}
 	}
 
-	by := bornY
-	if by < 0 {
-		by++
+	pageviews, err := scrapePageviews(ctx, client, href)
+	if err != nil {
+		return nil, classify(ScrapeErrorPageviews, errors.Wrap(err, "getting pageviews"))
 	}
-	dy := diedY
-	if dy < 0 {
-		dy++
+
+	res.Figure = &Figure{
+		Name:      title,
+		Desc:      desc,
+		Link:      href,
+		ImgSrc:    p.imgSrc,
+		ImgAlt:    p.imgAlt,
+		Born:      p.born,
+		Died:      p.died,
+		DaysAlive: p.died.Since(p.born),
+		Pageviews: pageviews,
+		Updated:   time.Now(),
 	}
-	born := time.Date(by, time.Month(bornM), bornD, 0, 0, 0, 0, time.UTC)
-	died := time.Date(dy, time.Month(diedM), diedD, 0, 0, 0, 0, time.UTC)
-	aliveDur := died.Sub(born)
-	aliveDays := int(aliveDur / (24 * time.Hour))
+	res.Parser = p.parser
+	res.Href = href
 
-	pageviews, err := scrapePageviews(ctx, client, href)
+	return res, nil
+}
+
+// UpdateFigure scrapes the figure at the given Wikipedia href
+// (as found by ScrapeDay)
+// from the given source
+// and stores the result.
+// It logs how the figure was found.
+func UpdateFigure(ctx context.Context, client *http.Client, store FigureStore, src Source, href, title, desc string) (*PersonResult, error) {
+	res, err := ScrapeFigure(ctx, client, src, href, title, desc)
 	if err != nil {
-		return errors.Wrap(err, "getting pageviews")
+		return nil, err
+	}
+
+	fig := res.Figure
+	log.Printf("updating person %s (href %s) via %s parser, %d pageviews", fig.Name, fig.Link, res.Parser, fig.Pageviews)
+	for _, w := range res.Warnings {
+		log.Printf("  %s: %s", res.OrigHref, w)
 	}
 
-	return onPerson(ctx, title, desc, href, imgSrc, imgAlt, bornY, bornM, bornD, diedY, diedM, diedD, aliveDays, pageviews)
+	err = store.ReplaceFigures(ctx, []*Figure{fig})
+	return res, errors.Wrapf(err, "storing %s", fig.Link)
 }
 
-func parsePerson(ctx context.Context, tree *html.Node, href, title string) (
-	fullname, imgSrc, imgAlt string,
-	bornY, bornM, bornD, diedY, diedM, diedD int,
-	err error,
-) {
+// Type parsedPerson is the result of parsing a figure's Wikipedia page.
+type parsedPerson struct {
+	fullname, imgSrc, imgAlt string
+	born, died               Date
+	parser                   Parser
+}
+
+func parsePerson(ctx context.Context, tree *html.Node, href, title string) (*parsedPerson, error) {
 	infobox := findInfoBox(tree)
 	if infobox == nil {
 		return parsePersonWithoutInfoBox(ctx, tree, href, title)
 	}
 
-	fullname, err = findFullName(infobox)
+	p := &parsedPerson{parser: ParserInfobox}
+
+	var err error
+	p.fullname, err = findFullName(infobox)
 	if err != nil {
-		err = errors.Wrap(err, "finding fullname in infobox")
-		return
+		return nil, errors.Wrap(err, "finding fullname in infobox")
 	}
 
-	imgSrc, imgAlt = findInfoboxImg(infobox)
+	p.imgSrc, p.imgAlt = findInfoboxImg(infobox)
 
-	bornY, bornM, bornD, err = findDateRow(infobox, "Born")
+	p.born, err = findDateRow(infobox, "Born")
 	if err != nil {
-		err = errors.Wrap(err, "finding Born row")
-		return
+		return nil, errors.Wrap(err, "finding Born row")
 	}
-	diedY, diedM, diedD, err = findDateRow(infobox, "Died")
+	p.died, err = findDateRow(infobox, "Died")
 	if err != nil {
-		err = errors.Wrap(err, "finding Died row")
-		return
+		return nil, errors.Wrap(err, "finding Died row")
 	}
 
-	return fullname, imgSrc, imgAlt, bornY, bornM, bornD, diedY, diedM, diedD, err
+	return p, nil
 }
 
-func parsePersonWithoutInfoBox(ctx context.Context, tree *html.Node, href, title string) (
-	fullname, imgSrc, imgAlt string,
-	bornY, bornM, bornD, diedY, diedM, diedD int,
-	err error,
-) {
+func parsePersonWithoutInfoBox(ctx context.Context, tree *html.Node, href, title string) (*parsedPerson, error) {
 	secNode := htree.FindEl(tree, func(n *html.Node) bool {
 		return n.DataAtom == atom.Section
 	})
@@ -243,6 +331,8 @@ func parsePersonWithoutInfoBox(ctx context.Context, tree *html.Node, href, title
 		return parsePersonWithoutInfoBoxOrSection(ctx, tree, href, title)
 	}
 
+	p := &parsedPerson{parser: ParserIntro}
+
 	// Look for the first <p> under secNode.
 	// Check it for name and dates.
 	// If that's no good, look for the second <p> and check that.
@@ -250,6 +340,7 @@ func parsePersonWithoutInfoBox(ctx context.Context, tree *html.Node, href, title
 		pNode *html.Node
 		tries int
 		found bool
+		err   error
 	)
 	for pNode = secNode.FirstChild; pNode != nil && tries <= 2; pNode = pNode.NextSibling {
 		if pNode.Type != html.ElementNode || pNode.DataAtom != atom.P {
@@ -264,12 +355,11 @@ func parsePersonWithoutInfoBox(ctx context.Context, tree *html.Node, href, title
 		if bNode == nil {
 			continue
 		}
-		fullname, err = htree.Text(bNode)
+		p.fullname, err = htree.Text(bNode)
 		if err != nil {
-			err = errors.Wrap(err, "converting fullname to text")
-			return
+			return nil, errors.Wrap(err, "converting fullname to text")
 		}
-		if fullname != title {
+		if p.fullname != title {
 			continue
 		}
 
@@ -277,8 +367,7 @@ func parsePersonWithoutInfoBox(ctx context.Context, tree *html.Node, href, title
 		for tNode := bNode.NextSibling; tNode != nil; tNode = tNode.NextSibling {
 			err = htree.WriteText(buf, tNode)
 			if err != nil {
-				err = errors.Wrap(err, "converting intro text to plain text")
-				return
+				return nil, errors.Wrap(err, "converting intro text to plain text")
 			}
 		}
 		tNodeText := buf.String()
@@ -287,12 +376,12 @@ func parsePersonWithoutInfoBox(ctx context.Context, tree *html.Node, href, title
 			continue
 		}
 
-		bornY, bornM, bornD, err = parseDate(m[1])
+		p.born, err = parseDate(m[1])
 		if err != nil {
 			continue
 		}
 
-		diedY, diedM, diedD, err = parseDate(m[2])
+		p.died, err = parseDate(m[2])
 		if err != nil {
 			continue
 		}
@@ -302,8 +391,7 @@ func parsePersonWithoutInfoBox(ctx context.Context, tree *html.Node, href, title
 	}
 
 	if !found {
-		err = fmt.Errorf("no infobox and no suitable intro text in %s", href)
-		return
+		return nil, fmt.Errorf("no infobox and no suitable intro text in %s: %w", href, errNoDates)
 	}
 
 	// Look for the first <figure> under secNode, excluding tables (https://github.com/bobg/outlived/issues/27#issuecomment-552221279).
@@ -315,27 +403,23 @@ func parsePersonWithoutInfoBox(ctx context.Context, tree *html.Node, href, title
 		return n.DataAtom == atom.Figure
 	})
 	if figNode == nil {
-		return
+		return p, nil
 	}
-	imgSrc, imgAlt = findImg(figNode)
-	if imgSrc == "" {
-		return
+	p.imgSrc, p.imgAlt = findImg(figNode)
+	if p.imgSrc == "" {
+		return p, nil
 	}
 	captionEl := htree.FindEl(figNode, func(n *html.Node) bool {
 		return n.DataAtom == atom.Figcaption
 	})
 	if captionEl == nil {
-		return
+		return p, nil
 	}
-	imgAlt, _ = htree.Text(captionEl)
-	return
+	p.imgAlt, _ = htree.Text(captionEl)
+	return p, nil
 }
 
-func parsePersonWithoutInfoBoxOrSection(ctx context.Context, tree *html.Node, href, title string) (
-	fullname, imgSrc, imgAlt string,
-	bornY, bornM, bornD, diedY, diedM, diedD int,
-	err error,
-) {
+func parsePersonWithoutInfoBoxOrSection(ctx context.Context, tree *html.Node, href, title string) (*parsedPerson, error) {
 	// Look for the first <b>...</b> that contains the title of the page
 	// (i.e., the person's name).
 	bNode := htree.FindEl(tree, func(n *html.Node) bool {
@@ -349,26 +433,25 @@ func parsePersonWithoutInfoBoxOrSection(ctx context.Context, tree *html.Node, hr
 		return txt == title
 	})
 	if bNode == nil {
-		err = fmt.Errorf("no infobox or bolded person name in %s", href)
-		return
+		return nil, fmt.Errorf("no infobox or bolded person name in %s", href)
 	}
 
-	fullname, err = htree.Text(bNode)
+	p := &parsedPerson{parser: ParserBold}
+
+	var err error
+	p.fullname, err = htree.Text(bNode)
 	if err != nil {
-		err = errors.Wrap(err, "converting fullname to text")
-		return
+		return nil, errors.Wrap(err, "converting fullname to text")
 	}
-	if fullname != title {
-		err = fmt.Errorf(`found fullname "%s", which does not match title "%s"`, fullname, title)
-		return
+	if p.fullname != title {
+		return nil, fmt.Errorf(`found fullname "%s", which does not match title "%s"`, p.fullname, title)
 	}
 
 	buf := new(bytes.Buffer)
 	for tNode := bNode.NextSibling; tNode != nil; tNode = tNode.NextSibling {
 		err = htree.WriteText(buf, tNode)
 		if err != nil {
-			err = errors.Wrap(err, "converting intro text to plain text")
-			return
+			return nil, errors.Wrap(err, "converting intro text to plain text")
 		}
 	}
 	tNodeText := buf.String()
@@ -378,24 +461,28 @@ func parsePersonWithoutInfoBoxOrSection(ctx context.Context, tree *html.Node, hr
 		m = maybeBornDied2.FindStringSubmatch(tNodeText)
 	}
 	if len(m) == 0 {
-		err = fmt.Errorf("found bolded person name but no dates in %s", href)
-		return
+		return nil, fmt.Errorf("found bolded person name but %w in %s", errNoDates, href)
 	}
 
-	bornY, bornM, bornD, err = parseDate(m[1])
+	p.born, err = parseDate(m[1])
 	if err != nil {
-		err = errors.Wrapf(err, "parsing birth date in %s", href)
-		return
+		return nil, errors.Wrapf(err, "parsing birth date in %s", href)
 	}
 
-	diedY, diedM, diedD, err = parseDate(m[2])
-	err = errors.Wrapf(err, "parsing death date in %s", href)
+	p.died, err = parseDate(m[2])
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing death date in %s", href)
+	}
 
-	return
+	return p, nil
 }
 
 var nameRegex = regexp.MustCompile(`[^/]+$`)
 
+// This is a variable so tests can replace it,
+// keeping pageviews URLs (and so recorded responses) stable.
+var timeNow = time.Now
+
 func scrapePageviews(ctx context.Context, client *http.Client, href string) (int, error) {
 	m := nameRegex.FindString(href)
 	if m == "" {
@@ -403,7 +490,7 @@ func scrapePageviews(ctx context.Context, client *http.Client, href string) (int
 	}
 
 	var (
-		now       = time.Now()
+		now       = timeNow()
 		yesterday = now.Add(-24 * time.Hour)
 		start     = yesterday.Add(-90 * 24 * time.Hour) // 90 days before yesterday
 	)
@@ -490,74 +577,189 @@ func findFullName(node *html.Node) (string, error) {
 	return strings.Join(strings.Fields(txt), " "), nil
 }
 
-func findDateRow(node *html.Node, label string) (year, mon, day int, err error) {
+func findDateRow(node *html.Node, label string) (Date, error) {
 	if node.Type == html.ElementNode && node.DataAtom == atom.Th {
-		var txt string
-		txt, err = htree.Text(node)
+		txt, err := htree.Text(node)
 		if err != nil {
-			err = errors.Wrap(err, "converting to text")
-			return
+			return Date{}, errors.Wrap(err, "converting to text")
 		}
 		if txt != label {
-			return 0, 0, 0, errNotFound
+			return Date{}, errNotFound
 		}
 		td := node.NextSibling
 		if td == nil || td.Type != html.ElementNode || td.DataAtom != atom.Td {
-			return 0, 0, 0, errNotFound
+			return Date{}, errNotFound
 		}
 		txt, err = htree.Text(td)
 		if err != nil {
-			err = errors.Wrap(err, "converting to text")
-			return
+			return Date{}, errors.Wrap(err, "converting to text")
 		}
 		return parseDate(txt)
 	}
 	if node.Type == html.TextNode {
-		return 0, 0, 0, errNotFound
+		return Date{}, errNotFound
 	}
 	for child := node.FirstChild; child != nil; child = child.NextSibling {
-		if year, mon, day, err = findDateRow(child, label); err == nil {
-			return
+		if d, err := findDateRow(child, label); err == nil {
+			return d, nil
 		}
 	}
-	return 0, 0, 0, errNotFound
+	return Date{}, errNotFound
 }
 
-func parseDate(s string) (year, mon, day int, err error) {
-	if m := dateRegex1.FindStringSubmatch(s); m != nil {
-		return parseDate2(m[3], m[1], m[2], m[4])
-	}
-	if m := dateRegex2.FindStringSubmatch(s); m != nil {
-		return parseDate2(m[3], m[2], m[1], m[4])
+// Function parseDate parses the first date in s,
+// which looks like "March 1, 1620" or "1 March 1620",
+// or less precisely "March 1620", "1620", or "1620s",
+// any of which may be preceded by "c." (circa).
+// The result is in the Julian calendar if it has a dual year (as in "11 February 1731/32"),
+// if it is marked O.S.,
+// or if it is unmarked and earlier than GregorianStart.
+// Otherwise it is Gregorian.
+// The most precise form of date found in s is preferred,
+// since s may contain other numbers
+// (such as the machine-readable "(1567-02-12)" that precedes many infobox dates).
+func parseDate(s string) (Date, error) {
+	for _, re := range dateRegexes {
+		matches := re.FindAllStringSubmatchIndex(s, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		var firstErr error
+		for _, m := range matches {
+			group := func(name string) string {
+				i := re.SubexpIndex(name)
+				if i < 0 || m[2*i] < 0 {
+					return ""
+				}
+				return s[m[2*i]:m[2*i+1]]
+			}
+			d, err := parseDate2(group)
+			if err == nil {
+				return d, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return Date{}, firstErr
 	}
-	return 0, 0, 0, errNotFound
+	return Date{}, errNotFound
 }
 
-func parseDate2(yearStr, monStr, dayStr, bcStr string) (year, mon, day int, err error) {
-	for i := 1; i <= 12; i++ {
-		if monStr == monthName[i] {
-			mon = i
-			break
+// Function parseDate2 builds a Date from the named groups of a match of one of dateRegexes.
+func parseDate2(group func(string) string) (Date, error) {
+	var (
+		yearStr = group("year")
+		monStr  = group("mon")
+		dayStr  = group("day")
+	)
+
+	year, err := strconv.Atoi(yearStr) // TODO: range check?
+	if err != nil {
+		return Date{}, errors.Wrap(err, "parsing year")
+	}
+	date := Date{Y: year, M: time.January, D: 1, Circa: group("circa") != ""}
+
+	switch {
+	case dayStr != "":
+		date.Prec = DayPrecision
+	case monStr != "":
+		date.Prec = MonthPrecision
+	case group("decade") != "":
+		date.Prec = DecadePrecision
+	default:
+		date.Prec = YearPrecision
+	}
+
+	if date.Prec != DayPrecision && !date.Circa && group("bc") == "" && len(yearStr) < 3 {
+		// Probably not a year at all, but some other number,
+		// like a footnote or an age.
+		return Date{}, fmt.Errorf("%s is not a year", yearStr)
+	}
+	if date.Prec == DecadePrecision && year%10 != 0 {
+		return Date{}, fmt.Errorf("%ss is not a decade", yearStr)
+	}
+
+	if monStr != "" {
+		date.M = 0
+		for i := 1; i <= 12; i++ {
+			if monStr == monthName[i] {
+				date.M = time.Month(i)
+				break
+			}
+		}
+		if date.M == 0 {
+			return Date{}, fmt.Errorf("parsing month %s", monStr)
 		}
 	}
-	if mon == 0 {
-		return 0, 0, 0, errors.Wrap(err, "parsing month")
+	if dayStr != "" {
+		date.D, err = strconv.Atoi(dayStr)
+		if err != nil {
+			return Date{}, errors.Wrap(err, "parsing day")
+		}
 	}
-	day, err = strconv.Atoi(dayStr)
-	if err != nil {
-		return 0, 0, 0, errors.Wrap(err, "parsing day")
+
+	if group("bc") != "" {
+		// There is no year 0 in the BC/AD scheme.
+		// 1 BC is followed directly by AD 1.
+		// So in a BC decade like the 40s BC (49 BC through 40 BC),
+		// the earliest year is 49 BC.
+		if date.Prec == DecadePrecision {
+			year += 9
+		}
+		date.Y = 1 - year
 	}
-	year, err = strconv.Atoi(yearStr) // TODO: range check?
+
+	switch {
+	case group("dual") != "":
+		// Before 1752 in Britain and its colonies,
+		// the year began on 25 March,
+		// and dates from 1 January to 24 March were often written with both years,
+		// as in 11 February 1731/32.
+		// The second is the year as counted from 1 January.
+		date.Y, err = dualYear(date.Y, group("dual"))
+		if err != nil {
+			return Date{}, errors.Wrap(err, "parsing dual year")
+		}
+		date.Cal = Julian
+
+	case group("osns") != "":
+		m := osnsRegex.FindStringSubmatch(group("osns"))
+		// A marker followed by another date (as in "22 February 1732 [O.S. 11 February 1731]")
+		// describes that other date, not this one.
+		if (m[1] == "O") == (m[2] == "") {
+			date.Cal = Julian
+		}
+
+	case date.Since(GregorianStart) < 0:
+		date.Cal = Julian
+	}
+
+	if date.D < 1 || date.D > date.Cal.daysInMonth(date.Y, date.M) {
+		return Date{}, fmt.Errorf("day %d out of range", date.D)
+	}
+	return date, nil
+}
+
+// Function dualYear produces the second year in a dual year like 1731/32,
+// given the first year and the (possibly abbreviated) second.
+func dualYear(year int, suffix string) (int, error) {
+	n, err := strconv.Atoi(suffix)
 	if err != nil {
-		return 0, 0, 0, errors.Wrap(err, "parsing year")
+		return 0, err
+	}
+	mod := 1
+	for range suffix {
+		mod *= 10
 	}
-	if bcStr != "" {
-		year = -year
+	result := year - year%mod + n
+	if result < year {
+		result += mod
 	}
-	if day < 1 || day > daysInMonth(year, time.Month(mon)) {
-		return 0, 0, 0, fmt.Errorf("day %d out of range", day)
+	if result != year+1 {
+		return 0, fmt.Errorf("%d/%s is not a dual year", year, suffix)
 	}
-	return
+	return result, nil
 }
 
 func foreachDeathsUL(node *html.Node, f func(*html.Node) error) error {
@@ -5,8 +5,11 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/bobg/htree"
 	"golang.org/x/net/html"
@@ -282,17 +285,20 @@ func TestParsePerson(t *testing.T) {
 		title        string
 		wantFullname string
 		wantImgSrc   string
+		wantParser   Parser
 		wantErrStr   string
 	}{
 		{
 			srcfile:      "testdata/thomascampion.html",
 			title:        "Thomas Campion",
 			wantFullname: "Thomas Campion",
+			wantParser:   ParserIntro,
 		},
 		{
 			srcfile:      "testdata/vincenzogalilei.html",
 			title:        "Vincenzo Galilei",
 			wantFullname: "Vincenzo Galilei",
+			wantParser:   ParserBold,
 		},
 	}
 
@@ -308,7 +314,7 @@ func TestParsePerson(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			fullname, imgSrc, _ /* imgAlt */, _ /* bornY */, _ /* bornM */, _ /* bornD */, _ /* diedY */, _ /* diedM */, _ /* diedD */, err := parsePerson(ctx, tree, "testHref", c.title)
+			p, err := parsePerson(ctx, tree, "testHref", c.title)
 			if err != nil {
 				if c.wantErrStr == "" {
 					t.Fatal(err)
@@ -321,11 +327,164 @@ func TestParsePerson(t *testing.T) {
 			if c.wantErrStr != "" {
 				t.Fatalf("got no error, want ...%s...", c.wantErrStr)
 			}
-			if fullname != c.wantFullname {
-				t.Errorf("got fullname %s, want %s", fullname, c.wantFullname)
+			if p.fullname != c.wantFullname {
+				t.Errorf("got fullname %s, want %s", p.fullname, c.wantFullname)
 			}
-			if imgSrc != c.wantImgSrc {
-				t.Errorf("got imgsrc %s, want %s", imgSrc, c.wantImgSrc)
+			if p.imgSrc != c.wantImgSrc {
+				t.Errorf("got imgsrc %s, want %s", p.imgSrc, c.wantImgSrc)
+			}
+			if p.parser != c.wantParser {
+				t.Errorf("got parser %s, want %s", p.parser, c.wantParser)
+			}
+		})
+	}
+}
+
+// Set timeNow to the day the responses in testdata/http were recorded,
+// so requests for pageviews match.
+func replayClient(t *testing.T) *http.Client {
+	oldTimeNow := timeNow
+	timeNow = func() time.Time { return time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC) }
+	t.Cleanup(func() { timeNow = oldTimeNow })
+
+	return &http.Client{Transport: &ReplayTransport{Dir: "testdata/http"}}
+}
+
+func TestParseDate(t *testing.T) {
+	cases := []struct {
+		inp     string
+		want    Date
+		wantErr bool
+	}{
+		{inp: "March 1, 1620", want: Date{Y: 1620, M: time.March, D: 1}},
+		{inp: "12 February 1567", want: Date{Y: 1567, M: time.February, D: 12, Cal: Julian}},
+		{inp: "4 October 1582", want: Date{Y: 1582, M: time.October, D: 4, Cal: Julian}},
+		{inp: "15 October 1582", want: Date{Y: 1582, M: time.October, D: 15}},
+		{inp: "15 March 44 BC", want: Date{Y: -43, M: time.March, D: 15, Cal: Julian}},
+		{inp: "22 February 1732 [O.S. 11 February 1731]", want: Date{Y: 1732, M: time.February, D: 22}},
+		{inp: "11 February 1731/32", want: Date{Y: 1732, M: time.February, D: 11, Cal: Julian}},
+		{inp: "20 March 1699/1700", want: Date{Y: 1700, M: time.March, D: 20, Cal: Julian}},
+		{inp: "29 February 1699/00", want: Date{Y: 1700, M: time.February, D: 29, Cal: Julian}},
+		{inp: "10 March 1731/33", wantErr: true},
+		{inp: "1 March 1620 (O.S.)", want: Date{Y: 1620, M: time.March, D: 1, Cal: Julian}},
+		{inp: "5 January 1600 N.S.", want: Date{Y: 1600, M: time.January, D: 5}},
+		{inp: "29 February 1700", wantErr: true},
+		{inp: "29 February 1700 (O.S.)", want: Date{Y: 1700, M: time.February, D: 29, Cal: Julian}},
+		{inp: "(1567-02-12)12 February 1567", want: Date{Y: 1567, M: time.February, D: 12, Cal: Julian}},
+		{inp: "c. 12 March 1650", want: Date{Y: 1650, M: time.March, D: 12, Circa: true}},
+		{inp: "March 1450", want: Date{Y: 1450, M: time.March, D: 1, Cal: Julian, Prec: MonthPrecision}},
+		{inp: "c. 1450", want: Date{Y: 1450, M: time.January, D: 1, Cal: Julian, Prec: YearPrecision, Circa: true}},
+		{inp: "circa 980", want: Date{Y: 980, M: time.January, D: 1, Cal: Julian, Prec: YearPrecision, Circa: true}},
+		{inp: "1450s", want: Date{Y: 1450, M: time.January, D: 1, Cal: Julian, Prec: DecadePrecision}},
+		{inp: "1455s", wantErr: true},
+		{inp: "40s BC", want: Date{Y: -48, M: time.January, D: 1, Cal: Julian, Prec: DecadePrecision}},
+		{inp: "1620 (aged 53)", want: Date{Y: 1620, M: time.January, D: 1, Prec: YearPrecision}},
+		{inp: "aged 53", wantErr: true},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case_%02d", i), func(t *testing.T) {
+			got, err := parseDate(c.inp)
+			if c.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestScrapeDay(t *testing.T) {
+	client := replayClient(t)
+
+	type person struct{ href, title, desc string }
+	var got []person
+
+	err := ScrapeDay(context.Background(), client, time.March, 1, func(ctx context.Context, href, title, desc string) error {
+		got = append(got, person{href: href, title: title, desc: desc})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []person{
+		{href: "Thomas_Campion", title: "Thomas Campion", desc: "English poet and composer"},
+		{href: "George_Herbert", title: "George Herbert", desc: "Welsh-born English poet, orator, and priest"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestScrapePerson(t *testing.T) {
+	cases := []struct {
+		href, title string
+		want        Figure
+		wantParser  Parser
+		wantHref    string
+	}{
+		{
+			href:  "Thomas_Campion",
+			title: "Thomas Campion",
+			want: Figure{
+				Link:      "Thomas_Campion",
+				Name:      "Thomas Campion",
+				Desc:      "English poet and composer",
+				Born:      Date{Y: 1567, M: time.February, D: 12, Cal: Julian},
+				Died:      Date{Y: 1620, M: time.March, D: 1},
+				DaysAlive: 19366,
+				Pageviews: 605,
+			},
+			wantParser: ParserIntro,
+			wantHref:   "Thomas_Campion",
+		},
+		{
+			// This one redirects (via the Content-Location header) to Vincenzo_Galilei.
+			href:  "Galilei",
+			title: "Vincenzo Galilei",
+			want: Figure{
+				Link:      "Vincenzo_Galilei",
+				Name:      "Vincenzo Galilei",
+				Desc:      "Italian lutenist and composer",
+				Born:      Date{Y: 1520, M: time.April, D: 3, Cal: Julian},
+				Died:      Date{Y: 1591, M: time.July, D: 2},
+				DaysAlive: 26012,
+				Pageviews: 808,
+			},
+			wantParser: ParserBold,
+			wantHref:   "Vincenzo_Galilei",
+		},
+	}
+
+	client := replayClient(t)
+
+	for _, c := range cases {
+		t.Run(c.href, func(t *testing.T) {
+			res, err := ScrapePerson(context.Background(), client, c.href, c.title, c.want.Desc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Parser != c.wantParser {
+				t.Errorf("got parser %s, want %s", res.Parser, c.wantParser)
+			}
+			if res.Href != c.wantHref {
+				t.Errorf("got href %s, want %s", res.Href, c.wantHref)
+			}
+			if res.OrigHref != c.href || res.OrigTitle != c.title {
+				t.Errorf("got original href and title %s, %s; want %s, %s", res.OrigHref, res.OrigTitle, c.href, c.title)
+			}
+			fig := *res.Figure
+			fig.Updated = time.Time{}
+			if fig != c.want {
+				t.Errorf("got %+v, want %+v", fig, c.want)
 			}
 		})
 	}
@@ -0,0 +1,84 @@
+package outlived
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ScrapeErrorClass classifies the errors of ScrapeFigure
+// (see ScrapeErrorClassOf).
+type ScrapeErrorClass string
+
+const (
+	// ScrapeErrorHTTP means a page could not be fetched.
+	ScrapeErrorHTTP ScrapeErrorClass = "http"
+
+	// ScrapeErrorParse means a page was fetched but could not be understood.
+	ScrapeErrorParse ScrapeErrorClass = "parse"
+
+	// ScrapeErrorNoDates means a page was understood
+	// but did not give the figure's birth and death dates.
+	ScrapeErrorNoDates ScrapeErrorClass = "nodates"
+
+	// ScrapeErrorPageviews means the figure's pageviews could not be gotten.
+	ScrapeErrorPageviews ScrapeErrorClass = "pageviews"
+
+	// ScrapeErrorOther is any other error.
+	ScrapeErrorOther ScrapeErrorClass = "other"
+)
+
+// ParseScrapeErrorClass parses a ScrapeErrorClass.
+func ParseScrapeErrorClass(s string) (ScrapeErrorClass, error) {
+	switch c := ScrapeErrorClass(s); c {
+	case ScrapeErrorHTTP, ScrapeErrorParse, ScrapeErrorNoDates, ScrapeErrorPageviews, ScrapeErrorOther:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown scrape error class %s", s)
+}
+
+// ScrapeErrorClassOf gives the class of an error from ScrapeFigure
+// (or ScrapePerson, ScrapeWikidata, or UpdateFigure).
+func ScrapeErrorClassOf(err error) ScrapeErrorClass {
+	var e *scrapeError
+	if errors.As(err, &e) {
+		return e.class
+	}
+	return ScrapeErrorOther
+}
+
+// Type scrapeError is an error with a ScrapeErrorClass.
+type scrapeError struct {
+	class ScrapeErrorClass
+	err   error
+}
+
+func (e *scrapeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *scrapeError) Unwrap() error {
+	return e.err
+}
+
+// Function classify gives err the given class
+// (or returns nil if err is nil).
+func classify(class ScrapeErrorClass, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &scrapeError{class: class, err: err}
+}
+
+// The error of a page that has no dates where they were expected.
+var errNoDates = errors.New("no dates")
+
+// Function classifyParseError classifies an error from parsing a page,
+// as ScrapeErrorNoDates if dates (or other required parts) are missing
+// and otherwise as ScrapeErrorParse.
+func classifyParseError(err error) error {
+	if errors.Is(err, errNotFound) || errors.Is(err, errNoDates) {
+		return classify(ScrapeErrorNoDates, err)
+	}
+	return classify(ScrapeErrorParse, err)
+}
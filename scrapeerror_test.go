@@ -0,0 +1,102 @@
+package outlived
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// Type fakeTransport responds to requests for Wikipedia pages with the given status and body,
+// and fails all other requests.
+type fakeTransport struct {
+	status int
+	body   string
+}
+
+func (t *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "en.wikipedia.org" {
+		return nil, fmt.Errorf("no response for %s", req.URL)
+	}
+	return &http.Response{
+		StatusCode: t.status,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(t.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestScrapeErrorClass(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		want   ScrapeErrorClass
+	}{
+		{
+			name:   "not_found",
+			status: http.StatusNotFound,
+			want:   ScrapeErrorHTTP,
+		},
+		{
+			name:   "no_dates",
+			status: http.StatusOK,
+			body:   `<html><body><section><p><b>Jane Doe</b> was a person.</p></section></body></html>`,
+			want:   ScrapeErrorNoDates,
+		},
+		{
+			name:   "infobox_without_dates",
+			status: http.StatusOK,
+			body:   `<html><body><table class="infobox"><tr><th>Occupation</th><td>Poet</td></tr></table></body></html>`,
+			want:   ScrapeErrorNoDates,
+		},
+		{
+			name:   "pageviews",
+			status: http.StatusOK,
+			body:   `<html><body><section><p><b>Jane Doe</b> (1 March 1900 – 2 April 1980) was a person.</p></section></body></html>`,
+			want:   ScrapeErrorPageviews,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := &http.Client{Transport: &fakeTransport{status: c.status, body: c.body}}
+			_, err := ScrapePerson(context.Background(), client, "Jane_Doe", "Jane Doe", "")
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if got := ScrapeErrorClassOf(err); got != c.want {
+				t.Errorf("got class %s, want %s (error: %s)", got, c.want, err)
+			}
+		})
+	}
+}
+
+func TestScrapeErrorClassOf(t *testing.T) {
+	err := errors.Wrap(classify(ScrapeErrorParse, errors.New("x")), "wrapped")
+	if got := ScrapeErrorClassOf(err); got != ScrapeErrorParse {
+		t.Errorf("got %s, want %s", got, ScrapeErrorParse)
+	}
+	if got := ScrapeErrorClassOf(errors.New("x")); got != ScrapeErrorOther {
+		t.Errorf("got %s, want %s", got, ScrapeErrorOther)
+	}
+	if classify(ScrapeErrorHTTP, nil) != nil {
+		t.Error("classify(nil) is not nil")
+	}
+
+	for _, c := range []ScrapeErrorClass{ScrapeErrorHTTP, ScrapeErrorParse, ScrapeErrorNoDates, ScrapeErrorPageviews, ScrapeErrorOther} {
+		got, err := ParseScrapeErrorClass(string(c))
+		if err != nil {
+			t.Error(err)
+		} else if got != c {
+			t.Errorf("got %s, want %s", got, c)
+		}
+	}
+	if _, err := ParseScrapeErrorClass("bogus"); err == nil {
+		t.Error("got no error parsing a bogus class")
+	}
+}
@@ -0,0 +1,45 @@
+package outlived
+
+import "time"
+
+// ScrapeRun is a record of a scrape of all figures,
+// which proceeds as one task for each day of the year
+// and one for each person who died on that day.
+// It is stored in the datastore, keyed by its ID (see ScrapeRunID),
+// with the outcome of each day and person stored as its children
+// (see ScrapeDayOutcome and ScrapePersonOutcome).
+type ScrapeRun struct {
+	Start time.Time
+	End   time.Time // zero while the run is in progress
+	Days  int       // the number of days enqueued
+}
+
+// ScrapeRunID gives the ID of the ScrapeRun starting at the given time.
+// IDs sort in order of starting time.
+func ScrapeRunID(start time.Time) string {
+	return start.UTC().Format("20060102T150405Z")
+}
+
+// ScrapeDayOutcome is the outcome of scraping one day's page in a ScrapeRun.
+// Its key is "M/D" (e.g. "1/2" for January 2),
+// with the ScrapeRun's key as parent.
+type ScrapeDayOutcome struct {
+	Time    time.Time
+	Persons int    // the number of persons found on the day's page
+	Err     string `datastore:",noindex"`
+}
+
+// ScrapePersonOutcome is the outcome of scraping one person in a ScrapeRun.
+// Its key is the href of the person's page,
+// with the ScrapeRun's key as parent.
+// Failures (with OK false) make a log of the run's problems,
+// from which the failed persons can be retried.
+type ScrapePersonOutcome struct {
+	Time  time.Time
+	Href  string `datastore:",noindex"`
+	Title string `datastore:",noindex"`
+	Desc  string `datastore:",noindex"`
+	OK    bool
+	Class ScrapeErrorClass // of the error, if not OK
+	Err   string           `datastore:",noindex"`
+}
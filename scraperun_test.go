@@ -0,0 +1,24 @@
+package outlived
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScrapeRunID(t *testing.T) {
+	var (
+		start = time.Date(2024, time.March, 5, 1, 0, 7, 0, time.UTC)
+		later = start.Add(26 * time.Hour)
+	)
+
+	id := ScrapeRunID(start)
+	if id != "20240305T010007Z" {
+		t.Errorf("got %s, want 20240305T010007Z", id)
+	}
+	if got := ScrapeRunID(start.In(time.FixedZone("X", -8*3600))); got != id {
+		t.Errorf("got %s in another time zone, want %s", got, id)
+	}
+	if laterID := ScrapeRunID(later); laterID <= id {
+		t.Errorf("later ID %s does not sort after %s", laterID, id)
+	}
+}
@@ -0,0 +1,206 @@
+package site
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobg/mid"
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+const (
+	defaultCalendarDays  = 90
+	maxCalendarDays      = 366
+	defaultCalendarLimit = 50
+	maxCalendarLimit     = 500
+)
+
+// Function handleCalendar serves an iCalendar feed of the figures a user will outlive.
+// It needs no session, since calendar apps don't have one;
+// instead it is authenticated by a token in the URL (see tokenURL).
+// Optional parameters "days" and "limit" control the horizon of the feed
+// and the number of figures in it
+// (the most popular ones within the horizon, in chronological order;
+// see outlived.PopularOutlivings).
+func (s *Server) handleCalendar(w http.ResponseWriter, req *http.Request) error {
+	ctx := req.Context()
+
+	u, err := s.tokenUser(req, calendarTokenPurpose)
+	if err != nil {
+		return err
+	}
+
+	days, err := intParam(req, "days", defaultCalendarDays, maxCalendarDays)
+	if err != nil {
+		return err
+	}
+	limit, err := intParam(req, "limit", defaultCalendarLimit, maxCalendarLimit)
+	if err != nil {
+		return err
+	}
+
+	// Include today, in case the calendar app last refreshed yesterday.
+	// The user's time zone is unknown, so "today" is as of UTC.
+	today := outlived.TimeDate(time.Now().UTC())
+	upcoming, err := outlived.PopularOutlivings(ctx, s.figures, u.Born, today.AddDays(-1), days+1, limit)
+	if err != nil {
+		return errors.Wrapf(err, "getting upcoming outlivings for %s", u.Email)
+	}
+
+	cal := &icalendar{
+		name:   "Outlived",
+		desc:   "Notable figures you will outlive",
+		stamp:  time.Now(),
+		events: make([]icalEvent, 0, len(upcoming)),
+	}
+	for _, o := range upcoming {
+		fig := o.Figure
+		r, err := rlink(fig.Link)
+		if err != nil {
+			return errors.Wrapf(err, "making link for %s", fig.Link)
+		}
+
+		desc := fig.Name
+		if fig.Desc != "" {
+			desc += ", " + fig.Desc
+		}
+		desc += fmt.Sprintf(", %s—%s (lived %s). %s", fig.Born, fig.Died, fig.Died.YDSinceStr(fig.Born), r)
+
+		cal.events = append(cal.events, icalEvent{
+			uid:     fmt.Sprintf("%s-%s@outlived.net", o.Date.YYYYMMDD(), fig.Link),
+			date:    o.Date,
+			summary: "You outlive " + fig.Name,
+			desc:    desc,
+			url:     r.String(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	_, err = cal.WriteTo(w)
+	return errors.Wrap(err, "writing calendar")
+}
+
+// Function intParam parses the optional integer request parameter with the given name.
+// It returns def if the parameter is absent
+// and clamps the value to max.
+func intParam(req *http.Request, name string, def, max int) (int, error) {
+	s := req.FormValue(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, mid.CodeErr{C: http.StatusBadRequest, Err: fmt.Errorf("bad %s parameter %s", name, s)}
+	}
+	if n > max {
+		n = max
+	}
+	return n, nil
+}
+
+// Type icalendar is a minimal iCalendar (RFC 5545) document
+// of all-day events.
+type icalendar struct {
+	name, desc string
+	stamp      time.Time
+	events     []icalEvent
+}
+
+type icalEvent struct {
+	uid           string
+	date          outlived.Date
+	summary, desc string
+	url           string
+}
+
+func (c *icalendar) WriteTo(w io.Writer) (int64, error) {
+	iw := &icalWriter{w: w}
+
+	iw.line("BEGIN", "VCALENDAR")
+	iw.line("VERSION", "2.0")
+	iw.line("PRODID", "-//outlived.net//Outlived//EN")
+	iw.line("CALSCALE", "GREGORIAN")
+	iw.line("METHOD", "PUBLISH")
+	iw.line("X-WR-CALNAME", icalText(c.name))
+	iw.line("X-WR-CALDESC", icalText(c.desc))
+
+	stamp := c.stamp.UTC().Format("20060102T150405Z")
+	for _, ev := range c.events {
+		iw.line("BEGIN", "VEVENT")
+		iw.line("UID", ev.uid)
+		iw.line("DTSTAMP", stamp)
+		iw.line("DTSTART;VALUE=DATE", icalDate(ev.date))
+		iw.line("DTEND;VALUE=DATE", icalDate(ev.date.AddDays(1)))
+		iw.line("SUMMARY", icalText(ev.summary))
+		iw.line("DESCRIPTION", icalText(ev.desc))
+		if ev.url != "" {
+			iw.line("URL", ev.url)
+		}
+		iw.line("TRANSP", "TRANSPARENT")
+		iw.line("END", "VEVENT")
+	}
+
+	iw.line("END", "VCALENDAR")
+
+	return iw.n, iw.err
+}
+
+// Type icalWriter writes iCalendar content lines,
+// folding them at 75 octets as RFC 5545 requires.
+type icalWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+const icalLineLen = 75
+
+func (iw *icalWriter) line(name, value string) {
+	if iw.err != nil {
+		return
+	}
+	s := name + ":" + value
+
+	var buf strings.Builder
+	limit := icalLineLen
+	for len(s) > limit {
+		// Don't split a UTF-8 sequence.
+		i := limit
+		for i > 0 && s[i]&0xc0 == 0x80 {
+			i--
+		}
+		buf.WriteString(s[:i])
+		buf.WriteString("\r\n ")
+		s = s[i:]
+		limit = icalLineLen - 1 // allow for the leading space
+	}
+	buf.WriteString(s)
+	buf.WriteString("\r\n")
+
+	n, err := io.WriteString(iw.w, buf.String())
+	iw.n += int64(n)
+	iw.err = err
+}
+
+func icalDate(d outlived.Date) string {
+	d = d.In(outlived.Gregorian)
+	return fmt.Sprintf("%04d%02d%02d", d.Y, d.M, d.D)
+}
+
+var icalTextReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\n", `\n`,
+	"\r", "",
+)
+
+func icalText(s string) string {
+	return icalTextReplacer.Replace(s)
+}
@@ -0,0 +1,66 @@
+package site
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"outlived"
+)
+
+func TestICalendar(t *testing.T) {
+	cal := &icalendar{
+		name:  "Outlived",
+		desc:  "Test",
+		stamp: time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC),
+		events: []icalEvent{{
+			uid:     "2024-06-02-Thomas_Campion@outlived.net",
+			date:    outlived.Date{Y: 2024, M: time.June, D: 2},
+			summary: "You outlive Thomas Campion",
+			desc:    "Thomas Campion, English poet and composer; 12 Feb 1567—1 Mar 1620 (lived 53 years, 7 days). https://outlived.net/r?w=Thomas_Campion",
+			url:     "https://outlived.net/r?w=Thomas_Campion",
+		}},
+	}
+
+	buf := new(bytes.Buffer)
+	n, err := cal.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, wrote %d bytes", n, buf.Len())
+	}
+
+	want := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//outlived.net//Outlived//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Outlived",
+		"X-WR-CALDESC:Test",
+		"BEGIN:VEVENT",
+		"UID:2024-06-02-Thomas_Campion@outlived.net",
+		"DTSTAMP:20240601T120000Z",
+		"DTSTART;VALUE=DATE:20240602",
+		"DTEND;VALUE=DATE:20240603",
+		"SUMMARY:You outlive Thomas Campion",
+		"DESCRIPTION:Thomas Campion\\, English poet and composer\\; 12 Feb 1567—1 Ma",
+		" r 1620 (lived 53 years\\, 7 days). https://outlived.net/r?w=Thomas_Campion",
+		"URL:https://outlived.net/r?w=Thomas_Campion",
+		"TRANSP:TRANSPARENT",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		if len(line) > icalLineLen {
+			t.Errorf("line too long (%d octets): %s", len(line), line)
+		}
+	}
+}
@@ -33,6 +33,10 @@ type (
 		DaysAlive      int    `json:"daysAlive"`
 		YearsDaysAlive string `json:"yearsDaysAlive"`
 
+		// Approx means DaysAlive and YearsDaysAlive are estimates,
+		// because Born or Died is not exactly known.
+		Approx bool `json:"approx"`
+
 		Href   string `json:"href"`
 		ImgAlt string `json:"imgAlt"`
 		ImgSrc string `json:"imgSrc"`
@@ -48,6 +52,20 @@ type (
 		Figures        []figureData `json:"figures"`
 		Verified       bool         `json:"verified"`
 		Active         bool         `json:"active"`
+
+		// Categories limit the figures in the daily mail (see outlived.User).
+		Categories []string `json:"categories"`
+
+		// Mail preferences (see outlived.User and handleSetPrefs).
+		SendHour       int    `json:"sendHour"`
+		Frequency      string `json:"frequency"`
+		FiguresPerMail int    `json:"figuresPerMail"`
+
+		// CalendarURL is the user's iCalendar feed of upcoming outlivings.
+		CalendarURL string `json:"calendarURL"`
+
+		// FeedURL is the user's Atom feed of figures outlived today.
+		FeedURL string `json:"feedURL"`
 	}
 )
 
@@ -64,7 +82,7 @@ func (s *Server) handleData(
 		sess  = getSess(ctx)
 	)
 
-	figures, err := outlived.FiguresDiedOn(ctx, s.dsClient, today.M, today.D, 24)
+	figures, err := s.figures.FiguresDiedOn(ctx, today.M, today.D, 24)
 	if err != nil {
 		return nil, errors.Wrapf(err, "getting figures that died on %d %s", today.D, today.M)
 	}
@@ -102,6 +120,15 @@ func (s *Server) getUserData2(ctx context.Context, sess *aesite.Session, u *outl
 
 	alive := today.Since(u.Born)
 
+	calURL, err := tokenURL(u, "/s/calendar.ics", calendarTokenPurpose)
+	if err != nil {
+		return nil, nil, err
+	}
+	feedURL, err := tokenURL(u, "/s/outlived.atom", feedTokenPurpose)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	d := &userData{
 		CSRF:           csrf,
 		Born:           u.Born.String(),
@@ -111,9 +138,15 @@ func (s *Server) getUserData2(ctx context.Context, sess *aesite.Session, u *outl
 		Email:          u.Email,
 		Verified:       u.Verified,
 		Active:         u.Active,
+		Categories:     u.Categories,
+		SendHour:       u.SendHour,
+		Frequency:      string(u.Frequency),
+		FiguresPerMail: u.FiguresPerMail,
+		CalendarURL:    calURL.String(),
+		FeedURL:        feedURL.String(),
 	}
 
-	figures, err := outlived.FiguresAliveForAtMost(ctx, s.dsClient, alive-1, 24)
+	figures, err := s.figures.FiguresAliveForAtMost(ctx, alive-1, 24)
 	if err != nil {
 		return nil, nil, errors.Wrapf(err, "getting figures that died %d days ago", alive-1)
 	}
@@ -126,24 +159,35 @@ func (s *Server) getUserData2(ctx context.Context, sess *aesite.Session, u *outl
 }
 
 func (s *Server) toFigureData(figure *outlived.Figure) figureData {
-	return figureData{
+	f := figureData{
 		Name:           figure.Name,
 		Desc:           figure.Desc,
 		Born:           figure.Born.String(),
 		Died:           figure.Died.String(),
 		DaysAlive:      figure.DaysAlive,
 		YearsDaysAlive: figure.Died.YDSinceStr(figure.Born),
+		Approx:         figure.Approx(),
 		Href:           "https://en.wikipedia.org/wiki/" + figure.Link,
 		ImgAlt:         figure.ImgAlt,
 		ImgSrc:         figure.ImgSrc,
 	}
+	if f.Approx {
+		f.YearsDaysAlive = "approximately " + f.YearsDaysAlive
+	}
+	return f
 }
 
 func tzNow(tzname string) time.Time {
+	return time.Now().In(loadLocation(tzname))
+}
+
+// Function loadLocation loads the time zone with the given IANA name,
+// falling back to UTC.
+func loadLocation(tzname string) *time.Location {
 	loc, err := time.LoadLocation(tzname)
 	if err != nil {
 		log.Printf("loading timezone %s (falling back to UTC): %s", tzname, err)
-		loc = time.UTC
+		return time.UTC
 	}
-	return time.Now().In(loc)
+	return loc
 }
@@ -3,18 +3,32 @@ package site
 import (
 	"log"
 	"net/http"
-
-	"outlived"
 )
 
-// Function handleExpire expires stale figures.
+// Function handleExpire expires stale figures
+// and old send records and scrape runs
+// (see expireSendRecords and expireScrapeRuns).
 func (s *Server) handleExpire(w http.ResponseWriter, req *http.Request) error {
 	err := s.checkCron(req)
 	if err != nil {
 		return err
 	}
 
-	count, err := outlived.ExpireFigures(req.Context(), s.dsClient)
+	ctx := req.Context()
+
+	count, err := s.figures.ExpireFigures(ctx)
 	log.Printf("expired %d stale figure(s)", count)
+	if err != nil {
+		return err
+	}
+
+	count, err = s.expireSendRecords(ctx)
+	log.Printf("expired %d send record(s)", count)
+	if err != nil {
+		return err
+	}
+
+	count, err = s.expireScrapeRuns(ctx)
+	log.Printf("expired %d scrape run(s)", count)
 	return err
 }
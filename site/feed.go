@@ -0,0 +1,210 @@
+package site
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+// Function handleDiedFeed serves an Atom feed of the figures that died on this day,
+// the same ones shown by the web app.
+// The optional "tzname" parameter says what time zone determines "this day."
+func (s *Server) handleDiedFeed(w http.ResponseWriter, req *http.Request) error {
+	ctx := req.Context()
+
+	var (
+		now   = tzNow(req.FormValue("tzname"))
+		today = outlived.TimeDate(now)
+	)
+
+	figures, err := s.figures.FiguresDiedOn(ctx, today.M, today.D, 24)
+	if err != nil {
+		return errors.Wrapf(err, "getting figures that died on %d %s", today.D, today.M)
+	}
+
+	self := homeURL.ResolveReference(req.URL)
+	feed := &atomFeed{
+		ID:      feedTagPrefix + "died",
+		Title:   "Outlived: died on this day",
+		Updated: startOfDay(now).Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Href: self.String()},
+			{Rel: "alternate", Href: homeURL.String()},
+		},
+		Author: atomPerson{Name: "Outlived"},
+	}
+	err = s.addFeedEntries(feed, "died/"+today.YYYYMMDD()+"/", startOfDay(now), figures)
+	if err != nil {
+		return err
+	}
+
+	return writeFeed(w, feed)
+}
+
+// Function handleOutlivedFeed serves a user's personal Atom feed
+// of the figures they outlived today,
+// the same ones handleSend mails to them
+// (see outlivedFeed).
+// Like handleCalendar it is authenticated by a token in the URL (see tokenURL).
+// The optional "tzname" parameter overrides the user's time zone.
+func (s *Server) handleOutlivedFeed(w http.ResponseWriter, req *http.Request) error {
+	ctx := req.Context()
+
+	u, err := s.tokenUser(req, feedTokenPurpose)
+	if err != nil {
+		return err
+	}
+
+	tzname := req.FormValue("tzname")
+	if tzname == "" {
+		tzname = u.TZName
+	}
+
+	self := homeURL.ResolveReference(req.URL)
+	feed, err := s.outlivedFeed(ctx, u, tzNow(tzname), self.String())
+	if err != nil {
+		return err
+	}
+
+	return writeFeed(w, feed)
+}
+
+// Function outlivedFeed produces u's personal feed as of the given time.
+// It has the figures that u's mail on that day has
+// (see figuresForMail),
+// honoring u's categories, figures-per-mail, and frequency preferences.
+func (s *Server) outlivedFeed(ctx context.Context, u *outlived.User, now time.Time, self string) (*atomFeed, error) {
+	today := outlived.TimeDate(now)
+
+	figures, err := s.figuresForMail(ctx, u, today)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &atomFeed{
+		ID:      feedTagPrefix + "outlived/" + u.Key().Encode(),
+		Title:   "Outlived: figures you have outlived",
+		Updated: startOfDay(now).Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Href: self},
+			{Rel: "alternate", Href: homeURL.String()},
+		},
+		Author: atomPerson{Name: "Outlived"},
+	}
+	err = s.addFeedEntries(feed, "outlived/"+today.YYYYMMDD()+"/", startOfDay(now), figures)
+	return feed, err
+}
+
+// Feed and entry IDs are tag URIs (RFC 4151).
+const feedTagPrefix = "tag:outlived.net,2020:"
+
+func (s *Server) addFeedEntries(feed *atomFeed, idPrefix string, updated time.Time, figures []*outlived.Figure) error {
+	for _, figure := range figures {
+		f := s.toFigureData(figure)
+
+		link, err := rlink(figure.Link)
+		if err != nil {
+			return errors.Wrapf(err, "making link for %s", figure.Link)
+		}
+		var img string
+		if f.ImgSrc != "" {
+			imgLink, err := rlink(f.ImgSrc)
+			if err != nil {
+				return errors.Wrapf(err, "making image link for %s", figure.Link)
+			}
+			img = imgLink.String()
+		}
+
+		buf := new(bytes.Buffer)
+		err = s.tmpl.renderHTML(buf, "feed/entry", map[string]interface{}{
+			"fig":  f,
+			"link": link.String(),
+			"img":  img,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "rendering feed entry for %s", figure.Link)
+		}
+
+		summary := f.Name
+		if f.Desc != "" {
+			summary += ", " + f.Desc
+		}
+		summary += ", " + f.Born + "—" + f.Died + " (" + f.YearsDaysAlive + ")"
+
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      feedTagPrefix + idPrefix + figure.Link,
+			Title:   f.Name,
+			Updated: updated.Format(time.RFC3339),
+			Links:   []atomLink{{Rel: "alternate", Href: link.String()}},
+			Summary: atomText{Type: "text", Body: summary},
+			Content: atomText{Type: "html", Body: buf.String()},
+		})
+	}
+	return nil
+}
+
+func writeFeed(w http.ResponseWriter, feed *atomFeed) error {
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	_, err := feed.WriteTo(w)
+	return errors.Wrap(err, "writing feed")
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Types for an Atom (RFC 4287) feed.
+type (
+	atomFeed struct {
+		XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+		ID      string      `xml:"id"`
+		Title   string      `xml:"title"`
+		Updated string      `xml:"updated"`
+		Links   []atomLink  `xml:"link"`
+		Author  atomPerson  `xml:"author"`
+		Entries []atomEntry `xml:"entry"`
+	}
+
+	atomLink struct {
+		Rel  string `xml:"rel,attr,omitempty"`
+		Href string `xml:"href,attr"`
+	}
+
+	atomPerson struct {
+		Name string `xml:"name"`
+	}
+
+	atomEntry struct {
+		ID      string     `xml:"id"`
+		Title   string     `xml:"title"`
+		Updated string     `xml:"updated"`
+		Links   []atomLink `xml:"link"`
+		Summary atomText   `xml:"summary"`
+		Content atomText   `xml:"content"`
+	}
+
+	atomText struct {
+		Type string `xml:"type,attr,omitempty"`
+		Body string `xml:",chardata"`
+	}
+)
+
+func (f *atomFeed) WriteTo(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	err := enc.Encode(f)
+	if err != nil {
+		return 0, errors.Wrap(err, "encoding feed")
+	}
+	buf.WriteString("\n")
+	return buf.WriteTo(w)
+}
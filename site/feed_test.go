@@ -0,0 +1,131 @@
+package site
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"outlived"
+)
+
+func TestFeed(t *testing.T) {
+	s := &Server{tmpl: testTemplates(t)}
+
+	feed := &atomFeed{
+		ID:      feedTagPrefix + "died",
+		Title:   "Outlived: died on this day",
+		Updated: "2024-03-01T00:00:00Z",
+		Author:  atomPerson{Name: "Outlived"},
+	}
+	figures := []*outlived.Figure{
+		{
+			Link:      "Thomas_Campion",
+			Name:      "Thomas Campion",
+			Desc:      "English poet & composer",
+			Born:      outlived.Date{Y: 1567, M: time.February, D: 12, Cal: outlived.Julian},
+			Died:      outlived.Date{Y: 1620, M: time.March, D: 1},
+			DaysAlive: 19366,
+			ImgSrc:    "//upload.wikimedia.org/wikipedia/commons/thumb/x/xy/foo.jpg",
+			ImgAlt:    "Campion",
+		},
+	}
+	err := s.addFeedEntries(feed, "died/1620-03-01/", time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC), figures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	_, err = feed.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got atomFeed
+	err = xml.Unmarshal(buf.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(got.Entries))
+	}
+	e := got.Entries[0]
+	if want := "tag:outlived.net,2020:died/1620-03-01/Thomas_Campion"; e.ID != want {
+		t.Errorf("got ID %s, want %s", e.ID, want)
+	}
+	if want := "Thomas Campion, English poet & composer, 12 Feb 1567—1 Mar 1620 (53 years, 8 days)"; e.Summary.Body != want {
+		t.Errorf("got summary %s, want %s", e.Summary.Body, want)
+	}
+	if len(e.Links) != 1 || !strings.HasSuffix(e.Links[0].Href, "/r?w=Thomas_Campion") {
+		t.Errorf("got links %v, want one ending in /r?w=Thomas_Campion", e.Links)
+	}
+	for _, want := range []string{`/r?ct=xy%2Ffoo.jpg`, `English poet &amp; composer`} {
+		if !strings.Contains(e.Content.Body, want) {
+			t.Errorf("content does not contain %s: %s", want, e.Content.Body)
+		}
+	}
+}
+
+func TestOutlivedFeed(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := outlived.OpenFileFigureStore(filepath.Join(t.TempDir(), "figures.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	err = store.ReplaceFigures(ctx, []*outlived.Figure{
+		{Link: "a", Name: "A", Desc: "English poet", DaysAlive: 999, Pageviews: 10, Updated: now},
+		{Link: "b", Name: "B", Desc: "Composer", DaysAlive: 999, Pageviews: 30, Updated: now},
+		{Link: "c", Name: "C", Desc: "Poet and painter", DaysAlive: 999, Pageviews: 20, Updated: now},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{figures: store, tmpl: testTemplates(t)}
+
+	born := outlived.Date{Y: 2000, M: time.January, D: 1}
+	feedTime := time.Date(2002, time.September, 27, 12, 0, 0, 0, time.UTC) // born + 1000 days
+
+	cases := []struct {
+		name string
+		u    outlived.User
+		want []string
+	}{
+		{
+			name: "all",
+			u:    outlived.User{Born: born, Frequency: outlived.FrequencyDaily, FiguresPerMail: 24},
+			want: []string{"B", "C", "A"},
+		},
+		{
+			name: "categories",
+			u:    outlived.User{Born: born, Frequency: outlived.FrequencyDaily, FiguresPerMail: 24, Categories: []string{"poet"}},
+			want: []string{"C", "A"},
+		},
+		{
+			name: "limit",
+			u:    outlived.User{Born: born, Frequency: outlived.FrequencyDaily, FiguresPerMail: 1, Categories: []string{"poet"}},
+			want: []string{"C"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.u.Email = "user@example.com"
+			feed, err := s.outlivedFeed(ctx, &c.u, feedTime, "https://outlived.net/s/feed")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range feed.Entries {
+				got = append(got, e.Title)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
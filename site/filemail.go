@@ -0,0 +1,163 @@
+package site
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Type fileSender is a sender that writes complete messages to local files
+// instead of sending them,
+// one message per recipient.
+// The files are either in a Maildir (see https://cr.yp.to/proto/maildir.html)
+// or appended to an mbox file (in "mboxrd" format).
+// It is meant for development and testing.
+type fileSender struct {
+	path string
+	mbox bool
+
+	mu sync.Mutex // serializes appends to an mbox file
+}
+
+// Function newMaildirSender creates a fileSender for the Maildir at dir,
+// creating it if necessary.
+func newMaildirSender(dir string) (*fileSender, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating Maildir %s", dir)
+		}
+	}
+	return &fileSender{path: dir}, nil
+}
+
+// Function newMboxSender creates a fileSender for the mbox file at path.
+// The file is created on the first send if it does not exist.
+func newMboxSender(path string) *fileSender {
+	return &fileSender{path: path, mbox: true}
+}
+
+func (fs *fileSender) send(ctx context.Context, from string, to []recipient, subject string, textR io.Reader, htmlR io.Reader) error {
+	textBody, err := ioutil.ReadAll(textR)
+	if err != nil {
+		return errors.Wrap(err, "reading text body")
+	}
+	var htmlBody []byte
+	if htmlR != nil {
+		htmlBody, err = ioutil.ReadAll(htmlR)
+		if err != nil {
+			return errors.Wrap(err, "reading html body")
+		}
+	}
+
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return errors.Wrapf(err, "parsing from address %s", from)
+	}
+
+	for _, recip := range to {
+		msg := newMailMessage(from, recip, subject, textBody, htmlBody)
+		b, err := msg.bytes()
+		if err != nil {
+			return errors.Wrapf(err, "building message to %s", recip.addr)
+		}
+		if fs.mbox {
+			err = fs.appendMbox(fromAddr.Address, b)
+		} else {
+			err = fs.writeMaildir(b)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "writing message to %s", recip.addr)
+		}
+	}
+	return nil
+}
+
+// DeliverToMaildir makes s write outgoing mail
+// into the Maildir at dir
+// instead of sending it.
+func (s *Server) DeliverToMaildir(dir string) error {
+	fs, err := newMaildirSender(dir)
+	if err != nil {
+		return err
+	}
+	s.sender = fs
+	return nil
+}
+
+// DeliverToMbox makes s append outgoing mail
+// to the mbox file at path
+// instead of sending it.
+func (s *Server) DeliverToMbox(path string) {
+	s.sender = newMboxSender(path)
+}
+
+var maildirCounter int64
+
+func (fs *fileSender) writeMaildir(msg []byte) error {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	// The names sort in delivery order (within one process).
+	name := fmt.Sprintf("%d.P%dQ%06d.%s", time.Now().Unix(), os.Getpid(), atomic.AddInt64(&maildirCounter, 1), host)
+
+	var (
+		tmpname = filepath.Join(fs.path, "tmp", name)
+		newname = filepath.Join(fs.path, "new", name)
+	)
+	err = ioutil.WriteFile(tmpname, msg, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "writing %s", tmpname)
+	}
+	err = os.Rename(tmpname, newname)
+	return errors.Wrapf(err, "renaming %s to %s", tmpname, newname)
+}
+
+var mboxFromLine = regexp.MustCompile(`^>*From `)
+
+func (fs *fileSender) appendMbox(envelopeFrom string, msg []byte) error {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "From %s %s\n", envelopeFrom, time.Now().UTC().Format(time.ANSIC))
+
+	sc := bufio.NewScanner(bytes.NewReader(msg))
+	sc.Buffer(nil, len(msg)+1)
+	for sc.Scan() {
+		line := bytes.TrimSuffix(sc.Bytes(), []byte("\r"))
+		if mboxFromLine.Match(line) {
+			buf.WriteByte('>')
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return errors.Wrap(err, "scanning message")
+	}
+	buf.WriteByte('\n')
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, err := os.OpenFile(fs.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "opening %s", fs.path)
+	}
+	_, err = buf.WriteTo(f)
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "appending to %s", fs.path)
+	}
+	return f.Close()
+}
@@ -0,0 +1,379 @@
+package site
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+// Function readMaildir reads the messages in the "new" subdirectory of a Maildir
+// written by a fileSender,
+// in delivery order.
+func readMaildir(dir string) ([][]byte, error) {
+	newdir := filepath.Join(dir, "new")
+	infos, err := ioutil.ReadDir(newdir) // sorted by name
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", newdir)
+	}
+	var result [][]byte
+	for _, info := range infos {
+		b, err := ioutil.ReadFile(filepath.Join(newdir, info.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", info.Name())
+		}
+		result = append(result, b)
+	}
+	return result, nil
+}
+
+// Function readMbox reads the messages in an mbox file
+// written by a fileSender,
+// in order.
+func readMbox(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", path)
+	}
+	defer f.Close()
+
+	var (
+		result [][]byte
+		cur    *bytes.Buffer
+		sc     = bufio.NewScanner(f)
+	)
+	sc.Buffer(nil, 1024*1024)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if bytes.HasPrefix(line, []byte("From ")) {
+			if cur != nil {
+				result = append(result, bytes.TrimSuffix(cur.Bytes(), []byte("\n")))
+			}
+			cur = new(bytes.Buffer)
+			continue
+		}
+		if cur == nil {
+			return nil, fmt.Errorf("%s does not begin with a From line", path)
+		}
+		if mboxFromLine.Match(line) {
+			line = line[1:]
+		}
+		cur.Write(line)
+		cur.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrapf(err, "reading %s", path)
+	}
+	if cur != nil {
+		result = append(result, bytes.TrimSuffix(cur.Bytes(), []byte("\n")))
+	}
+	return result, nil
+}
+
+// Type testMail is a message read back from a fileSender or an SMTP server,
+// with its subject and bodies decoded.
+type testMail struct {
+	Header  mail.Header
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Function parseTestMail parses a message produced by mailMessage.bytes.
+func parseTestMail(raw []byte) (*testMail, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading message")
+	}
+	subj, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding subject")
+	}
+	result := &testMail{Header: msg.Header, Subject: subj}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing content type")
+	}
+	if mediaType != "multipart/alternative" {
+		b, err := ioutil.ReadAll(quotedprintable.NewReader(msg.Body))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading body")
+		}
+		result.Text = crlfToLF(b)
+		return result, nil
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "reading part")
+		}
+		b, err := ioutil.ReadAll(quotedprintable.NewReader(p))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading part body")
+		}
+		partType, _, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing part content type")
+		}
+		switch partType {
+		case "text/plain":
+			result.Text = crlfToLF(b)
+		case "text/html":
+			result.HTML = crlfToLF(b)
+		default:
+			return nil, fmt.Errorf("unexpected part type %s", partType)
+		}
+	}
+	return result, nil
+}
+
+// Function crlfToLF normalizes line endings,
+// which differ between Maildir (as sent) and mbox (as stored) messages.
+func crlfToLF(b []byte) string {
+	return string(bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n")))
+}
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestFileSender(t *testing.T) {
+	ctx := context.Background()
+
+	verifyLink, _ := url.Parse("https://outlived.net/s/verify?e=1709251200&n=nonce&t=token&u=userkey")
+	forgotLink, _ := url.Parse("https://outlived.net/s/forgot?e=1709251200&n=nonce&t=token&u=userkey")
+
+	s := &Server{tmpl: testTemplates(t)}
+
+	var (
+		alice = recipient{addr: "alice@example.com"}
+		bob   = recipient{addr: "bob@example.com"}
+	)
+
+	type mailCase struct {
+		name, subject string
+		to            []recipient
+		render        func() ([]byte, []byte, error)
+	}
+	cases := []mailCase{
+		{
+			name:    "daily",
+			subject: subject,
+			to: []recipient{
+				{
+					addr: alice.addr,
+					vars: map[string]string{
+						"alivedays":   "19,367",
+						"unsubscribe": "https://outlived.net/s/unsubscribe?e=1712534400&t=alicetoken&u=alicekey",
+						"browser":     "https://outlived.net/s/mail?d=2024-01-10&t=alicetoken2&u=alicekey",
+					},
+				},
+				{
+					addr: bob.addr,
+					vars: map[string]string{
+						"alivedays":   "19,367",
+						"unsubscribe": "https://outlived.net/s/unsubscribe?e=1712534400&t=bobtoken&u=bobkey",
+						"browser":     "https://outlived.net/s/mail?d=2024-01-10&t=bobtoken2&u=bobkey",
+					},
+				},
+			},
+			render: func() ([]byte, []byte, error) {
+				figures := []*outlived.Figure{
+					{
+						Link:      "Thomas_Campion",
+						Name:      "Thomas Campion",
+						Desc:      "English poet & composer",
+						Born:      outlived.Date{Y: 1567, M: time.February, D: 12, Cal: outlived.Julian},
+						Died:      outlived.Date{Y: 1620, M: time.March, D: 1},
+						DaysAlive: 19366,
+						ImgSrc:    "//upload.wikimedia.org/wikipedia/commons/thumb/x/xy/foo.jpg",
+						ImgAlt:    "Campion",
+					},
+					{
+						Link:      "Jane_Doe",
+						Name:      "Jane Doe",
+						Born:      outlived.Date{Y: 1900, M: time.January, D: 1},
+						Died:      outlived.Date{Y: 1953, M: time.January, D: 7},
+						DaysAlive: 19366,
+					},
+				}
+				born := outlived.Date{Y: 1971, M: time.January, D: 1}
+				return s.renderDailyMail(born, figures, false)
+			},
+		},
+		{
+			name:    "verify",
+			subject: "Verify your Outlived e-mail address",
+			to:      []recipient{alice},
+			render: func() ([]byte, []byte, error) {
+				return s.tmpl.renderMail("mail/verify", map[string]interface{}{"link": verifyLink})
+			},
+		},
+		{
+			name:    "forgot",
+			subject: "Reset your Outlived password",
+			to:      []recipient{bob},
+			render: func() ([]byte, []byte, error) {
+				return s.tmpl.renderMail("mail/forgot", map[string]interface{}{"link": forgotLink})
+			},
+		},
+	}
+
+	tmpdir := t.TempDir()
+
+	maildirSender, err := newMaildirSender(filepath.Join(tmpdir, "Maildir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mboxSender := newMboxSender(filepath.Join(tmpdir, "mbox"))
+
+	type sent struct {
+		c     mailCase
+		recip recipient
+	}
+	var wantSent []sent
+
+	for _, c := range cases {
+		textBody, htmlBody, err := c.render()
+		if err != nil {
+			t.Fatalf("rendering %s mail: %s", c.name, err)
+		}
+		for _, fs := range []*fileSender{maildirSender, mboxSender} {
+			err = fs.send(ctx, from, c.to, c.subject, bytes.NewReader(textBody), bytes.NewReader(htmlBody))
+			if err != nil {
+				t.Fatalf("sending %s mail: %s", c.name, err)
+			}
+		}
+		for _, recip := range c.to {
+			wantSent = append(wantSent, sent{c: c, recip: recip})
+		}
+	}
+
+	for _, src := range []struct {
+		name string
+		read func() ([][]byte, error)
+	}{
+		{name: "maildir", read: func() ([][]byte, error) { return readMaildir(filepath.Join(tmpdir, "Maildir")) }},
+		{name: "mbox", read: func() ([][]byte, error) { return readMbox(filepath.Join(tmpdir, "mbox")) }},
+	} {
+		t.Run(src.name, func(t *testing.T) {
+			msgs, err := src.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != len(wantSent) {
+				t.Fatalf("got %d messages, want %d", len(msgs), len(wantSent))
+			}
+			for i, raw := range msgs {
+				var (
+					c     = wantSent[i].c
+					recip = wantSent[i].recip
+				)
+				t.Run(fmt.Sprintf("%s_%s", c.name, recip.addr), func(t *testing.T) {
+					m, err := parseTestMail(raw)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got := m.Header.Get("To"); got != recip.addr {
+						t.Errorf("got To %s, want %s", got, recip.addr)
+					}
+					if m.Subject != c.subject {
+						t.Errorf("got subject %s, want %s", m.Subject, c.subject)
+					}
+					wantUnsubscribe, wantPost := unsubscribeURL, ""
+					if u := recip.vars["unsubscribe"]; u != "" {
+						wantUnsubscribe, wantPost = u, listUnsubscribePost
+					}
+					if got, want := m.Header.Get("List-Unsubscribe"), "<"+wantUnsubscribe+">"; got != want {
+						t.Errorf("got List-Unsubscribe %s, want %s", got, want)
+					}
+					if got := m.Header.Get("List-Unsubscribe-Post"); got != wantPost {
+						t.Errorf("got List-Unsubscribe-Post %q, want %q", got, wantPost)
+					}
+					golden := c.name
+					if len(c.to) > 1 {
+						golden += "-" + strings.Split(recip.addr, "@")[0]
+					}
+					checkGolden(t, golden+".txt", m.Text)
+					checkGolden(t, golden+".html", m.HTML)
+				})
+			}
+		})
+	}
+}
+
+func TestMboxEscaping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	fs := newMboxSender(path)
+
+	const body = "From here\n>From there\nFrom\n"
+	for i := 0; i < 2; i++ {
+		err := fs.send(context.Background(), from, recipients("alice@example.com"), "Escaping", strings.NewReader(body), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs, err := readMbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+	for _, raw := range msgs {
+		m, err := parseTestMail(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Text != body {
+			t.Errorf("got %q, want %q", m.Text, body)
+		}
+	}
+}
+
+// Function checkGolden compares got with the contents of testdata/golden/name,
+// or, with -update, rewrites that file.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+
+	filename := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s mismatch; got:\n%s\nwant:\n%s", name, got, string(want))
+	}
+}
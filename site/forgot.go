@@ -2,7 +2,6 @@ package site
 
 import (
 	"fmt"
-	"html/template"
 	"log"
 	"net/http"
 	"strconv"
@@ -64,13 +63,7 @@ func (s *Server) handleForgot(w http.ResponseWriter, req *http.Request) error {
 		"idem": idem,
 	}
 
-	tmpl, err := template.New("").Parse(forgotTmpl)
-	if err != nil {
-		return errors.Wrap(err, "parsing HTML template")
-	}
-
-	err = tmpl.Execute(w, dict)
-	return errors.Wrap(err, "executing HTML template")
+	return s.tmpl.renderHTML(w, "pages/forgot", dict)
 }
 
 func (s *Server) handleResetPW(w http.ResponseWriter, req *http.Request) error {
@@ -126,27 +119,3 @@ func (s *Server) handleResetPW(w http.ResponseWriter, req *http.Request) error {
 
 	return nil
 }
-
-const forgotTmpl = `
-<html>
-  <head>
-    <title>
-      Outlived
-    </title>
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-  </head>
-  <body>
-    <h1>Outlived</h1>
-
-    <form method="POST" action="/s/resetpw">
-      <input type="hidden" name="u" value="{{ .u }}"></input>
-      <input type="hidden" name="t" value="{{ .t }}"></input>
-      <input type="hidden" name="idem" value="{{ .idem }}"></input>
-      <label for="newpw">New password</label>
-      <input type="password" name="p"></input>
-      <button type="submit">Submit</button>
-    </form>
-
-  </body>
-</html>
-`
@@ -6,10 +6,8 @@ import (
 	"io"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
-	"github.com/bobg/aesite"
 	"github.com/bobg/mid"
 	"github.com/pkg/errors"
 
@@ -20,12 +18,9 @@ func (s *Server) handleLoad(w http.ResponseWriter, req *http.Request) error {
 	ctx := req.Context()
 
 	// Authorized callers only.
-	masterKey, err := aesite.GetSetting(ctx, s.dsClient, "master-key")
+	err := s.checkMasterKey(req)
 	if err != nil {
-		return errors.Wrap(err, "getting master key")
-	}
-	if strings.TrimSpace(req.Header.Get("X-Outlived-Key")) != string(masterKey) {
-		return mid.CodeErr{C: http.StatusUnauthorized}
+		return err
 	}
 
 	csvr := csv.NewReader(req.Body)
@@ -71,6 +66,6 @@ func (s *Server) handleLoad(w http.ResponseWriter, req *http.Request) error {
 		}
 		figures = append(figures, f)
 	}
-	err = outlived.ReplaceFigures(ctx, s.dsClient, figures)
+	err = s.figures.ReplaceFigures(ctx, figures)
 	return errors.Wrap(err, "writing to datastore")
 }
@@ -0,0 +1,679 @@
+package site
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+)
+
+// Type localTasks is a taskService for running outside App Engine.
+// It dispatches tasks to this server over HTTP,
+// honoring per-queue settings like those of Cloud Tasks (see queueConfig),
+// retrying failures with exponential backoff,
+// and refusing a task whose name is the same as a pending or recently finished one.
+// It can keep its tasks in a journal file (see setJournal),
+// so they survive a restart.
+type localTasks struct {
+	base    *url.URL
+	client  *http.Client
+	configs map[string]queueConfig // by queue ID (the last element of the queue name)
+
+	mu      sync.Mutex // protects the fields below
+	tasks   map[string]*localTask
+	done    map[string]time.Time // names of finished tasks, for dedup, with finishing times
+	wake    map[string]chan struct{}
+	journal *taskJournal
+	seq     int64
+	ctx     context.Context // non-nil once started
+}
+
+// Type localTask is a task in localTasks.
+type localTask struct {
+	Name     string          `json:"name"`
+	Queue    string          `json:"queue"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	Deadline time.Duration   `json:"deadline,omitempty"`
+	ETA      time.Time       `json:"eta"`
+	Attempts int             `json:"attempts"`
+	Seq      int64           `json:"seq"`
+
+	running bool
+}
+
+// How long the name of a finished task can't be reused.
+// Cloud Tasks' period is "approximately one hour" (or more).
+const taskNameRetention = time.Hour
+
+// How long a single attempt of a task may take by default.
+// This is the limit for App Engine apps with automatic scaling.
+const defaultTaskDeadline = 10 * time.Minute
+
+func newLocalTasks(host string) *localTasks {
+	return &localTasks{
+		base: &url.URL{
+			Scheme: "http",
+			Host:   host,
+		},
+		client:  new(http.Client),
+		configs: make(map[string]queueConfig),
+		tasks:   make(map[string]*localTask),
+		done:    make(map[string]time.Time),
+		wake:    make(map[string]chan struct{}),
+	}
+}
+
+// UseLocalTasks configures the task queues of s when not running on App Engine.
+// If journalPath is not empty,
+// tasks are kept in that file and survive a restart.
+// If queueYAMLPath is not empty,
+// queue settings are read from that file,
+// which is in the format of a Cloud Tasks queue.yaml file.
+// It must be called before Serve.
+func (s *Server) UseLocalTasks(journalPath, queueYAMLPath string) error {
+	t, ok := s.tasks.(*localTasks)
+	if !ok {
+		return errors.New("not using local tasks")
+	}
+	if queueYAMLPath != "" {
+		data, err := os.ReadFile(queueYAMLPath)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", queueYAMLPath)
+		}
+		t.configs, err = parseQueueYAML(data)
+		if err != nil {
+			return errors.Wrapf(err, "in %s", queueYAMLPath)
+		}
+	}
+	if journalPath != "" {
+		return t.setJournal(journalPath)
+	}
+	return nil
+}
+
+// Function start starts dispatching tasks,
+// until the context is canceled.
+func (t *localTasks) start(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.ctx = ctx
+	queues := make(map[string]bool)
+	for _, task := range t.tasks {
+		queues[task.Queue] = true
+	}
+	for queue := range queues {
+		t.startQueue(queue)
+	}
+}
+
+// Function startQueue starts the dispatcher for the given queue if necessary.
+// The caller must hold t.mu.
+func (t *localTasks) startQueue(queue string) {
+	if t.ctx == nil {
+		return
+	}
+	if _, ok := t.wake[queue]; ok {
+		return
+	}
+	wake := make(chan struct{}, 1)
+	t.wake[queue] = wake
+	go t.run(t.ctx, queue, wake)
+}
+
+func (t *localTasks) queueEmpty(ctx context.Context, queue string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, task := range t.tasks {
+		if task.Queue == queue {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Type errTaskExists is the error from enqueueTask
+// when the task name is in use.
+type errTaskExists string
+
+func (e errTaskExists) Error() string {
+	return fmt.Sprintf("task %s already exists", string(e))
+}
+
+func (t *localTasks) enqueueTask(ctx context.Context, queue string, tk *task) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.seq++
+	taskName := tk.Name
+	if taskName == "" {
+		taskName = fmt.Sprintf("%s/tasks/local%d", queue, t.seq)
+	}
+	if _, ok := t.tasks[taskName]; ok {
+		return errTaskExists(taskName)
+	}
+	if when, ok := t.done[taskName]; ok {
+		if time.Since(when) < taskNameRetention {
+			return errTaskExists(taskName)
+		}
+		delete(t.done, taskName)
+	}
+
+	task := &localTask{
+		Name:     taskName,
+		Queue:    queue,
+		URL:      tk.URL,
+		Body:     tk.Body,
+		Deadline: tk.Deadline,
+		ETA:      tk.ScheduleTime,
+		Seq:      t.seq,
+	}
+	if task.ETA.IsZero() {
+		task.ETA = time.Now()
+	}
+	err := t.journal.put(task)
+	if err != nil {
+		return errors.Wrapf(err, "localTasks: enqueueing task %s", taskName)
+	}
+	t.tasks[taskName] = task
+	t.startQueue(queue)
+	t.signal(queue)
+	return nil
+}
+
+// Function signal wakes the dispatcher for the given queue.
+// The caller must hold t.mu.
+func (t *localTasks) signal(queue string) {
+	select {
+	case t.wake[queue] <- struct{}{}:
+	default:
+	}
+}
+
+// Function run dispatches the tasks in the given queue,
+// honoring the queue's rate and concurrency limits.
+func (t *localTasks) run(ctx context.Context, queue string, wake <-chan struct{}) {
+	var (
+		cfg     = t.config(queue)
+		limiter = rate.NewLimiter(rate.Limit(cfg.Rate), cfg.BucketSize)
+		sem     = make(chan struct{}, cfg.MaxConcurrent)
+	)
+
+	log.Printf("starting queue processor for %s", queue)
+	defer log.Printf("exiting queue processor for %s", queue)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sem <- struct{}{}:
+		}
+
+		task, wait := t.next(queue)
+		if task == nil {
+			<-sem
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
+		}
+
+		err := limiter.Wait(ctx)
+		if err != nil {
+			return
+		}
+		go func() {
+			defer func() { <-sem }()
+			t.dispatch(ctx, cfg, task)
+		}()
+	}
+}
+
+// Function next returns the next task in the given queue that is ready to run,
+// marking it as running.
+// If there is none,
+// it returns how long until there might be.
+func (t *localTasks) next(queue string) (*localTask, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var (
+		now    = time.Now()
+		result *localTask
+		wait   = time.Hour
+	)
+	for _, task := range t.tasks {
+		if task.Queue != queue || task.running {
+			continue
+		}
+		if task.ETA.After(now) {
+			if d := task.ETA.Sub(now); d < wait {
+				wait = d
+			}
+			continue
+		}
+		if result == nil || task.ETA.Before(result.ETA) || (task.ETA.Equal(result.ETA) && task.Seq < result.Seq) {
+			result = task
+		}
+	}
+	if result != nil {
+		result.running = true
+	}
+	return result, wait
+}
+
+// Function dispatch runs a task by requesting its URL from this server,
+// then removes it, or schedules a retry if the request failed.
+func (t *localTasks) dispatch(ctx context.Context, cfg queueConfig, task *localTask) {
+	err := t.request(ctx, task)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	task.running = false
+	defer t.signal(task.Queue)
+
+	if ctx.Err() != nil {
+		// Shutting down. Leave the task for next time.
+		return
+	}
+
+	task.Attempts++
+
+	if err != nil {
+		if cfg.RetryLimit < 0 || task.Attempts <= cfg.RetryLimit {
+			delay := cfg.backoff(task.Attempts)
+			log.Printf("localTasks, queue %s: %s (attempt %d, retrying in %s)", task.Queue, err, task.Attempts, delay)
+			task.ETA = time.Now().Add(delay)
+			err = t.journal.put(task)
+			if err != nil {
+				log.Printf("localTasks: recording retry of %s: %s", task.Name, err)
+			}
+			return
+		}
+		log.Printf("localTasks, queue %s: %s (attempt %d, giving up)", task.Queue, err, task.Attempts)
+	}
+
+	now := time.Now()
+	delete(t.tasks, task.Name)
+	t.done[task.Name] = now
+	err = t.journal.finish(task.Name, now)
+	if err != nil {
+		log.Printf("localTasks: recording completion of %s: %s", task.Name, err)
+	}
+}
+
+// Function request makes the HTTP request for a task:
+// a POST of its JSON body.
+// Like Cloud Tasks, it counts any status other than 2xx as failure,
+// it cancels the request after the task's deadline,
+// and it sets App Engine's task headers.
+func (t *localTasks) request(ctx context.Context, task *localTask) error {
+	u, err := url.Parse(task.URL)
+	if err != nil {
+		return errors.Wrapf(err, "parsing url %s", task.URL)
+	}
+
+	deadline := task.Deadline
+	if deadline <= 0 {
+		deadline = defaultTaskDeadline
+	}
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", t.base.ResolveReference(u).String(), bytes.NewReader(task.Body))
+	if err != nil {
+		return errors.Wrapf(err, "creating request for %s", task.URL)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-AppEngine-QueueName", path.Base(task.Queue))
+	req.Header.Set("X-AppEngine-TaskName", path.Base(task.Name))
+	req.Header.Set("X-AppEngine-TaskRetryCount", strconv.Itoa(task.Attempts))
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "during POST %s", task.URL)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s: status %d", task.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// Type queueConfig holds the settings for a queue in localTasks.
+// They are named for (and can be read from) the settings in a Cloud Tasks queue.yaml file.
+// See https://cloud.google.com/appengine/docs/standard/reference/queueref.
+type queueConfig struct {
+	Rate          float64 // tasks per second
+	BucketSize    int
+	MaxConcurrent int
+
+	// RetryLimit is the number of retries after the first attempt,
+	// or -1 for no limit.
+	RetryLimit int
+
+	MinBackoff, MaxBackoff time.Duration
+	MaxDoublings           int
+}
+
+// The settings for a queue missing from the configuration.
+// These are Cloud Tasks' defaults,
+// except for a lower max concurrency.
+var defaultQueueConfig = queueConfig{
+	Rate:          5,
+	BucketSize:    5,
+	MaxConcurrent: 10,
+	RetryLimit:    -1,
+	MinBackoff:    100 * time.Millisecond,
+	MaxBackoff:    time.Hour,
+	MaxDoublings:  16,
+}
+
+// Function config gives the settings of the given queue.
+func (t *localTasks) config(queue string) queueConfig {
+	if cfg, ok := t.configs[path.Base(queue)]; ok {
+		return cfg
+	}
+	return defaultQueueConfig
+}
+
+// Function backoff gives the delay before the retry that follows the given number of attempts.
+// It doubles with each attempt, up to MaxDoublings times and at most MaxBackoff.
+func (c queueConfig) backoff(attempts int) time.Duration {
+	doublings := attempts - 1
+	if doublings > c.MaxDoublings {
+		doublings = c.MaxDoublings
+	}
+	if doublings > 30 {
+		doublings = 30
+	}
+	d := c.MinBackoff << doublings
+	if d > c.MaxBackoff || d <= 0 {
+		d = c.MaxBackoff
+	}
+	return d
+}
+
+// Function parseQueueYAML reads queue settings in the format of a Cloud Tasks queue.yaml file.
+// Settings missing from it get the values in defaultQueueConfig.
+func parseQueueYAML(data []byte) (map[string]queueConfig, error) {
+	var doc struct {
+		Queue []struct {
+			Name                  string `yaml:"name"`
+			Rate                  string `yaml:"rate"`
+			BucketSize            *int   `yaml:"bucket_size"`
+			MaxConcurrentRequests *int   `yaml:"max_concurrent_requests"`
+			RetryParameters       *struct {
+				TaskRetryLimit    *int     `yaml:"task_retry_limit"`
+				MinBackoffSeconds *float64 `yaml:"min_backoff_seconds"`
+				MaxBackoffSeconds *float64 `yaml:"max_backoff_seconds"`
+				MaxDoublings      *int     `yaml:"max_doublings"`
+			} `yaml:"retry_parameters"`
+		} `yaml:"queue"`
+	}
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing queue configuration")
+	}
+
+	seconds := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second))
+	}
+
+	result := make(map[string]queueConfig)
+	for _, q := range doc.Queue {
+		cfg := defaultQueueConfig
+		if q.Rate != "" {
+			cfg.Rate, err = parseQueueRate(q.Rate)
+			if err != nil {
+				return nil, errors.Wrapf(err, "queue %s", q.Name)
+			}
+		}
+		if q.BucketSize != nil {
+			cfg.BucketSize = *q.BucketSize
+		}
+		if q.MaxConcurrentRequests != nil {
+			cfg.MaxConcurrent = *q.MaxConcurrentRequests
+		}
+		if p := q.RetryParameters; p != nil {
+			if p.TaskRetryLimit != nil {
+				cfg.RetryLimit = *p.TaskRetryLimit
+			}
+			if p.MinBackoffSeconds != nil {
+				cfg.MinBackoff = seconds(*p.MinBackoffSeconds)
+			}
+			if p.MaxBackoffSeconds != nil {
+				cfg.MaxBackoff = seconds(*p.MaxBackoffSeconds)
+			}
+			if p.MaxDoublings != nil {
+				cfg.MaxDoublings = *p.MaxDoublings
+			}
+		}
+		if cfg.Rate <= 0 || cfg.BucketSize <= 0 || cfg.MaxConcurrent <= 0 {
+			return nil, fmt.Errorf("queue %s: rate, bucket_size, and max_concurrent_requests must be positive", q.Name)
+		}
+		result[q.Name] = cfg
+	}
+	return result, nil
+}
+
+// Function parseQueueRate parses a queue.yaml rate like "5/s" or "100/m",
+// giving tasks per second.
+func parseQueueRate(s string) (float64, error) {
+	numStr, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("malformed rate %s", s)
+	}
+	n, err := strconv.ParseFloat(numStr, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parsing rate %s", s)
+	}
+	switch unit {
+	case "s":
+		return n, nil
+	case "m":
+		return n / 60, nil
+	case "h":
+		return n / 3600, nil
+	case "d":
+		return n / 86400, nil
+	}
+	return 0, fmt.Errorf("unknown unit in rate %s", s)
+}
+
+// Type taskJournal is the durable storage of localTasks:
+// a file of JSON records, one per line,
+// each either a task (added or rescheduled)
+// or the name of a finished task.
+// The file is rewritten with only the live records when it grows too long
+// (see compact).
+// A nil *taskJournal stores nothing.
+type taskJournal struct {
+	path      string
+	f         *os.File
+	records   int // in the file
+	compactAt int // compact when records reaches this
+	live      func() ([]*localTask, map[string]time.Time)
+}
+
+type journalRecord struct {
+	Task     *localTask `json:"task,omitempty"`
+	Finished string     `json:"finished,omitempty"`
+	Time     time.Time  `json:"time,omitempty"`
+}
+
+// Function setJournal makes t durable,
+// loading tasks from the journal file at the given path (if it exists)
+// and recording changes there.
+// It must be called before start.
+func (t *localTasks) setJournal(journalPath string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var records int
+
+	f, err := os.Open(journalPath)
+	if err == nil {
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			var rec journalRecord
+			err = json.Unmarshal(sc.Bytes(), &rec)
+			if err != nil {
+				return errors.Wrapf(err, "decoding record %d in %s", records+1, journalPath)
+			}
+			records++
+			switch {
+			case rec.Task != nil:
+				t.tasks[rec.Task.Name] = rec.Task
+				if rec.Task.Seq > t.seq {
+					t.seq = rec.Task.Seq
+				}
+			case rec.Finished != "":
+				delete(t.tasks, rec.Finished)
+				t.done[rec.Finished] = rec.Time
+			}
+		}
+		if err = sc.Err(); err != nil {
+			return errors.Wrapf(err, "reading %s", journalPath)
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "opening %s", journalPath)
+	}
+
+	t.journal = &taskJournal{
+		path:    journalPath,
+		records: records,
+		live: func() ([]*localTask, map[string]time.Time) {
+			return t.liveRecords()
+		},
+	}
+	if len(t.tasks) > 0 {
+		log.Printf("loaded %d pending task(s) from %s", len(t.tasks), journalPath)
+	}
+	return t.journal.compact()
+}
+
+// Function liveRecords returns the pending tasks
+// and the finished ones whose names are still reserved,
+// first forgetting the others.
+// The caller must hold t.mu.
+func (t *localTasks) liveRecords() ([]*localTask, map[string]time.Time) {
+	var tasks []*localTask
+	for _, task := range t.tasks {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Seq < tasks[j].Seq })
+
+	for name, when := range t.done {
+		if time.Since(when) >= taskNameRetention {
+			delete(t.done, name)
+		}
+	}
+	return tasks, t.done
+}
+
+func (j *taskJournal) put(task *localTask) error {
+	return j.write(journalRecord{Task: task})
+}
+
+func (j *taskJournal) finish(name string, when time.Time) error {
+	return j.write(journalRecord{Finished: name, Time: when})
+}
+
+// Function write appends a record to the journal,
+// first compacting it if it has grown too long.
+// The caller must hold the lock of the localTasks.
+func (j *taskJournal) write(rec journalRecord) error {
+	if j == nil {
+		return nil
+	}
+	if j.records >= j.compactAt {
+		err := j.compact()
+		if err != nil {
+			return err
+		}
+	}
+	err := json.NewEncoder(j.f).Encode(rec)
+	if err != nil {
+		return errors.Wrapf(err, "writing to %s", j.path)
+	}
+	j.records++
+	return errors.Wrapf(j.f.Sync(), "syncing %s", j.path)
+}
+
+// Function compact rewrites the journal with only the live records.
+func (j *taskJournal) compact() error {
+	tasks, done := j.live()
+
+	dir, base := filepath.Split(j.path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, base+".tmp*")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	enc := json.NewEncoder(tmp)
+	for _, task := range tasks {
+		if err = enc.Encode(journalRecord{Task: task}); err != nil {
+			tmp.Close()
+			return errors.Wrap(err, "writing temporary file")
+		}
+	}
+	for name, when := range done {
+		if err = enc.Encode(journalRecord{Finished: name, Time: when}); err != nil {
+			tmp.Close()
+			return errors.Wrap(err, "writing temporary file")
+		}
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "syncing temporary file")
+	}
+
+	err = os.Rename(tmp.Name(), j.path)
+	if err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "renaming temporary file to %s", j.path)
+	}
+	if j.f != nil {
+		j.f.Close()
+	}
+	j.f = tmp
+	j.records = len(tasks) + len(done)
+	j.compactAt = 4 * j.records
+	if j.compactAt < 1000 {
+		j.compactAt = 1000
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package site
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseQueueYAML(t *testing.T) {
+	configs, err := parseQueueYAML([]byte(`
+queue:
+- name: scrape
+  rate: 30/m
+  bucket_size: 2
+  max_concurrent_requests: 3
+  retry_parameters:
+    task_retry_limit: 4
+    min_backoff_seconds: 0.5
+    max_backoff_seconds: 10
+    max_doublings: 2
+- name: other
+  rate: 2/s
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := queueConfig{
+		Rate:          0.5,
+		BucketSize:    2,
+		MaxConcurrent: 3,
+		RetryLimit:    4,
+		MinBackoff:    500 * time.Millisecond,
+		MaxBackoff:    10 * time.Second,
+		MaxDoublings:  2,
+	}
+	if got := configs["scrape"]; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	want = defaultQueueConfig
+	want.Rate = 2
+	if got := configs["other"]; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err = parseQueueYAML([]byte("queue:\n- name: x\n  rate: 5/fortnight\n")); err == nil {
+		t.Error("got no error for a bad rate")
+	}
+}
+
+func TestQueueBackoff(t *testing.T) {
+	cfg := queueConfig{
+		MinBackoff:   time.Second,
+		MaxBackoff:   10 * time.Second,
+		MaxDoublings: 2,
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	for i, w := range want {
+		if got := cfg.backoff(i + 1); got != w {
+			t.Errorf("after %d attempt(s): got %s, want %s", i+1, got, w)
+		}
+	}
+
+	cfg.MaxDoublings = 16
+	if got := cfg.backoff(10); got != cfg.MaxBackoff {
+		t.Errorf("got %s, want %s", got, cfg.MaxBackoff)
+	}
+}
+
+// Function testLocalTasks creates a localTasks
+// dispatching to a test server with the given handler.
+func testLocalTasks(t *testing.T, handler http.HandlerFunc) *localTasks {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newLocalTasks(u.Host)
+}
+
+// Function waitEmpty waits for the given queue to empty.
+func waitEmpty(t *testing.T, lt *localTasks, queue string) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		empty, err := lt.queueEmpty(context.Background(), queue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if empty {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("queue %s did not empty", queue)
+}
+
+func TestLocalTasksRetry(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		calls   = make(map[string]int)
+		retries []string
+	)
+	lt := testLocalTasks(t, func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls[req.URL.Path]++
+		retries = append(retries, req.Header.Get("X-AppEngine-TaskRetryCount"))
+		if req.Header.Get("X-AppEngine-QueueName") != "q" {
+			t.Errorf("got queue name header %s", req.Header.Get("X-AppEngine-QueueName"))
+		}
+		if req.URL.Path == "/flaky" && calls["/flaky"] < 3 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		}
+		if req.URL.Path == "/broken" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+		}
+	})
+	lt.configs["q"] = queueConfig{
+		Rate:          100,
+		BucketSize:    10,
+		MaxConcurrent: 1,
+		RetryLimit:    2,
+		MinBackoff:    10 * time.Millisecond,
+		MaxBackoff:    50 * time.Millisecond,
+		MaxDoublings:  3,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lt.start(ctx)
+
+	const queue = "projects/p/locations/l/queues/q"
+	if err := lt.enqueueTask(ctx, queue, &task{Name: queue + "/tasks/flaky", URL: "/flaky"}); err != nil {
+		t.Fatal(err)
+	}
+	waitEmpty(t, lt, queue)
+
+	if err := lt.enqueueTask(ctx, queue, &task{Name: queue + "/tasks/broken", URL: "/broken"}); err != nil {
+		t.Fatal(err)
+	}
+	waitEmpty(t, lt, queue)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if calls["/flaky"] != 3 {
+		t.Errorf("got %d call(s) to /flaky, want 3", calls["/flaky"])
+	}
+	if calls["/broken"] != 3 {
+		t.Errorf("got %d call(s) to /broken, want 3 (1 + task_retry_limit)", calls["/broken"])
+	}
+	if want := []string{"0", "1", "2", "0", "1", "2"}; len(retries) != len(want) {
+		t.Errorf("got retry counts %v, want %v", retries, want)
+	} else {
+		for i := range want {
+			if retries[i] != want[i] {
+				t.Errorf("got retry counts %v, want %v", retries, want)
+				break
+			}
+		}
+	}
+}
+
+func TestLocalTasksDedup(t *testing.T) {
+	lt := newLocalTasks("localhost:0")
+	ctx := context.Background()
+
+	if err := lt.enqueueTask(ctx, "q", &task{Name: "q/tasks/a", URL: "/a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lt.enqueueTask(ctx, "q", &task{Name: "q/tasks/a", URL: "/a"}); err == nil {
+		t.Error("got no error enqueueing a pending task name")
+	}
+	if err := lt.enqueueTask(ctx, "q", &task{URL: "/a"}); err != nil {
+		t.Errorf("unnamed task: %s", err)
+	}
+	if err := lt.enqueueTask(ctx, "q", &task{URL: "/a"}); err != nil {
+		t.Errorf("second unnamed task: %s", err)
+	}
+
+	delete(lt.tasks, "q/tasks/a")
+	lt.done["q/tasks/a"] = time.Now().Add(-time.Minute)
+	if err := lt.enqueueTask(ctx, "q", &task{Name: "q/tasks/a", URL: "/a"}); err == nil {
+		t.Error("got no error enqueueing a recently finished task name")
+	}
+
+	delete(lt.tasks, "q/tasks/a")
+	lt.done["q/tasks/a"] = time.Now().Add(-2 * taskNameRetention)
+	if err := lt.enqueueTask(ctx, "q", &task{Name: "q/tasks/a", URL: "/a"}); err != nil {
+		t.Errorf("reusing an old task name: %s", err)
+	}
+}
+
+func TestLocalTasksJournal(t *testing.T) {
+	var (
+		journal = filepath.Join(t.TempDir(), "tasks.jsonl")
+		ctx     = context.Background()
+	)
+
+	lt := testLocalTasks(t, func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/c" {
+			http.Error(w, "failed", http.StatusInternalServerError)
+		}
+	})
+	if err := lt.setJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := lt.enqueueTask(ctx, "q", &task{Name: "q/tasks/" + name, URL: "/" + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Run task b, which succeeds, and task c, which fails.
+	cfg := defaultQueueConfig
+	cfg.MinBackoff = time.Hour
+	for _, name := range []string{"b", "c"} {
+		task := lt.tasks["q/tasks/"+name]
+		task.running = true
+		lt.dispatch(ctx, cfg, task)
+	}
+
+	lt2 := newLocalTasks("localhost:0")
+	if err := lt2.setJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	if len(lt2.tasks) != 2 || lt2.tasks["q/tasks/a"] == nil || lt2.tasks["q/tasks/c"] == nil {
+		t.Fatalf("got tasks %v after reloading, want a and c", lt2.tasks)
+	}
+	if c := lt2.tasks["q/tasks/c"]; c.Attempts != 1 || time.Until(c.ETA) < 59*time.Minute {
+		t.Errorf("got task c with %d attempt(s) and ETA %s, want 1 attempt and ETA in an hour", c.Attempts, c.ETA)
+	}
+	if err := lt2.enqueueTask(ctx, "q", &task{Name: "q/tasks/b", URL: "/b"}); err == nil {
+		t.Error("got no error reusing the name of a task finished before reloading")
+	}
+	if task, _ := lt2.next("q"); task == nil || task.Name != "q/tasks/a" {
+		t.Errorf("got next task %v, want a", task)
+	}
+}
+
+func TestLocalTasksConcurrency(t *testing.T) {
+	var (
+		mu             sync.Mutex
+		active, maxAct int
+		count          int
+	)
+	lt := testLocalTasks(t, func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		active++
+		count++
+		if active > maxAct {
+			maxAct = active
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+	})
+	lt.configs["q"] = queueConfig{
+		Rate:          1000,
+		BucketSize:    100,
+		MaxConcurrent: 2,
+		RetryLimit:    -1,
+		MinBackoff:    time.Millisecond,
+		MaxBackoff:    time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lt.start(ctx)
+
+	for i := 0; i < 10; i++ {
+		if err := lt.enqueueTask(ctx, "q", &task{URL: "/x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitEmpty(t, lt, "q")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if count != 10 {
+		t.Errorf("got %d request(s), want 10", count)
+	}
+	if maxAct != 2 {
+		t.Errorf("got max concurrency %d, want 2", maxAct)
+	}
+}
@@ -4,11 +4,9 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	htemplate "html/template"
 	"log"
 	"net/http"
 	"net/url"
-	ttemplate "text/template"
 
 	"github.com/bobg/aesite"
 	"github.com/bobg/mid"
@@ -53,28 +51,13 @@ func (s *Server) handleLogin(
 
 		dict := map[string]interface{}{"link": link}
 
-		ttmpl, err := ttemplate.New("").Parse(fmailText)
+		textBody, htmlBody, err := s.tmpl.renderMail("mail/forgot", dict)
 		if err != nil {
-			return nil, errors.Wrap(err, "parsing plain-text template")
-		}
-		textBuf := new(bytes.Buffer)
-		err = ttmpl.Execute(textBuf, dict)
-		if err != nil {
-			return nil, errors.Wrap(err, "executing plain-text template")
-		}
-
-		htmpl, err := htemplate.New("").Parse(fmailHTML)
-		if err != nil {
-			return nil, errors.Wrap(err, "parsing HTML template")
-		}
-		htmlBuf := new(bytes.Buffer)
-		err = htmpl.Execute(htmlBuf, dict)
-		if err != nil {
-			return nil, errors.Wrap(err, "executing HTML template")
+			return nil, errors.Wrap(err, "rendering forgot-password mail")
 		}
 
 		const subject = "Reset your Outlived password"
-		err = s.sender.send(ctx, from, []string{u.Email}, subject, textBuf, htmlBuf)
+		err = s.sender.send(ctx, from, recipients(u.Email), subject, bytes.NewReader(textBody), bytes.NewReader(htmlBody))
 		if err != nil {
 			return nil, errors.Wrap(err, "sending forgot-password mail")
 		}
@@ -103,35 +86,3 @@ func (s *Server) handleLogin(
 
 	return d, nil
 }
-
-const fmailText = `Follow this link to reset your Outlived password:
-
-  {{ .link }}
-
-This link expires in one hour.
-`
-
-const fmailHTML = `
-<p>Follow <a href="{{ .link }}">this link</a> to reset your Outlived password:</p>
-<p><a href="{{ .link }}">{{ .link }}</a></p>
-<p>This link expires in one hour.</p>
-`
-
-const postForgotTmpl = `
-<html>
-  <head>
-    <title>
-      Outlived
-    </title>
-  </head>
-  <body>
-    <h1>Check e-mail</h1>
-
-    <p>
-      Reset your Outlived password by following the link
-      in the e-mail we just sent you. The link expires in one hour.
-    </p>
-
-  </body>
-</html>
-`
@@ -2,6 +2,7 @@ package site
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -13,17 +14,64 @@ import (
 
 const from = "Outlived <no-reply@mail.outlived.net>"
 
+// Type sender sends a message to one or more recipients.
+// The bodies may contain placeholders (see recipientVar)
+// that the sender replaces with each recipient's own values.
+// Each sender produces a separate message for each recipient
+// (see newMailMessage),
+// since the List-Unsubscribe header differs from one recipient to the next.
 type sender interface {
 	send(
 		ctx context.Context,
 		from string,
-		to []string,
+		to []recipient,
 		subject string,
 		textBody io.Reader,
 		htmlBody io.Reader,
 	) error
 }
 
+// Type recipient is one recipient of a message.
+type recipient struct {
+	addr string
+
+	// vars holds the values of this recipient's placeholders, by name.
+	// The variable "unsubscribe" is special:
+	// it is also used as a one-click unsubscribe URL
+	// in the List-Unsubscribe header (see handleUnsubscribe).
+	vars map[string]string
+}
+
+// Function recipients makes a recipient with no variables for each address.
+func recipients(addrs ...string) []recipient {
+	result := make([]recipient, 0, len(addrs))
+	for _, addr := range addrs {
+		result = append(result, recipient{addr: addr})
+	}
+	return result
+}
+
+// Function recipientVar produces the placeholder
+// for the recipient variable with the given name.
+// This uses Mailgun's syntax,
+// but the replacing is done by personalize, not by Mailgun.
+func recipientVar(name string) string {
+	return "%recipient." + name + "%"
+}
+
+// Function personalize replaces the placeholders in body
+// with the values in vars.
+func personalize(body []byte, vars map[string]string) []byte {
+	if len(vars) == 0 {
+		return body
+	}
+	oldnew := make([]string, 0, 2*len(vars))
+	for name, val := range vars {
+		oldnew = append(oldnew, recipientVar(name), val)
+	}
+	return []byte(strings.NewReplacer(oldnew...).Replace(string(body)))
+}
+
 type mailgunSender struct {
 	mg *mailgun.MailgunImpl
 }
@@ -34,7 +82,7 @@ func newMailgunSender(domain, apiKey string) *mailgunSender {
 	}
 }
 
-func (mg *mailgunSender) send(ctx context.Context, from string, to []string, subject string, textR io.Reader, htmlR io.Reader) error {
+func (mg *mailgunSender) send(ctx context.Context, from string, to []recipient, subject string, textR io.Reader, htmlR io.Reader) error {
 	textBody, err := ioutil.ReadAll(textR)
 	if err != nil {
 		return errors.Wrap(err, "reading text body")
@@ -47,37 +95,54 @@ func (mg *mailgunSender) send(ctx context.Context, from string, to []string, sub
 		}
 	}
 
-	for len(to) > 0 {
-		var nextTo []string
-		if len(to) > mailgun.MaxNumberOfRecipients {
-			to, nextTo = to[:mailgun.MaxNumberOfRecipients], to[mailgun.MaxNumberOfRecipients:]
-		}
-
-		msg := mg.mg.NewMessage(from, subject, string(textBody))
-		msg.AddHeader("List-Unsubscribe", "https://outlived.net/unsubscribe")
-
-		if htmlBody != nil {
-			msg.SetHtml(string(htmlBody))
-		}
-
-		for _, recip := range to {
-			msg.AddRecipientAndVariables(recip, map[string]interface{}{})
-		}
-		_, _, err = mg.mg.Send(msg)
+	var failed []string
+	for _, recip := range to {
+		err = mg.sendOne(newMailMessage(from, recip, subject, textBody, htmlBody))
 		if err != nil {
-			return errors.Wrapf(err, "sending to %d recipient(s)", len(to))
+			// A bad recipient should not prevent delivery to the others.
+			log.Printf("sending to %s via mailgun: %s", recip.addr, err)
+			failed = append(failed, recip.addr)
 		}
-
-		to = nextTo
 	}
-
+	if len(failed) > 0 {
+		return fmt.Errorf("could not send to %d of %d recipient(s): %s", len(failed), len(to), strings.Join(failed, ", "))
+	}
 	return nil
 }
 
+// Method sendOne hands a single-recipient message to Mailgun.
+// The List-Unsubscribe header names the recipient's own unsubscribe URL,
+// so it must be set per message
+// (Mailgun's recipient variables are not documented to apply to headers).
+func (mg *mailgunSender) sendOne(m *mailMessage) error {
+	msg := mg.mg.NewMessage(m.from, m.subject, string(m.textBody), m.to)
+	if m.htmlBody != nil {
+		msg.SetHtml(string(m.htmlBody))
+	}
+	if m.unsubscribe == "" {
+		msg.AddHeader("List-Unsubscribe", "<"+unsubscribeURL+">")
+	} else {
+		// See RFC 8058.
+		msg.AddHeader("List-Unsubscribe", "<"+m.unsubscribe+">")
+		msg.AddHeader("List-Unsubscribe-Post", listUnsubscribePost)
+	}
+	_, _, err := mg.mg.Send(msg)
+	return err
+}
+
 type testSender struct{}
 
-func (ts *testSender) send(ctx context.Context, from string, to []string, subject string, textR io.Reader, htmlR io.Reader) error {
-	log.Printf("sending e-mail from %s, subject %s, to %s", from, subject, strings.Join(to, ", "))
+func (ts *testSender) send(ctx context.Context, from string, to []recipient, subject string, textR io.Reader, htmlR io.Reader) error {
+	var addrs []string
+	for _, recip := range to {
+		addrs = append(addrs, recip.addr)
+	}
+	log.Printf("sending e-mail from %s, subject %s, to %s", from, subject, strings.Join(addrs, ", "))
+	for _, recip := range to {
+		if len(recip.vars) > 0 {
+			log.Printf("variables for %s: %v", recip.addr, recip.vars)
+		}
+	}
 	if textR != nil {
 		textBody, err := ioutil.ReadAll(textR)
 		if err != nil {
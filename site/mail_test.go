@@ -0,0 +1,93 @@
+package site
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mailgun/mailgun-go"
+)
+
+func TestMailgunSend(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests = make(map[string]url.Values) // recipient -> form
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/example.com/messages" {
+			t.Errorf("got request for %s", req.URL.Path)
+		}
+		if err := req.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to := req.MultipartForm.Value["to"]
+		if len(to) != 1 {
+			t.Errorf("got recipients %v, want exactly one", to)
+			http.Error(w, "bad recipients", http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(to[0], "bad") {
+			http.Error(w, "no such user", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requests[to[0]] = req.MultipartForm.Value
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"message": "Queued. Thank you.", "id": "<x@example.com>"}`))
+	}))
+	defer srv.Close()
+
+	mg := mailgun.NewMailgun("example.com", "key")
+	mg.SetAPIBase(srv.URL)
+	sender := &mailgunSender{mg: mg}
+
+	to := []recipient{
+		{addr: "alice@example.com", vars: map[string]string{"name": "Alice", "unsubscribe": "https://outlived.net/unsubscribe?t=a"}},
+		{addr: "bad@example.com", vars: map[string]string{"name": "Bad", "unsubscribe": "https://outlived.net/unsubscribe?t=b"}},
+		{addr: "carol@example.com"},
+	}
+	text := "Hello, " + recipientVar("name") + "."
+	html := "<p>" + text + "</p>"
+	err := sender.send(context.Background(), from, to, "Greetings", strings.NewReader(text), strings.NewReader(html))
+	if err == nil || !strings.Contains(err.Error(), "1 of 3") {
+		t.Errorf("got error %v, want failure for 1 of 3 recipients", err)
+	}
+
+	alice := requests["alice@example.com"]
+	if alice == nil {
+		t.Fatal("no request for alice@example.com")
+	}
+	for field, want := range map[string]string{
+		"from":                    from,
+		"subject":                 "Greetings",
+		"text":                    "Hello, Alice.",
+		"html":                    "<p>Hello, Alice.</p>",
+		"h:List-Unsubscribe":      "<https://outlived.net/unsubscribe?t=a>",
+		"h:List-Unsubscribe-Post": listUnsubscribePost,
+	} {
+		if got := alice.Get(field); got != want {
+			t.Errorf("got %s %q for alice, want %q", field, got, want)
+		}
+	}
+	if _, ok := alice["recipient-variables"]; ok {
+		t.Error("got recipient-variables for alice, want none")
+	}
+
+	carol := requests["carol@example.com"]
+	if carol == nil {
+		t.Fatal("no request for carol@example.com")
+	}
+	if got, want := carol.Get("h:List-Unsubscribe"), "<"+unsubscribeURL+">"; got != want {
+		t.Errorf("got List-Unsubscribe %q for carol, want %q", got, want)
+	}
+	if _, ok := carol["h:List-Unsubscribe-Post"]; ok {
+		t.Error("got List-Unsubscribe-Post for carol, want none")
+	}
+}
@@ -0,0 +1,307 @@
+package site
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/bobg/aesite"
+	"github.com/bobg/mid"
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+// Limit on the size of a mail-event request body.
+const maxMailEventSize = 1 << 20
+
+// Type mailgunEventPayload is the body of a Mailgun webhook request.
+// See https://documentation.mailgun.com/docs/mailgun/user-manual/tracking-messages/#webhooks.
+type mailgunEventPayload struct {
+	Signature struct {
+		Timestamp string `json:"timestamp"`
+		Token     string `json:"token"`
+		Signature string `json:"signature"`
+	} `json:"signature"`
+
+	EventData struct {
+		ID             string  `json:"id"`
+		Event          string  `json:"event"`
+		Severity       string  `json:"severity"`
+		Recipient      string  `json:"recipient"`
+		Timestamp      float64 `json:"timestamp"`
+		Reason         string  `json:"reason"`
+		DeliveryStatus struct {
+			Message     string `json:"message"`
+			Description string `json:"description"`
+		} `json:"delivery-status"`
+	} `json:"event-data"`
+}
+
+// Function handleMailgunEvent handles Mailgun webhook requests
+// for the "permanent failure," "spam complaint," and "unsubscribe" events
+// (see recordMailEvent).
+// Other events are ignored.
+// Requests must be signed with the mailgun_webhook_key setting
+// (Mailgun's "HTTP webhook signing key"),
+// or if there is none, the mailgun_api_key setting.
+func (s *Server) handleMailgunEvent(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != "POST" {
+		return mid.CodeErr{C: http.StatusMethodNotAllowed}
+	}
+
+	ctx := req.Context()
+
+	var p mailgunEventPayload
+	err := json.NewDecoder(io.LimitReader(req.Body, maxMailEventSize)).Decode(&p)
+	if err != nil {
+		return errors.Wrap(mid.CodeErr{C: http.StatusBadRequest, Err: err}, "decoding Mailgun event")
+	}
+
+	key, err := optionalSetting(ctx, s.dsClient, "mailgun_webhook_key")
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		apiKey, err := aesite.GetSetting(ctx, s.dsClient, "mailgun_api_key")
+		if err != nil {
+			return errors.Wrap(err, "getting setting for mailgun_api_key")
+		}
+		key = string(apiKey)
+	}
+	err = verifyMailgunSignature(key, p.Signature.Timestamp, p.Signature.Token, p.Signature.Signature, time.Now())
+	if err != nil {
+		return errors.Wrap(mid.CodeErr{C: http.StatusUnauthorized, Err: err}, "verifying Mailgun event")
+	}
+
+	ev := p.mailEvent()
+	if ev == nil {
+		return nil
+	}
+	id := p.EventData.ID
+	if id == "" {
+		// Each webhook request has a new random token,
+		// so a replayed request is a duplicate of this one.
+		id = "token:" + p.Signature.Token
+	}
+	return s.recordMailEvent(ctx, id, ev)
+}
+
+// How far the timestamp of a Mailgun webhook request may be from the current time.
+// Older requests are rejected so that captured ones can't be replayed later.
+const mailgunMaxClockSkew = 5 * time.Minute
+
+// Function verifyMailgunSignature checks the signature of a Mailgun webhook request:
+// the hex-encoded HMAC-SHA256 of the timestamp and token, keyed with the given key.
+// It also checks that the timestamp (in Unix seconds) is within mailgunMaxClockSkew of now.
+// See https://documentation.mailgun.com/docs/mailgun/user-manual/tracking-messages/#securing-webhooks.
+func verifyMailgunSignature(key, timestamp, token, signature string, now time.Time) error {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "parsing timestamp")
+	}
+	if d := now.Sub(time.Unix(secs, 0)); d > mailgunMaxClockSkew || d < -mailgunMaxClockSkew {
+		return fmt.Errorf("timestamp %s is %s from now", timestamp, d.Round(time.Second))
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	io.WriteString(mac, timestamp)
+	io.WriteString(mac, token)
+
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.Wrap(err, "decoding signature")
+	}
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// Function mailEvent converts a Mailgun event to a MailEvent,
+// or returns nil if it is not one of the events that recordMailEvent cares about.
+func (p *mailgunEventPayload) mailEvent() *outlived.MailEvent {
+	d := &p.EventData
+
+	var typ outlived.MailEventType
+	switch d.Event {
+	case "failed":
+		// Temporary failures are retried by Mailgun.
+		if d.Severity != "permanent" {
+			return nil
+		}
+		typ = outlived.MailEventBounced
+	case "complained":
+		typ = outlived.MailEventComplained
+	case "unsubscribed":
+		typ = outlived.MailEventUnsubscribed
+	default:
+		return nil
+	}
+
+	detail := d.DeliveryStatus.Description
+	if detail == "" {
+		detail = d.DeliveryStatus.Message
+	}
+	if detail == "" {
+		detail = d.Reason
+	}
+
+	secs, frac := math.Modf(d.Timestamp)
+	return &outlived.MailEvent{
+		Time:   time.Unix(int64(secs), int64(frac*1e9)).UTC(),
+		Type:   typ,
+		Addr:   d.Recipient,
+		Source: "mailgun",
+		Detail: detail,
+	}
+}
+
+// Type genericMailEvent is the body of a request to handleMailEvent.
+type genericMailEvent struct {
+	// ID optionally identifies the event, so that it is recorded only once.
+	ID string `json:"id"`
+
+	// Type is "bounced," "complained," or "unsubscribed."
+	// See outlived.MailEventType.
+	Type string `json:"type"`
+
+	Recipient string `json:"recipient"`
+
+	// Time is when the event happened.
+	// The default is now.
+	Time time.Time `json:"time"`
+
+	// Source is the name of the mail service.
+	// The default is "generic."
+	Source string `json:"source"`
+
+	Detail string `json:"detail"`
+}
+
+// Function handleMailEvent handles mail events (see recordMailEvent)
+// from mail services other than Mailgun,
+// in the format of genericMailEvent.
+// It is for admins only (see checkMasterKey).
+func (s *Server) handleMailEvent(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != "POST" {
+		return mid.CodeErr{C: http.StatusMethodNotAllowed}
+	}
+
+	err := s.checkMasterKey(req)
+	if err != nil {
+		return err
+	}
+
+	var g genericMailEvent
+	err = json.NewDecoder(io.LimitReader(req.Body, maxMailEventSize)).Decode(&g)
+	if err != nil {
+		return errors.Wrap(mid.CodeErr{C: http.StatusBadRequest, Err: err}, "decoding mail event")
+	}
+	typ, err := outlived.ParseMailEventType(g.Type)
+	if err != nil {
+		return mid.CodeErr{C: http.StatusBadRequest, Err: err}
+	}
+
+	ev := &outlived.MailEvent{
+		Time:   g.Time,
+		Type:   typ,
+		Addr:   g.Recipient,
+		Source: g.Source,
+		Detail: g.Detail,
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ev.Source == "" {
+		ev.Source = "generic"
+	}
+
+	return s.recordMailEvent(req.Context(), g.ID, ev)
+}
+
+// Function recordMailEvent adds ev to the event history of the user it's about
+// and updates the user accordingly (see outlived.MailEvent.Apply).
+// If id is not empty,
+// an event with the same id is recorded only once.
+// Events for unknown addresses are logged and otherwise ignored.
+func (s *Server) recordMailEvent(ctx context.Context, id string, ev *outlived.MailEvent) error {
+	email, err := aesite.CanonicalizeEmail(ev.Addr)
+	if err != nil {
+		return errors.Wrap(mid.CodeErr{C: http.StatusBadRequest, Err: err}, "canonicalizing recipient address")
+	}
+
+	var (
+		userKey = datastore.NameKey("User", email, nil)
+		evKey   = datastore.IncompleteKey("MailEvent", userKey)
+	)
+	if id != "" {
+		evKey = datastore.NameKey("MailEvent", id, userKey)
+	}
+
+	var found, dup, changed bool
+	_, err = s.dsClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		found, dup, changed = false, false, false
+
+		var u outlived.User
+		err := tx.Get(userKey, &u)
+		if errors.Is(err, datastore.ErrNoSuchEntity) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "getting user %s", email)
+		}
+		found = true
+
+		if id != "" {
+			var prev outlived.MailEvent
+			err = tx.Get(evKey, &prev)
+			if err == nil {
+				dup = true
+				return nil
+			}
+			if !errors.Is(err, datastore.ErrNoSuchEntity) {
+				return errors.Wrapf(err, "getting mail event %s", id)
+			}
+		}
+
+		_, err = tx.Put(evKey, ev)
+		if err != nil {
+			return errors.Wrap(err, "storing mail event")
+		}
+
+		changed = ev.Apply(&u)
+		if changed {
+			_, err = tx.Put(userKey, &u)
+			if err != nil {
+				return errors.Wrapf(err, "updating user %s", email)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "recording %s event for %s", ev.Type, email)
+	}
+
+	switch {
+	case !found:
+		log.Printf("ignoring %s event for unknown address %s", ev.Type, email)
+	case dup:
+		log.Printf("ignoring duplicate %s event %s for %s", ev.Type, id, email)
+	case changed:
+		log.Printf("recorded %s event for %s and stopped their mail", ev.Type, email)
+	default:
+		log.Printf("recorded %s event for %s", ev.Type, email)
+	}
+	return nil
+}
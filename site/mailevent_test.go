@@ -0,0 +1,113 @@
+package site
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"outlived"
+)
+
+func TestVerifyMailgunSignature(t *testing.T) {
+	const (
+		key       = "webhook-key"
+		timestamp = "1712534400"
+		token     = "0123456789abcdef"
+	)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + token))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	now := time.Unix(1712534400+60, 0)
+
+	if err := verifyMailgunSignature(key, timestamp, token, sig, now); err != nil {
+		t.Errorf("good signature: %s", err)
+	}
+	if err := verifyMailgunSignature("other-key", timestamp, token, sig, now); err == nil {
+		t.Error("got no error with the wrong key")
+	}
+	if err := verifyMailgunSignature(key, "1712534401", token, sig, now); err == nil {
+		t.Error("got no error with a different timestamp")
+	}
+	if err := verifyMailgunSignature(key, timestamp, token, "not hex", now); err == nil {
+		t.Error("got no error with a malformed signature")
+	}
+
+	// A correctly signed but stale (e.g. replayed) request.
+	if err := verifyMailgunSignature(key, timestamp, token, sig, now.Add(time.Hour)); err == nil {
+		t.Error("got no error with a stale timestamp")
+	}
+	if err := verifyMailgunSignature(key, timestamp, token, sig, now.Add(-time.Hour)); err == nil {
+		t.Error("got no error with a future timestamp")
+	}
+	if err := verifyMailgunSignature(key, "soon", token, sig, now); err == nil {
+		t.Error("got no error with a malformed timestamp")
+	}
+}
+
+func TestMailgunEvent(t *testing.T) {
+	cases := []struct {
+		name, body string
+		want       *outlived.MailEvent
+	}{
+		{
+			name: "permanent_failure",
+			body: `{"event-data": {"id": "a", "event": "failed", "severity": "permanent", "recipient": "alice@example.com", "timestamp": 1712534400.5, "reason": "bounce", "delivery-status": {"message": "550 5.1.1", "description": "No such user"}}}`,
+			want: &outlived.MailEvent{
+				Time:   time.Unix(1712534400, 5e8).UTC(),
+				Type:   outlived.MailEventBounced,
+				Addr:   "alice@example.com",
+				Source: "mailgun",
+				Detail: "No such user",
+			},
+		},
+		{
+			name: "temporary_failure",
+			body: `{"event-data": {"id": "b", "event": "failed", "severity": "temporary", "recipient": "alice@example.com", "timestamp": 1712534400}}`,
+		},
+		{
+			name: "complained",
+			body: `{"event-data": {"id": "c", "event": "complained", "recipient": "bob@example.com", "timestamp": 1712534400}}`,
+			want: &outlived.MailEvent{
+				Time:   time.Unix(1712534400, 0).UTC(),
+				Type:   outlived.MailEventComplained,
+				Addr:   "bob@example.com",
+				Source: "mailgun",
+			},
+		},
+		{
+			name: "unsubscribed",
+			body: `{"event-data": {"id": "d", "event": "unsubscribed", "recipient": "bob@example.com", "timestamp": 1712534400}}`,
+			want: &outlived.MailEvent{
+				Time:   time.Unix(1712534400, 0).UTC(),
+				Type:   outlived.MailEventUnsubscribed,
+				Addr:   "bob@example.com",
+				Source: "mailgun",
+			},
+		},
+		{
+			name: "delivered",
+			body: `{"event-data": {"id": "e", "event": "delivered", "recipient": "bob@example.com", "timestamp": 1712534400}}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var p mailgunEventPayload
+			err := json.Unmarshal([]byte(c.body), &p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := p.mailEvent()
+			switch {
+			case got == nil && c.want == nil:
+			case got == nil || c.want == nil:
+				t.Errorf("got %+v, want %+v", got, c.want)
+			case !got.Time.Equal(c.want.Time) || got.Type != c.want.Type || got.Addr != c.want.Addr || got.Source != c.want.Source || got.Detail != c.want.Detail:
+				t.Errorf("got %+v, want %+v", *got, *c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package site
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/bobg/aesite"
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+// The setting recording that MigrateUsers has run
+// (with the time it finished as its value).
+const usersMigratedSetting = "users_migrated"
+
+// MigrateUsers rewrites every user in the datastore,
+// removing the obsolete TZOffset and TZSector properties
+// (and setting TZName from TZOffset where it was missing)
+// and storing default mail preferences where they were missing
+// (see outlived.User.Load).
+// Until then, users stored before the TZName and SendHour properties existed
+// are invisible to the queries in handleSend and get no mail.
+// It returns the number of users rewritten.
+//
+// The handleSend cron job calls this (via ensureUsersMigrated) on its first run after a deploy,
+// so it is not necessary to call it by hand.
+func (s *Server) MigrateUsers(ctx context.Context) (int, error) {
+	keys, err := s.dsClient.GetAll(ctx, datastore.NewQuery("User").KeysOnly(), nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting user keys")
+	}
+	for _, key := range keys {
+		// Rewrite each user in a transaction,
+		// so as not to undo a concurrent change to its preferences.
+		_, err = s.dsClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			var u outlived.User
+			if err := tx.Get(key, &u); err != nil {
+				return err
+			}
+			_, err := tx.Put(key, &u)
+			return err
+		})
+		if err != nil {
+			return 0, errors.Wrapf(err, "rewriting user %s", key.Name)
+		}
+	}
+
+	err = aesite.SetSetting(ctx, s.dsClient, usersMigratedSetting, []byte(time.Now().Format(time.RFC3339)))
+	return len(keys), errors.Wrap(err, "recording migration")
+}
+
+// Function ensureUsersMigrated calls MigrateUsers
+// if it has not already run.
+func (s *Server) ensureUsersMigrated(ctx context.Context) error {
+	_, err := aesite.GetSetting(ctx, s.dsClient, usersMigratedSetting)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, datastore.ErrNoSuchEntity) {
+		return errors.Wrapf(err, "getting setting %s", usersMigratedSetting)
+	}
+	n, err := s.MigrateUsers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "migrating users")
+	}
+	log.Printf("migrated %d user(s)", n)
+	return nil
+}
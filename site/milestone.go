@@ -0,0 +1,104 @@
+package site
+
+import (
+	"context"
+	"fmt"
+	htemplate "html/template"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+// How long lifespanStats may reuse previously computed stats.
+const lifespanStatsLifetime = 24 * time.Hour
+
+// Function lifespanStats returns the lifespan stats of all figures,
+// recomputing them at most once per lifespanStatsLifetime.
+func (s *Server) lifespanStats(ctx context.Context) (*outlived.LifespanStats, error) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	if s.stats != nil && time.Since(s.statsTime) < lifespanStatsLifetime {
+		return s.stats, nil
+	}
+	stats, err := s.figures.LifespanStats(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "computing lifespan stats")
+	}
+	s.stats, s.statsTime = stats, time.Now()
+	return stats, nil
+}
+
+// Function sendMilestoneMail tells users in the given time zone who were all born on the same day
+// about the milestones they reach on the given day.
+// Each user gets it at most once,
+// even if sending is retried (see milestoneIdemKey),
+// except in resume mode (see sendOptions),
+// when only the group's sendRecord prevents duplicates.
+func (s *Server) sendMilestoneMail(ctx context.Context, opts sendOptions, tzname string, today outlived.Date, milestones []*outlived.Milestone, users []*outlived.User) (*groupReport, error) {
+	var (
+		born = users[0].Born
+		keys []string
+	)
+	for _, m := range milestones {
+		keys = append(keys, m.Key)
+	}
+	g := &groupReport{
+		ID:         sendGroupID(today, tzname, "milestone", born, strings.Join(keys, ",")),
+		Subject:    milestones[0].Title,
+		Milestones: keys,
+	}
+
+	textBody, htmlBody, err := s.renderMilestoneMail(born, milestones)
+	if err != nil {
+		return g, g.fail(err)
+	}
+
+	idemKey := func(u *outlived.User) string {
+		return milestoneIdemKey(u, today, milestones)
+	}
+	err = s.sendGroup(ctx, opts, today, g, users, idemKey, g.Subject, textBody, htmlBody)
+	if err != nil {
+		return g, err
+	}
+	if g.Status == "sent" {
+		log.Printf("sent milestone message to %d users born %s about %d milestone(s)", len(users)-len(g.Skipped), born, len(milestones))
+	}
+
+	return g, nil
+}
+
+// Function milestoneIdemKey gives the aesite.Idempotent key
+// for the mail to u about the given milestones on the given day.
+func milestoneIdemKey(u *outlived.User, today outlived.Date, milestones []*outlived.Milestone) string {
+	return fmt.Sprintf("milestone %s %s %s", u.Email, today.YYYYMMDD(), milestoneKeys(milestones))
+}
+
+// Function milestoneKeys joins the keys of the given milestones.
+func milestoneKeys(milestones []*outlived.Milestone) string {
+	keys := make([]string, 0, len(milestones))
+	for _, m := range milestones {
+		keys = append(keys, m.Key)
+	}
+	return strings.Join(keys, ",")
+}
+
+// Function renderMilestoneMail produces the bodies of the mail
+// telling someone born on the given date about their milestones.
+// The bodies contain placeholders for the variables in dailyMailVars.
+func (s *Server) renderMilestoneMail(born outlived.Date, milestones []*outlived.Milestone) (textBody, htmlBody []byte, err error) {
+	dict := map[string]interface{}{
+		"born":            born,
+		"alivedays":       recipientVar("alivedays"),
+		"unsubscribe":     recipientVar("unsubscribe"),
+		"unsubscribeHref": htemplate.HTMLAttr(`href="` + recipientVar("unsubscribe") + `"`),
+		"milestones":      milestones,
+	}
+
+	textBody, htmlBody, err = s.tmpl.renderMail("mail/milestone", dict)
+	return textBody, htmlBody, errors.Wrap(err, "rendering milestone mail")
+}
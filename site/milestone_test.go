@@ -0,0 +1,32 @@
+package site
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"outlived"
+)
+
+func TestRenderMilestoneMail(t *testing.T) {
+	var (
+		born       = outlived.Date{Y: 2000, M: time.January, D: 1}
+		milestones = outlived.Milestones(born, born.AddDays(10000), outlived.Date{}, nil)
+	)
+	if len(milestones) != 1 {
+		t.Fatalf("got %d milestones, want 1", len(milestones))
+	}
+
+	s := &Server{tmpl: testTemplates(t)}
+	textBody, htmlBody, err := s.renderMilestoneMail(born, milestones)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{string(textBody), string(htmlBody)} {
+		for _, want := range []string{"You are 10,000 days old!", recipientVar("alivedays"), recipientVar("unsubscribe")} {
+			if !strings.Contains(body, want) {
+				t.Errorf("body does not contain %q:\n%s", want, body)
+			}
+		}
+	}
+}
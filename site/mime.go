@@ -0,0 +1,148 @@
+package site
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const unsubscribeURL = "https://outlived.net/unsubscribe"
+
+// The List-Unsubscribe-Post header value
+// that marks a List-Unsubscribe URL as one-click (RFC 8058).
+const listUnsubscribePost = "List-Unsubscribe=One-Click"
+
+// Type mailMessage is an e-mail message to a single recipient.
+// Senders that must produce the message themselves (like smtpSender) render it with bytes;
+// mailgunSender hands its parts to Mailgun.
+type mailMessage struct {
+	from, to, subject string
+	textBody          []byte
+	htmlBody          []byte // optional
+	date              time.Time
+	unsubscribe       string // optional one-click unsubscribe URL; default is unsubscribeURL
+}
+
+// Function newMailMessage produces the message for one recipient
+// of a possibly multi-recipient send,
+// with the recipient's placeholders replaced.
+func newMailMessage(from string, recip recipient, subject string, textBody, htmlBody []byte) *mailMessage {
+	msg := &mailMessage{
+		from:        from,
+		to:          recip.addr,
+		subject:     subject,
+		textBody:    personalize(textBody, recip.vars),
+		unsubscribe: recip.vars["unsubscribe"],
+	}
+	if htmlBody != nil {
+		msg.htmlBody = personalize(htmlBody, recip.vars)
+	}
+	return msg
+}
+
+// Function bytes renders m in RFC 5322 format,
+// as a multipart/alternative MIME message if it has an HTML body.
+func (m *mailMessage) bytes() ([]byte, error) {
+	fromAddr, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing from address %s", m.from)
+	}
+
+	msgID, err := messageID(fromAddr.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	date := m.date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	buf := new(bytes.Buffer)
+	writeHeader := func(name, value string) {
+		fmt.Fprintf(buf, "%s: %s\r\n", name, value)
+	}
+	writeHeader("From", fromAddr.String())
+	writeHeader("To", m.to)
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", m.subject))
+	writeHeader("Date", date.Format(time.RFC1123Z))
+	writeHeader("Message-ID", msgID)
+	if m.unsubscribe == "" {
+		writeHeader("List-Unsubscribe", "<"+unsubscribeURL+">")
+	} else {
+		// See RFC 8058.
+		writeHeader("List-Unsubscribe", "<"+m.unsubscribe+">")
+		writeHeader("List-Unsubscribe-Post", listUnsubscribePost)
+	}
+	writeHeader("MIME-Version", "1.0")
+
+	if m.htmlBody == nil {
+		writeHeader("Content-Type", `text/plain; charset="utf-8"`)
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		err = writeQP(buf, m.textBody)
+		return buf.Bytes(), err
+	}
+
+	mw := multipart.NewWriter(buf)
+	writeHeader("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": mw.Boundary()}))
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{contentType: "text/plain", body: m.textBody},
+		{contentType: "text/html", body: m.htmlBody},
+	} {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", part.contentType+`; charset="utf-8"`)
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating %s part", part.contentType)
+		}
+		err = writeQP(pw, part.body)
+		if err != nil {
+			return nil, errors.Wrapf(err, "writing %s part", part.contentType)
+		}
+	}
+
+	err = mw.Close()
+	return buf.Bytes(), errors.Wrap(err, "closing multipart message")
+}
+
+func writeQP(w io.Writer, body []byte) error {
+	qw := quotedprintable.NewWriter(w)
+	_, err := qw.Write(body)
+	if err != nil {
+		return errors.Wrap(err, "writing quoted-printable body")
+	}
+	return qw.Close()
+}
+
+// Function messageID generates a new, unique Message-ID
+// in the domain of the given address.
+func messageID(addr string) (string, error) {
+	var b [16]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return "", errors.Wrap(err, "generating message ID")
+	}
+	domain := "outlived.net"
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		domain = addr[i+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b[:]), domain), nil
+}
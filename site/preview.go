@@ -0,0 +1,148 @@
+package site
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bobg/aesite"
+	"github.com/bobg/mid"
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+// MailPreview is a message that handleSend would send to a user.
+type MailPreview struct {
+	Subject    string
+	Text, HTML []byte
+
+	// Milestones are the milestones in a milestone message.
+	Milestones []*outlived.Milestone `json:",omitempty"`
+
+	// Figures are the figures in a regular message.
+	Figures []*outlived.Figure `json:",omitempty"`
+}
+
+// PreviewMail returns the messages that handleSend would send
+// to the user with the given e-mail address on the given (local) day,
+// without sending anything:
+// first any milestone mail,
+// then the regular mail if the user's preferred frequency calls for it.
+// It disregards whether the user is active and verified
+// and whether mail was already sent that day.
+func (s *Server) PreviewMail(ctx context.Context, email string, today outlived.Date) ([]*MailPreview, error) {
+	var u outlived.User
+	err := aesite.LookupUser(ctx, s.dsClient, email, &u)
+	if err != nil {
+		return nil, errors.Wrapf(err, "looking up user %s", email)
+	}
+	return s.previewMail(ctx, &u, today)
+}
+
+// Function previewMail is PreviewMail for a given user.
+// It selects milestones and figures the way sendTZ does,
+// with outlived.Milestones, regularMailDue, and composeMail.
+func (s *Server) previewMail(ctx context.Context, u *outlived.User, today outlived.Date) ([]*MailPreview, error) {
+	vars, err := dailyMailVars(u, today)
+	if err != nil {
+		return nil, errors.Wrapf(err, "computing mail variables for %s", u.Email)
+	}
+
+	var result []*MailPreview
+
+	stats, err := s.lifespanStats(ctx)
+	if err != nil {
+		// As in sendTZ.
+		log.Printf("%s", err)
+	}
+	if milestones := outlived.Milestones(u.Born, today, u.LastSent, stats); len(milestones) > 0 {
+		textBody, htmlBody, err := s.renderMilestoneMail(u.Born, milestones)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &MailPreview{
+			Subject:    milestones[0].Title,
+			Text:       personalize(textBody, vars),
+			HTML:       personalize(htmlBody, vars),
+			Milestones: milestones,
+		})
+	}
+
+	local := time.Date(today.Y, today.M, today.D, u.SendHour, 0, 0, 0, loadLocation(u.TZName))
+	if regularMailDue(u, local) {
+		subj, textBody, htmlBody, figures, err := s.composeMail(ctx, u, today)
+		if err != nil {
+			return nil, err
+		}
+		if len(figures) > 0 {
+			result = append(result, &MailPreview{
+				Subject: subj,
+				Text:    personalize(textBody, vars),
+				HTML:    personalize(htmlBody, vars),
+				Figures: figures,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// WriteMailPreviews writes a human-readable rendition of the given previews to w.
+// If part is "text" or "html",
+// only that part of each message is included.
+func WriteMailPreviews(w io.Writer, previews []*MailPreview, part string) error {
+	if len(previews) == 0 {
+		_, err := fmt.Fprintln(w, "No mail.")
+		return err
+	}
+	for i, p := range previews {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "===== Subject: %s\n", p.Subject)
+		if part != "html" {
+			fmt.Fprintf(w, "----- text\n%s\n", p.Text)
+		}
+		if part != "text" {
+			fmt.Fprintf(w, "----- html\n%s\n", p.HTML)
+		}
+	}
+	return nil
+}
+
+// Function handlePreviewMail serves the output of PreviewMail
+// for the user in parameter "email" on the day in parameter "date" (YYYY-MM-DD),
+// written by WriteMailPreviews with parameter "part".
+// It is for admins only (see checkMasterKey).
+func (s *Server) handlePreviewMail(w http.ResponseWriter, req *http.Request) error {
+	err := s.checkMasterKey(req)
+	if err != nil {
+		return err
+	}
+
+	var (
+		email = req.FormValue("email")
+		part  = req.FormValue("part")
+	)
+	today, err := outlived.ParseDate(req.FormValue("date"))
+	if err != nil {
+		return errors.Wrap(mid.CodeErr{C: http.StatusBadRequest, Err: err}, "parsing date")
+	}
+	switch part {
+	case "", "text", "html":
+	default:
+		return mid.CodeErr{C: http.StatusBadRequest, Err: fmt.Errorf("unknown part %s", part)}
+	}
+
+	previews, err := s.PreviewMail(req.Context(), email, today)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	return WriteMailPreviews(w, previews, part)
+}
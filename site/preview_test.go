@@ -0,0 +1,176 @@
+package site
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"outlived"
+)
+
+func TestWriteMailPreviews(t *testing.T) {
+	previews := []*MailPreview{
+		{Subject: "Happy birthday!", Text: []byte("milestone text"), HTML: []byte("<p>milestone html</p>")},
+		{Subject: subject, Text: []byte("daily text"), HTML: []byte("<p>daily html</p>")},
+	}
+
+	cases := []struct {
+		part          string
+		want, notWant []string
+	}{
+		{
+			part: "",
+			want: []string{"Subject: Happy birthday!", "Subject: " + subject, "milestone text", "<p>milestone html</p>", "daily text", "<p>daily html</p>"},
+		},
+		{
+			part:    "text",
+			want:    []string{"Subject: Happy birthday!", "milestone text", "daily text"},
+			notWant: []string{"html"},
+		},
+		{
+			part:    "html",
+			want:    []string{"Subject: " + subject, "<p>milestone html</p>", "<p>daily html</p>"},
+			notWant: []string{"text"},
+		},
+	}
+	for _, c := range cases {
+		t.Run("part_"+c.part, func(t *testing.T) {
+			buf := new(strings.Builder)
+			err := WriteMailPreviews(buf, previews, c.part)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := buf.String()
+			for _, want := range c.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("output does not contain %q:\n%s", want, got)
+				}
+			}
+			for _, notWant := range c.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("output contains %q:\n%s", notWant, got)
+				}
+			}
+		})
+	}
+
+	buf := new(strings.Builder)
+	err := WriteMailPreviews(buf, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "No mail.\n" {
+		t.Errorf("got %q for no previews", got)
+	}
+}
+
+func TestPreviewMailSelection(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		born  = outlived.Date{Y: 2000, M: time.January, D: 1}
+		today = outlived.Date{Y: 2023, M: time.January, D: 1} // a Sunday, and a birthday
+		since = today.Since(born)
+	)
+
+	store, err := outlived.OpenFileFigureStore(filepath.Join(t.TempDir(), "figures.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	err = store.ReplaceFigures(ctx, []*outlived.Figure{
+		{Link: "a", Name: "A", Desc: "English poet", DaysAlive: since - 1, Pageviews: 10, Updated: now},
+		{Link: "b", Name: "B", Desc: "Composer", DaysAlive: since - 1, Pageviews: 30, Updated: now},
+		{Link: "c", Name: "C", Desc: "Poet and painter", DaysAlive: since - 3, Pageviews: 20, Updated: now},
+		{Link: "d", Name: "D", Desc: "Painter", DaysAlive: since + 100, Pageviews: 50, Updated: now},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := store.LifespanStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{figures: store, tmpl: testTemplates(t)}
+
+	cases := []struct {
+		name        string
+		u           outlived.User
+		wantRegular []string
+	}{
+		{
+			name:        "daily",
+			u:           outlived.User{Frequency: outlived.FrequencyDaily, FiguresPerMail: 24},
+			wantRegular: []string{"b", "a"},
+		},
+		{
+			name:        "daily_categories",
+			u:           outlived.User{Frequency: outlived.FrequencyDaily, FiguresPerMail: 24, Categories: []string{"poet"}},
+			wantRegular: []string{"a"},
+		},
+		{
+			name:        "weekly",
+			u:           outlived.User{Frequency: outlived.FrequencyWeekly, FiguresPerMail: 24, Categories: []string{"poet"}},
+			wantRegular: []string{"c", "a"},
+		},
+		{
+			name: "milestones_only",
+			u:    outlived.User{Frequency: outlived.FrequencyMilestones, FiguresPerMail: 24},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			u := c.u
+			u.Email, u.Born, u.TZName, u.SendHour = "user@example.com", born, "UTC", outlived.DefaultSendHour
+
+			previews, err := s.previewMail(ctx, &u, today)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The milestone message has what sendTZ selects.
+			var wantMilestones []string
+			for _, m := range outlived.Milestones(born, today, outlived.Date{}, stats) {
+				wantMilestones = append(wantMilestones, m.Key)
+			}
+			if len(wantMilestones) == 0 {
+				t.Fatal("test data produces no milestones")
+			}
+			if len(previews) == 0 || len(previews[0].Milestones) == 0 {
+				t.Fatal("no milestone message")
+			}
+			var gotMilestones []string
+			for _, m := range previews[0].Milestones {
+				gotMilestones = append(gotMilestones, m.Key)
+			}
+			if !reflect.DeepEqual(gotMilestones, wantMilestones) {
+				t.Errorf("got milestones %v, want %v", gotMilestones, wantMilestones)
+			}
+
+			// The regular message has what sendMail selects.
+			var gotRegular []string
+			if len(previews) > 1 {
+				for _, f := range previews[1].Figures {
+					gotRegular = append(gotRegular, f.Link)
+				}
+			}
+			if !reflect.DeepEqual(gotRegular, c.wantRegular) {
+				t.Errorf("got regular figures %v, want %v", gotRegular, c.wantRegular)
+			}
+			if c.wantRegular == nil {
+				return
+			}
+			figures, err := s.figuresForMail(ctx, &u, today)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(figures) != len(gotRegular) {
+				t.Errorf("preview has %d figures, figuresForMail has %d", len(gotRegular), len(figures))
+			}
+		})
+	}
+}
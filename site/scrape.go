@@ -2,7 +2,6 @@ package site
 
 import (
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,7 +9,9 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/bobg/basexx"
+	"cloud.google.com/go/datastore"
+	"github.com/bobg/aesite"
+	"github.com/bobg/mid"
 	"github.com/pkg/errors"
 
 	"outlived"
@@ -32,29 +33,65 @@ var daysInMonth = []int{
 	31,
 }
 
-func (s *Server) scrapeQueue() string {
-	return fmt.Sprintf("projects/%s/locations/%s/queues/scrape", s.projectID, s.locationID)
+// Kinds of task in the scrape queue.
+var (
+	scrapedayTask = taskDef[scrapedayPayload]{
+		Kind:     "scrapeday",
+		Queue:    "scrape",
+		Deadline: 10 * time.Minute,
+		Legacy:   legacyScrapedayPayload,
+	}
+	scrapepersonTask = taskDef[scrapepersonPayload]{
+		Kind:     "scrapeperson",
+		Queue:    "scrape",
+		Deadline: 5 * time.Minute,
+		Legacy:   legacyScrapepersonPayload,
+	}
+)
+
+type scrapedayPayload struct {
+	Run   string     `json:"run"` // see outlived.ScrapeRunID
+	Month time.Month `json:"m"`
+	Day   int        `json:"d"`
+}
+
+type scrapepersonPayload struct {
+	Run   string `json:"run"`
+	Href  string `json:"href"`
+	Title string `json:"title"`
+	Desc  string `json:"desc"`
 }
 
-func (s *Server) taskName(inp string) string {
-	hasher := sha256.New()
-	hasher.Write([]byte{1}) // version of this hash
-	hasher.Write([]byte(inp))
-	h := hasher.Sum(nil)
-	src := basexx.NewBuffer(h, basexx.Binary)
-	buf := make([]byte, basexx.Length(256, 50, len(h)))
-	dest := basexx.NewBuffer(buf[:], basexx.Base50)
-	_, err := basexx.Convert(dest, src)
+// Function legacyScrapedayPayload decodes a scrapeday task of the old form,
+// /t/scrapeday?m=M&d=D.
+// It belongs to no scrape run.
+func legacyScrapedayPayload(v url.Values) (*scrapedayPayload, error) {
+	m, err := strconv.Atoi(v.Get("m"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing value for m: %s", v.Get("m"))
+	}
+	d, err := strconv.Atoi(v.Get("d"))
 	if err != nil {
-		panic(err)
+		return nil, errors.Wrapf(err, "parsing value for d: %s", v.Get("d"))
 	}
-	converted := dest.Written()
-	return fmt.Sprintf("%s/tasks/%s", s.scrapeQueue(), string(converted))
+	return &scrapedayPayload{Month: time.Month(m), Day: d}, nil
+}
+
+// Function legacyScrapepersonPayload decodes a scrapeperson task of the old form,
+// /t/scrapeperson?href=HREF&title=TITLE&desc=DESC.
+// It belongs to no scrape run.
+func legacyScrapepersonPayload(v url.Values) (*scrapepersonPayload, error) {
+	return &scrapepersonPayload{Href: v.Get("href"), Title: v.Get("title"), Desc: v.Get("desc")}, nil
+}
+
+func (s *Server) scrapeQueue() string {
+	return s.queuePath("scrape")
 }
 
 // Function handleScrape launches a new scrape: one task for each day of the year.
-// (Each handled by handleScrapeday.)
+// (Each handled by scrapeday.)
 // A task is queued only if the scrape queue is empty.
+// The scrape is recorded as an outlived.ScrapeRun (see startScrapeRun).
 func (s *Server) handleScrape(w http.ResponseWriter, req *http.Request) error {
 	err := s.checkCron(req)
 	if err != nil {
@@ -71,107 +108,93 @@ func (s *Server) handleScrape(w http.ResponseWriter, req *http.Request) error {
 		return nil
 	}
 
-	log.Print("starting new scrape")
+	runID, run, err := s.startScrapeRun(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("starting new scrape, run %s", runID)
+
+	defer func() {
+		_, err := s.dsClient.Put(ctx, scrapeRunKey(runID), run)
+		if err != nil {
+			log.Printf("storing scrape run %s: %s", runID, err)
+		}
+	}()
 
-	// err == iterator.Done (i.e., the queue is empty)
 	for m := time.January; m <= time.December; m++ {
 		for d := 1; d <= daysInMonth[m]; d++ {
-			err = s.tasks.enqueueTask(
-				ctx,
-				s.scrapeQueue(),
-				s.taskName(fmt.Sprintf("%d/%d", m, d)),
-				fmt.Sprintf("/t/scrapeday?m=%d&d=%d", m, d),
-			)
+			err = scrapedayTask.enqueue(ctx, s, &scrapedayPayload{Run: runID, Month: m, Day: d}, taskOpts{Key: fmt.Sprintf("%d/%d", m, d)})
 			if err != nil {
-				return errors.Wrapf(err, "queueing /scrapeday task for m=%d, d=%d", m, d)
+				return errors.Wrapf(err, "queueing scrapeday task for m=%d, d=%d", m, d)
 			}
+			run.Days++
 		}
 	}
 
 	return nil
 }
 
-func (s *Server) handleScrapeday(w http.ResponseWriter, req *http.Request) error {
-	err := s.checkTaskQueue(req, "scrape")
-	if err != nil {
-		return err
-	}
-
-	mstr := req.FormValue("m")
-	m, err := strconv.Atoi(mstr)
-	if err != nil {
-		return errors.Wrapf(err, "parsing value for m: %s", mstr)
-	}
-
-	dstr := req.FormValue("d")
-	d, err := strconv.Atoi(dstr)
-	if err != nil {
-		return errors.Wrapf(err, "parsing value for d: %s", dstr)
-	}
-
+// Function scrapeday handles a scrapeday task,
+// queueing a scrapeperson task for each figure who died on the given day of the year.
+func (s *Server) scrapeday(ctx context.Context, p *scrapedayPayload) error {
+	m, d := p.Month, p.Day
 	if m < 1 || m > 12 || d < 1 || d > daysInMonth[m] {
-		return errors.Wrapf(err, "month %d, day %d is out of range", m, d)
+		return mid.CodeErr{C: http.StatusBadRequest, Err: fmt.Errorf("month %d, day %d is out of range", m, d)}
 	}
 
-	log.Printf("scraping day %s %d", time.Month(m), d)
+	log.Printf("scraping day %s %d", m, d)
 
-	ctx := req.Context()
-	return outlived.ScrapeDay(ctx, new(http.Client), time.Month(m), d, func(ctx context.Context, href, title, desc string) error {
-		u, _ := url.Parse("/t/scrapeperson")
-
-		v := url.Values{}
-		v.Set("href", href)
-		v.Set("title", title)
-		v.Set("desc", desc)
-		u.RawQuery = v.Encode()
-
-		err := s.tasks.enqueueTask(
-			ctx,
-			s.scrapeQueue(),
-			s.taskName(href),
-			u.String(),
-		)
+	var persons int
+	err := outlived.ScrapeDay(ctx, new(http.Client), m, d, func(ctx context.Context, href, title, desc string) error {
+		persons++
+		err := scrapepersonTask.enqueue(ctx, s, &scrapepersonPayload{Run: p.Run, Href: href, Title: title, Desc: desc}, taskOpts{Key: href})
 		if err != nil {
 			log.Printf("enqueueing scrapeperson task for %s (%s): %s", title, href, err)
 			// otherwise ignore error
 		}
 		return nil
 	})
+	if p.Run != "" {
+		recErr := s.recordScrapeDay(ctx, p.Run, m, d, persons, err)
+		if recErr != nil {
+			log.Print(recErr)
+		}
+	}
+	return err
 }
 
-func (s *Server) handleScrapeperson(w http.ResponseWriter, req *http.Request) error {
-	err := s.checkTaskQueue(req, "scrape")
+// Function figureSource tells where to get figure details while scraping.
+// It comes from the setting "figure_source"
+// (one of "html", "wikidata", or "merge"; default "html").
+func (s *Server) figureSource(ctx context.Context) (outlived.Source, error) {
+	val, err := aesite.GetSetting(ctx, s.dsClient, "figure_source")
+	if err == datastore.ErrNoSuchEntity {
+		return outlived.SourceHTML, nil
+	}
 	if err != nil {
-		return err
+		return "", errors.Wrap(err, "getting setting for figure_source")
 	}
+	return outlived.ParseSource(string(val))
+}
 
-	var (
-		href  = req.FormValue("href")
-		title = req.FormValue("title")
-		desc  = req.FormValue("desc")
-	)
-
-	ctx := req.Context()
-	err = outlived.ScrapePerson(ctx, new(http.Client), href, title, desc, func(ctx context.Context, title, desc, href, imgSrc, alt string, bornY, bornM, bornD, diedY, diedM, diedD, aliveDays, pageviews int) error {
-		fig := &outlived.Figure{
-			Name:      title,
-			Desc:      desc,
-			Link:      href,
-			ImgSrc:    imgSrc,
-			ImgAlt:    alt,
-			Born:      outlived.Date{Y: bornY, M: time.Month(bornM), D: bornD},
-			Died:      outlived.Date{Y: diedY, M: time.Month(diedM), D: diedD},
-			DaysAlive: aliveDays,
-			Pageviews: pageviews,
-			Updated:   time.Now(),
-		}
-		return outlived.ReplaceFigures(ctx, s.dsClient, []*outlived.Figure{fig})
-	})
+// Function scrapeperson handles a scrapeperson task,
+// updating the figure at the given href.
+func (s *Server) scrapeperson(ctx context.Context, p *scrapepersonPayload) error {
+	src, err := s.figureSource(ctx)
 	if err != nil {
-		log.Printf("scraping person %s: %s", title, err)
+		return errors.Wrap(err, "getting figure source")
+	}
+
+	_, scrapeErr := outlived.UpdateFigure(ctx, new(http.Client), s.figures, src, p.Href, p.Title, p.Desc)
+	if scrapeErr != nil {
+		log.Printf("scraping person %s: %s", p.Title, scrapeErr)
 		// Otherwise ignore this error. We'll get this person next time round.
 		// (Or the error will persist and the person will expire out of the datastore.)
 	}
 
-	return nil
+	if p.Run == "" {
+		return nil
+	}
+	return s.recordScrapePerson(ctx, p.Run, p.Href, p.Title, p.Desc, scrapeErr)
 }
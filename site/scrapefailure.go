@@ -0,0 +1,106 @@
+package site
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+
+	"cloud.google.com/go/datastore"
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+// ScrapeFailures returns the failures recorded in the given scrape run,
+// or in the most recent run if runID is empty,
+// sorted by class and href.
+// If class is not empty,
+// only failures of that class are included.
+// It also returns the ID of the run.
+func (s *Server) ScrapeFailures(ctx context.Context, runID string, class outlived.ScrapeErrorClass) (string, []*ScrapeFailureReport, error) {
+	runID, outcomes, err := s.scrapeFailures(ctx, runID, class)
+	if err != nil {
+		return "", nil, err
+	}
+	var result []*ScrapeFailureReport
+	for _, outcome := range outcomes {
+		result = append(result, newScrapeFailureReport(outcome))
+	}
+	return runID, result, nil
+}
+
+// RetryScrapeFailures scrapes again the persons who failed in the given scrape run
+// (or the most recent run if runID is empty;
+// only those whose failures are of the given class if class is not empty),
+// using the given client and figure source
+// (or, if src is empty, the one configured for the server, see figureSource).
+// Each new outcome replaces the failure in the run.
+// It returns the number of persons retried and the number that succeeded.
+func (s *Server) RetryScrapeFailures(ctx context.Context, client *http.Client, runID string, class outlived.ScrapeErrorClass, src outlived.Source) (retried, fixed int, err error) {
+	runID, outcomes, err := s.scrapeFailures(ctx, runID, class)
+	if err != nil {
+		return 0, 0, err
+	}
+	if src == "" {
+		src, err = s.figureSource(ctx)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "getting figure source")
+		}
+	}
+
+	for _, outcome := range outcomes {
+		if err = ctx.Err(); err != nil {
+			return retried, fixed, err
+		}
+
+		_, scrapeErr := outlived.UpdateFigure(ctx, client, s.figures, src, outcome.Href, outcome.Title, outcome.Desc)
+		retried++
+		if scrapeErr == nil {
+			fixed++
+		} else {
+			log.Printf("retrying %s: %s", outcome.Href, scrapeErr)
+		}
+
+		err = s.recordScrapePerson(ctx, runID, outcome.Href, outcome.Title, outcome.Desc, scrapeErr)
+		if err != nil {
+			return retried, fixed, err
+		}
+	}
+
+	return retried, fixed, nil
+}
+
+// Function scrapeFailures gets the failed outcomes in a scrape run.
+// See ScrapeFailures.
+func (s *Server) scrapeFailures(ctx context.Context, runID string, class outlived.ScrapeErrorClass) (string, []*outlived.ScrapePersonOutcome, error) {
+	if runID == "" {
+		keys, err := s.dsClient.GetAll(ctx, datastore.NewQuery("ScrapeRun").Order("-Start").Limit(1).KeysOnly(), nil)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "getting latest scrape run")
+		}
+		if len(keys) == 0 {
+			return "", nil, errors.New("no scrape runs")
+		}
+		runID = keys[0].Name
+	}
+
+	q := datastore.NewQuery("ScrapePersonOutcome").Ancestor(scrapeRunKey(runID)).Filter("OK =", false)
+	if class != "" {
+		q = q.Filter("Class =", string(class))
+	}
+	var outcomes []*outlived.ScrapePersonOutcome
+	_, err := s.dsClient.GetAll(ctx, q, &outcomes)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "getting failures in scrape run %s", runID)
+	}
+
+	sort.Slice(outcomes, func(i, j int) bool {
+		if outcomes[i].Class != outcomes[j].Class {
+			return outcomes[i].Class < outcomes[j].Class
+		}
+		return outcomes[i].Href < outcomes[j].Href
+	})
+
+	return runID, outcomes, nil
+}
@@ -0,0 +1,332 @@
+package site
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/datastore/apiv1/datastorepb"
+	"github.com/bobg/mid"
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+// How many of a run's most recent failures a ScrapeRunReport includes.
+const scrapeRunReportFailures = 20
+
+// How long ScrapeRuns are kept (see expireScrapeRuns).
+const scrapeRunLifetime = 90 * 24 * time.Hour
+
+// ScrapeRunReport describes an outlived.ScrapeRun and its progress.
+type ScrapeRunReport struct {
+	ID      string     `json:"id"`
+	Start   time.Time  `json:"start"`
+	End     *time.Time `json:"end,omitempty"`
+	Running bool       `json:"running"`
+
+	Days       int `json:"days"`        // enqueued
+	DaysDone   int `json:"days_done"`   // scraped successfully
+	DaysFailed int `json:"days_failed"` // failed so far (the task may be retried)
+
+	Persons   int `json:"persons"` // found on the pages of the scraped days
+	Attempted int `json:"attempted"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+
+	// Failures are the run's most recent failures.
+	Failures []*ScrapeFailureReport `json:"failures,omitempty"`
+}
+
+// ScrapeFailureReport describes a person who could not be scraped.
+type ScrapeFailureReport struct {
+	Time  time.Time                 `json:"time"`
+	Href  string                    `json:"href"`
+	Title string                    `json:"title"`
+	Class outlived.ScrapeErrorClass `json:"class"`
+	Err   string                    `json:"err"`
+}
+
+func newScrapeFailureReport(outcome *outlived.ScrapePersonOutcome) *ScrapeFailureReport {
+	return &ScrapeFailureReport{
+		Time:  outcome.Time,
+		Href:  outcome.Href,
+		Title: outcome.Title,
+		Class: outcome.Class,
+		Err:   outcome.Err,
+	}
+}
+
+func scrapeRunKey(id string) *datastore.Key {
+	return datastore.NameKey("ScrapeRun", id, nil)
+}
+
+// Function startScrapeRun records the start of a new ScrapeRun,
+// first finishing any unfinished ones (see finishIdleScrapeRuns).
+// The scrape queue must be empty.
+func (s *Server) startScrapeRun(ctx context.Context) (string, *outlived.ScrapeRun, error) {
+	err := s.finishIdleScrapeRuns(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	run := &outlived.ScrapeRun{Start: time.Now()}
+	id := outlived.ScrapeRunID(run.Start)
+	_, err = s.dsClient.Put(ctx, scrapeRunKey(id), run)
+	return id, run, errors.Wrapf(err, "storing scrape run %s", id)
+}
+
+// Function finishScrapeRun marks a ScrapeRun as finished,
+// as of the latest outcome recorded in it.
+func (s *Server) finishScrapeRun(ctx context.Context, key *datastore.Key, run *outlived.ScrapeRun) error {
+	run.End = run.Start
+	for _, kind := range []string{"ScrapeDayOutcome", "ScrapePersonOutcome"} {
+		var times []struct{ Time time.Time }
+		q := datastore.NewQuery(kind).Ancestor(key).Order("-Time").Limit(1).Project("Time")
+		_, err := s.dsClient.GetAll(ctx, q, &times)
+		if err != nil {
+			return errors.Wrapf(err, "getting latest %s of scrape run %s", kind, key.Name)
+		}
+		if len(times) > 0 && times[0].Time.After(run.End) {
+			run.End = times[0].Time
+		}
+	}
+	_, err := s.dsClient.Put(ctx, key, run)
+	if err != nil {
+		return errors.Wrapf(err, "storing scrape run %s", key.Name)
+	}
+	log.Printf("scrape run %s finished at %s", key.Name, run.End)
+	return nil
+}
+
+// Function recordScrapeDay records the outcome of scraping the page for the given day in the given run.
+func (s *Server) recordScrapeDay(ctx context.Context, runID string, m time.Month, d, persons int, scrapeErr error) error {
+	outcome := &outlived.ScrapeDayOutcome{
+		Time:    time.Now(),
+		Persons: persons,
+	}
+	if scrapeErr != nil {
+		outcome.Err = scrapeErr.Error()
+	}
+	key := datastore.NameKey("ScrapeDayOutcome", fmt.Sprintf("%d/%d", m, d), scrapeRunKey(runID))
+	_, err := s.dsClient.Put(ctx, key, outcome)
+	return errors.Wrapf(err, "recording outcome of %s %d in scrape run %s", m, d, runID)
+}
+
+// Function recordScrapePerson records the outcome of scraping the given person in the given run.
+func (s *Server) recordScrapePerson(ctx context.Context, runID, href, title, desc string, scrapeErr error) error {
+	outcome := &outlived.ScrapePersonOutcome{
+		Time:  time.Now(),
+		Href:  href,
+		Title: title,
+		Desc:  desc,
+		OK:    scrapeErr == nil,
+	}
+	if scrapeErr != nil {
+		outcome.Class = outlived.ScrapeErrorClassOf(scrapeErr)
+		outcome.Err = scrapeErr.Error()
+	}
+	key := datastore.NameKey("ScrapePersonOutcome", href, scrapeRunKey(runID))
+	_, err := s.dsClient.Put(ctx, key, outcome)
+	return errors.Wrapf(err, "recording outcome of %s in scrape run %s", href, runID)
+}
+
+// Function finishIdleScrapeRuns marks unfinished ScrapeRuns finished
+// if the scrape queue is empty.
+func (s *Server) finishIdleScrapeRuns(ctx context.Context) error {
+	var runs []*outlived.ScrapeRun
+	keys, err := s.dsClient.GetAll(ctx, datastore.NewQuery("ScrapeRun").Filter("End =", time.Time{}), &runs)
+	if err != nil {
+		return errors.Wrap(err, "getting unfinished scrape runs")
+	}
+	if len(runs) == 0 {
+		return nil
+	}
+
+	empty, err := s.tasks.queueEmpty(ctx, s.scrapeQueue())
+	if err != nil {
+		return errors.Wrap(err, "checking scrape queue for emptiness")
+	}
+	if !empty {
+		return nil
+	}
+
+	for i, run := range runs {
+		err = s.finishScrapeRun(ctx, keys[i], run)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScrapeRuns reports on the most recent scrape runs, newest first.
+// A run remains unfinished
+// until the next scrape starts
+// or a request to /s/scraperuns finds the scrape queue empty.
+func (s *Server) ScrapeRuns(ctx context.Context, limit int) ([]*ScrapeRunReport, error) {
+	var runs []*outlived.ScrapeRun
+	keys, err := s.dsClient.GetAll(ctx, datastore.NewQuery("ScrapeRun").Order("-Start").Limit(limit), &runs)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting scrape runs")
+	}
+
+	var result []*ScrapeRunReport
+	for i, run := range runs {
+		rep, err := s.scrapeRunReport(ctx, keys[i], run)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rep)
+	}
+	return result, nil
+}
+
+// Function scrapeRunReport tallies the outcomes recorded in a ScrapeRun.
+func (s *Server) scrapeRunReport(ctx context.Context, key *datastore.Key, run *outlived.ScrapeRun) (*ScrapeRunReport, error) {
+	rep := &ScrapeRunReport{
+		ID:      key.Name,
+		Start:   run.Start,
+		Running: run.End.IsZero(),
+		Days:    run.Days,
+	}
+	if !rep.Running {
+		end := run.End
+		rep.End = &end
+	}
+
+	var days []*outlived.ScrapeDayOutcome
+	_, err := s.dsClient.GetAll(ctx, datastore.NewQuery("ScrapeDayOutcome").Ancestor(key), &days)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting day outcomes of scrape run %s", key.Name)
+	}
+	for _, d := range days {
+		if d.Err != "" {
+			rep.DaysFailed++
+			continue
+		}
+		rep.DaysDone++
+		rep.Persons += d.Persons
+	}
+
+	persons := datastore.NewQuery("ScrapePersonOutcome").Ancestor(key)
+	rep.Succeeded, err = s.count(ctx, persons.Filter("OK =", true))
+	if err != nil {
+		return nil, errors.Wrapf(err, "counting successes in scrape run %s", key.Name)
+	}
+	rep.Failed, err = s.count(ctx, persons.Filter("OK =", false))
+	if err != nil {
+		return nil, errors.Wrapf(err, "counting failures in scrape run %s", key.Name)
+	}
+	rep.Attempted = rep.Succeeded + rep.Failed
+
+	var failures []*outlived.ScrapePersonOutcome
+	_, err = s.dsClient.GetAll(ctx, persons.Filter("OK =", false).Order("-Time").Limit(scrapeRunReportFailures), &failures)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting failures in scrape run %s", key.Name)
+	}
+	for _, f := range failures {
+		rep.Failures = append(rep.Failures, newScrapeFailureReport(f))
+	}
+
+	return rep, nil
+}
+
+// Function count counts the results of a query.
+func (s *Server) count(ctx context.Context, q *datastore.Query) (int, error) {
+	res, err := s.dsClient.RunAggregationQuery(ctx, q.NewAggregationQuery().WithCount("count"))
+	if err != nil {
+		return 0, err
+	}
+	v, ok := res["count"].(*datastorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count result of type %T", res["count"])
+	}
+	return int(v.GetIntegerValue()), nil
+}
+
+// Function handleScrapeRuns reports on recent scrape runs (see ScrapeRuns) in JSON,
+// first marking them finished if the scrape queue is empty.
+// The parameter "limit" is how many (default 10).
+// It is for admins only (see checkMasterKey).
+func (s *Server) handleScrapeRuns(w http.ResponseWriter, req *http.Request) error {
+	err := s.checkMasterKey(req)
+	if err != nil {
+		return err
+	}
+
+	limit := 10
+	if limitStr := req.FormValue("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return mid.CodeErr{C: http.StatusBadRequest, Err: fmt.Errorf("bad limit %s", limitStr)}
+		}
+	}
+
+	ctx := req.Context()
+
+	err = s.finishIdleScrapeRuns(ctx)
+	if err != nil {
+		return err
+	}
+
+	reports, err := s.ScrapeRuns(ctx, limit)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(reports), "writing report")
+}
+
+// Function expireScrapeRuns deletes ScrapeRuns older than scrapeRunLifetime,
+// with their outcomes.
+// It returns the number of runs deleted.
+func (s *Server) expireScrapeRuns(ctx context.Context) (int, error) {
+	q := datastore.NewQuery("ScrapeRun").Filter("Start <", time.Now().Add(-scrapeRunLifetime)).KeysOnly()
+	runKeys, err := s.dsClient.GetAll(ctx, q, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting expired scrape runs")
+	}
+
+	count := 0
+
+	for _, runKey := range runKeys {
+		// This kindless ancestor query gets the run and all its outcomes.
+		// The run itself is deleted last,
+		// so it can be found again if deleting the outcomes fails.
+		allKeys, err := s.dsClient.GetAll(ctx, datastore.NewQuery("").Ancestor(runKey).KeysOnly(), nil)
+		if err != nil {
+			return count, errors.Wrapf(err, "getting outcomes of scrape run %s", runKey.Name)
+		}
+		var keys []*datastore.Key
+		for _, k := range allKeys {
+			if !k.Equal(runKey) {
+				keys = append(keys, k)
+			}
+		}
+		keys = append(keys, runKey)
+
+		for len(keys) > 0 {
+			var nextKeys []*datastore.Key
+
+			if len(keys) > outlived.MultiLimit {
+				keys, nextKeys = keys[:outlived.MultiLimit], keys[outlived.MultiLimit:]
+			}
+			err = s.dsClient.DeleteMulti(ctx, keys)
+			if err != nil {
+				return count, errors.Wrapf(err, "expiring scrape run %s", runKey.Name)
+			}
+			keys = nextKeys
+		}
+		count++
+	}
+	return count, nil
+}
@@ -1,204 +1,544 @@
 package site
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	htemplate "html/template"
 	"log"
 	"net/http"
-	"strconv"
+	"sort"
 	"strings"
-	ttemplate "text/template"
 	"time"
 
 	"cloud.google.com/go/datastore"
-	"github.com/bobg/aesite"
+	"github.com/bobg/mid"
 	"github.com/pkg/errors"
-	"golang.org/x/text/message"
 	"google.golang.org/api/iterator"
 
 	"outlived"
 )
 
-const subject = "You have outlived!"
+const (
+	subject       = "You have outlived!"
+	digestSubject = "Your weekly Outlived digest"
+)
 
+// Function handleSend sends mail to the users
+// in whose time zones their preferred hour (User.SendHour) has now arrived.
+// It runs hourly (see cron.yaml).
+// Its first run after a deploy migrates users stored in an older form
+// (see MigrateUsers),
+// since until then those users are invisible to its queries.
+//
+// Admins (see checkMasterKey) may also invoke it with these parameters:
+//   - dryrun: report what would be sent, without sending or recording anything
+//   - resume: retry users already processed today (see sendOptions)
+//   - tz: limit the run to the time zone with this IANA name
+//   - at: run as if at this time (RFC 3339) instead of now
+//
+// The response is a JSON report (see tzReport),
+// with status 500 if sending failed in any time zone.
 func (s *Server) handleSend(w http.ResponseWriter, req *http.Request) error {
 	err := s.checkCron(req)
+	if err != nil {
+		if s.checkMasterKey(req) != nil {
+			return err
+		}
+	}
+
+	var (
+		ctx  = req.Context()
+		now  = time.Now()
+		opts = sendOptions{
+			dryRun: req.FormValue("dryrun") != "",
+			resume: req.FormValue("resume") != "",
+		}
+	)
+	if atStr := req.FormValue("at"); atStr != "" {
+		now, err = time.Parse(time.RFC3339, atStr)
+		if err != nil {
+			return errors.Wrap(mid.CodeErr{C: http.StatusBadRequest, Err: err}, "parsing at parameter")
+		}
+	}
+
+	// This writes only the stored form of users, not what they are sent,
+	// so it happens even in a dry run.
+	err = s.ensureUsersMigrated(ctx)
 	if err != nil {
 		return err
 	}
 
-	ctx := req.Context()
+	tznames := []string{req.FormValue("tz")}
+	if tznames[0] == "" {
+		tznames, err = s.userTZNames(ctx)
+		if err != nil {
+			return err
+		}
+	}
 
 	var (
-		tzname      = req.FormValue("tzname")
-		tzoffsetStr = req.FormValue("tzoffset")
+		reports []*tzReport
+		failed  []string
 	)
+	for _, tzname := range tznames {
+		rep, err := s.sendTZ(ctx, opts, tzname, now.In(loadLocation(tzname)))
+		if err != nil {
+			// Don't let one time zone's problem prevent mail to the others.
+			log.Printf("sending to users in time zone %q: %s", tzname, err)
+			rep.Error = err.Error()
+			failed = append(failed, tzname)
+		}
+		reports = append(reports, rep)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(failed) > 0 {
+		// The report is the response body,
+		// so the failure is logged here rather than returned
+		// (mid.Err does not write or log an error once a status is written).
+		log.Printf("could not send to users in %d time zone(s): %s", len(failed), strings.Join(failed, ", "))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(reports)
+	if err != nil {
+		// The status is already written, so this too can only be logged.
+		log.Printf("writing send report: %s", err)
+	}
+	return nil
+}
+
+// Type sendOptions controls a run of handleSend.
+type sendOptions struct {
+	// dryRun means only report what would be sent,
+	// sending and recording nothing.
+	dryRun bool
+
+	// resume means include users who were already processed today
+	// (normally they are skipped, see claimDailyMail),
+	// for retrying after a failure partway through a run.
+	// Recipients already recorded as sent in a group's sendRecord
+	// are still skipped.
+	resume bool
+}
+
+// Type tzReport describes a run of sendTZ.
+type tzReport struct {
+	TZName string         `json:"tzname"`
+	Local  string         `json:"local"` // RFC 3339
+	Groups []*groupReport `json:"groups"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// Type groupReport describes one message to a group of users (see sendGroup).
+type groupReport struct {
+	ID         string   `json:"id"` // see sendGroupID
+	Subject    string   `json:"subject,omitempty"`
+	Recipients []string `json:"recipients"`
+	Skipped    []string `json:"skipped,omitempty"` // recipients who already got it
+	Figures    []string `json:"figures,omitempty"`
+	Milestones []string `json:"milestones,omitempty"`
+
+	// Status is one of:
+	//   - "sent"
+	//   - "sent-unrecorded": sent, but the recipients could not be recorded,
+	//     so a resumed run (see sendOptions) would send it again
+	//   - "failed"
+	//   - "dryrun": would have been sent
+	//   - "done": every recipient already got it
+	//   - "empty": nothing to send
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Function fail records err in g and returns it.
+func (g *groupReport) fail(err error) error {
+	g.Status = "failed"
+	g.Error = err.Error()
+	return err
+}
 
-	tzoffset, err := strconv.Atoi(tzoffsetStr)
+// Function userTZNames returns the distinct time zone names of all users.
+func (s *Server) userTZNames(ctx context.Context) ([]string, error) {
+	q := datastore.NewQuery("User").Project("TZName").DistinctOn("TZName")
+	var users []*outlived.User
+	_, err := s.dsClient.GetAll(ctx, q, &users)
 	if err != nil {
-		return errors.Wrapf(err, "parsing tzoffset %s", tzoffsetStr)
+		return nil, errors.Wrap(err, "getting user time zones")
+	}
+	result := make([]string, 0, len(users))
+	for _, u := range users {
+		result = append(result, u.TZName)
 	}
+	return result, nil
+}
 
-	loc := time.FixedZone(tzname, tzoffset)
-	now := time.Now().In(loc)
-	today := outlived.Date{Y: now.Year(), M: now.Month(), D: now.Day()}
+// Function sendTZ sends mail to the users in the given time zone
+// whose preferred hour has arrived at the given local time
+// (or passed, if they have not had mail today):
+// a message about any milestones they reach today (see outlived.Milestones),
+// and the regular mail if their preferred frequency calls for it (see regularMailDue).
+// Each user is processed at most once per (local) day (see claimDailyMail),
+// unless opts.resume is set.
+//
+// The failure of one group's message does not prevent the others;
+// it is in the report, and in the error returned after all the groups.
+// The report is returned even with an error.
+func (s *Server) sendTZ(ctx context.Context, opts sendOptions, tzname string, local time.Time) (*tzReport, error) {
+	var (
+		today = outlived.TimeDate(local)
+		rep   = &tzReport{TZName: tzname, Local: local.Format(time.RFC3339)}
+	)
 
-	idemKey := fmt.Sprintf("send-%s-%s", today, tzoffsetStr)
-	err = aesite.Idempotent(ctx, s.dsClient, idemKey)
+	stats, err := s.lifespanStats(ctx)
 	if err != nil {
-		return errors.Wrap(err, "checking idempotency")
+		// Milestones that depend on the stats are skipped, but nothing else.
+		log.Printf("%s", err)
 	}
 
 	q := datastore.NewQuery("User")
 	q = q.Filter("Verified =", true).Filter("Active =", true)
-	q = q.Filter("TZSector =", outlived.TZSector(tzoffset))
-	q = q.Order("Born.Y").Order("Born.M").Order("Born.D")
+	// This includes users whose preferred hour has passed
+	// but who have not had today's mail
+	// (e.g. because a daylight saving time change skipped their hour);
+	// users who have are skipped below.
+	q = q.Filter("TZName =", tzname).Filter("SendHour <=", local.Hour())
+	q = q.Order("SendHour").Order("Born.Y").Order("Born.M").Order("Born.D")
 	it := s.dsClient.Run(ctx, q)
 
 	var (
-		users    []*outlived.User
-		lastBorn outlived.Date
+		users      []*outlived.User
+		milestones = make(map[*outlived.User][]*outlived.Milestone)
+		lastBorn   outlived.Date
+		failed     int
 	)
 
-	p := message.NewPrinter(message.MatchLanguage("en"))
-	numprinter := func(n int) string {
-		return p.Sprintf("%v", n)
-	}
-
-	redir := func(inp string) string {
-		r, _ := rlink(inp)
-		return r.String()
+	addGroup := func(g *groupReport, err error) {
+		rep.Groups = append(rep.Groups, g)
+		if err != nil {
+			log.Printf("sending to group %s: %s", g.ID, err)
+			failed++
+		}
 	}
 
-	wrap := func() error {
+	wrap := func() {
 		if len(users) == 0 {
-			return nil
+			return
 		}
 		defer func() {
 			users = nil
+			milestones = make(map[*outlived.User][]*outlived.Milestone)
 		}()
 
-		born := users[0].Born
-		since := today.Since(born)
-		figures, err := outlived.FiguresAliveFor(ctx, s.dsClient, since-1, 24)
-		if err != nil {
-			return errors.Wrapf(err, "looking up figures alive for %d days", since-1)
-		}
-		if len(figures) == 0 {
-			log.Printf("%d users west of %s born %d days ago, but no figures alive for %d days", len(users), loc, since, since-1)
-			return nil
-		}
-
-		dict := map[string]interface{}{
-			"born":       born,
-			"alivedays":  since,
-			"figures":    figures,
-			"numprinter": numprinter,
-			"redir":      redir,
-		}
-
-		ttmpl, err := ttemplate.New("").Parse(mailTextTemplate)
-		if err != nil {
-			return errors.Wrap(err, "parsing mail text template")
-		}
-		tbuf := new(bytes.Buffer)
-		err = ttmpl.Execute(tbuf, dict)
-		if err != nil {
-			return errors.Wrap(err, "executing mail text template")
-		}
-		textPart := tbuf.String()
-
-		htmpl, err := htemplate.New("").Parse(mailHTMLTemplate)
-		if err != nil {
-			return errors.Wrap(err, "parsing mail HTML template")
+		// Users born on the same day reach mostly the same milestones,
+		// but not all: some depend on when they last got mail.
+		var (
+			milestoneGroups    = make(map[string][]*outlived.User)
+			milestoneGroupKeys []string
+		)
+		for _, u := range users {
+			if len(milestones[u]) == 0 {
+				continue
+			}
+			key := milestoneKeys(milestones[u])
+			if _, ok := milestoneGroups[key]; !ok {
+				milestoneGroupKeys = append(milestoneGroupKeys, key)
+			}
+			milestoneGroups[key] = append(milestoneGroups[key], u)
 		}
-		hbuf := new(bytes.Buffer)
-		err = htmpl.Execute(hbuf, dict)
-		if err != nil {
-			return errors.Wrap(err, "executing mail HTML template")
+		for _, key := range milestoneGroupKeys {
+			group := milestoneGroups[key]
+			addGroup(s.sendMilestoneMail(ctx, opts, tzname, today, milestones[group[0]], group))
 		}
-		htmlPart := hbuf.String()
 
-		var to []string
+		// They also get the same regular message,
+		// unless they have different preferences (see mailGroupKey).
+		var (
+			groups    = make(map[string][]*outlived.User)
+			groupKeys []string
+		)
 		for _, u := range users {
-			to = append(to, u.Email)
+			if !regularMailDue(u, local) {
+				continue
+			}
+			key := mailGroupKey(u)
+			if _, ok := groups[key]; !ok {
+				groupKeys = append(groupKeys, key)
+			}
+			groups[key] = append(groups[key], u)
 		}
-
-		err = s.sender.send(ctx, from, to, subject, strings.NewReader(textPart), strings.NewReader(htmlPart))
-		if err != nil {
-			return errors.Wrap(err, "sending message")
+		for _, key := range groupKeys {
+			addGroup(s.sendMail(ctx, opts, tzname, today, groups[key]))
 		}
-
-		log.Printf("sent message to %d users west of %s born %d days ago about %d figure(s) alive for %d days", len(users), loc, since, len(figures), since-1)
-
-		return nil
 	}
 
 	for {
 		var u outlived.User
-		_, err = it.Next(&u)
+		_, err := it.Next(&u)
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			return errors.Wrap(err, "iterating over users")
+			return rep, errors.Wrap(err, "iterating over users")
+		}
+		if u.LastSent == today && !opts.resume {
+			continue
+		}
+		// This must precede claimDailyMail, which changes u.LastSent.
+		ms := outlived.Milestones(u.Born, today, u.LastSent, stats)
+		if !regularMailDue(&u, local) && len(ms) == 0 {
+			continue
 		}
 		if u.Born != lastBorn {
-			err = wrap()
+			wrap()
+		}
+		if !opts.dryRun {
+			ok, err := s.claimDailyMail(ctx, &u, today)
 			if err != nil {
-				return err
+				return rep, err
+			}
+			if !ok && !opts.resume {
+				continue
 			}
 		}
 		users = append(users, &u)
+		milestones[&u] = ms
 		lastBorn = u.Born
 	}
-	return wrap()
+	wrap()
+
+	if failed > 0 {
+		return rep, fmt.Errorf("%d group(s) failed", failed)
+	}
+	return rep, nil
+}
+
+// Function regularMailDue tells whether u's preferred frequency
+// calls for the regular mail at the given local time.
+func regularMailDue(u *outlived.User, local time.Time) bool {
+	switch u.Frequency {
+	case outlived.FrequencyMilestones:
+		return false
+	case outlived.FrequencyWeekly:
+		return local.Weekday() == outlived.DigestWeekday
+	}
+	return true
+}
+
+// Function claimDailyMail records that u is getting mail for the given (local) day,
+// setting u.LastSent.
+// It returns false if u already got it.
+// The record is made before sending,
+// so a failure can cause a missed message but never a duplicate.
+// Missed messages can be sent with handleSend's resume option.
+func (s *Server) claimDailyMail(ctx context.Context, u *outlived.User, today outlived.Date) (bool, error) {
+	var ok bool
+	_, err := s.dsClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var cur outlived.User
+		err := tx.Get(u.Key(), &cur)
+		if err != nil {
+			return errors.Wrapf(err, "getting user %s", u.Email)
+		}
+		if cur.LastSent == today {
+			ok = false
+			return nil
+		}
+		cur.LastSent = today
+		_, err = tx.Put(u.Key(), &cur)
+		if err != nil {
+			return errors.Wrapf(err, "updating user %s", u.Email)
+		}
+		u.LastSent = today
+		ok = true
+		return nil
+	})
+	return ok, errors.Wrapf(err, "claiming daily mail for %s on %s", u.Email, today)
+}
+
+// Function mailGroupKey gives a key that is the same for users born on the same day
+// exactly when they should get the same message
+// (apart from its placeholders).
+func mailGroupKey(u *outlived.User) string {
+	return fmt.Sprintf("%s %d %s", u.Frequency, u.FiguresPerMail, strings.Join(u.Categories, ","))
 }
 
-const mailTextTemplate = `
-This is an update from Outlived! <https://outlived.net>
+// Function sendMail sends the mail for the given day
+// to users in the given time zone who were all born on the same day
+// and have the same mail preferences (see mailGroupKey).
+// The message is the same for all of them
+// except for the placeholders in it (see dailyMailVars).
+func (s *Server) sendMail(ctx context.Context, opts sendOptions, tzname string, today outlived.Date, users []*outlived.User) (*groupReport, error) {
+	var (
+		u0    = users[0]
+		since = today.Since(u0.Born)
+		g     = &groupReport{ID: sendGroupID(today, tzname, "regular", u0.Born, mailGroupKey(u0))}
+	)
+
+	subj, textBody, htmlBody, figures, err := s.composeMail(ctx, u0, today)
+	if err != nil {
+		return g, g.fail(err)
+	}
+	if len(figures) == 0 {
+		log.Printf("%d users born %d days ago, but no figures to mail about", len(users), since)
+		for _, u := range users {
+			g.Recipients = append(g.Recipients, u.Email)
+		}
+		g.Status = "empty"
+		return g, nil
+	}
+
+	g.Subject = subj
+	for _, f := range figures {
+		g.Figures = append(g.Figures, f.Name)
+	}
+
+	err = s.sendGroup(ctx, opts, today, g, users, nil, subj, textBody, htmlBody)
+	if err != nil {
+		return g, err
+	}
+	if g.Status == "sent" {
+		log.Printf("sent message to %d users born %d days ago about %d figure(s)", len(users)-len(g.Skipped), since, len(figures))
+	}
+
+	return g, nil
+}
 
-You were born on {{ .born }}, which was {{ call .numprinter .alivedays }} days ago.
+// Function composeMail produces the subject and bodies of u's regular mail for the given day,
+// with placeholders for the variables in dailyMailVars,
+// and the figures in it.
+// If there are no figures there is nothing to send, and no bodies.
+func (s *Server) composeMail(ctx context.Context, u *outlived.User, today outlived.Date) (subj string, textBody, htmlBody []byte, figures []*outlived.Figure, err error) {
+	figures, err = s.figuresForMail(ctx, u, today)
+	if err != nil || len(figures) == 0 {
+		return "", nil, nil, nil, err
+	}
 
-You have now outlived:
+	digest := u.Frequency == outlived.FrequencyWeekly
+	textBody, htmlBody, err = s.renderDailyMail(u.Born, figures, digest)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
 
-{{ $redir := .redir }}
-{{ range .figures }}
-- {{ .Name }}, {{ if .Desc }}{{ .Desc }}, {{ end }}{{ .Born }}—{{ .Died }}. {{ call $redir .Link }}
-{{ end }}
+	subj = subject
+	if digest {
+		subj = digestSubject
+	}
+	return subj, textBody, htmlBody, figures, nil
+}
 
-Data supplied by Wikipedia, the free encyclopedia. <https://en.wikipedia.org/>
+// Function figuresForMail returns the figures for u's mail on the given day,
+// according to u's preferences.
+func (s *Server) figuresForMail(ctx context.Context, u *outlived.User, today outlived.Date) ([]*outlived.Figure, error) {
+	if u.Frequency != outlived.FrequencyWeekly {
+		return s.figuresOutlivedOn(ctx, u.Born, today, u.Categories, u.FiguresPerMail)
+	}
 
-To stop receiving these updates, visit https://outlived.net/unsubscribe.
-`
+	// The weekly digest combines the past seven days' figures,
+	// most popular first.
+	var result []*outlived.Figure
+	for i := 0; i < 7; i++ {
+		figures, err := s.figuresOutlivedOn(ctx, u.Born, today.AddDays(-i), u.Categories, u.FiguresPerMail)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, figures...)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Pageviews > result[j].Pageviews
+	})
+	if len(result) > u.FiguresPerMail {
+		result = result[:u.FiguresPerMail]
+	}
+	return result, nil
+}
 
-const mailHTMLTemplate = `
-<p>This is an update from <a href="https://outlived.net/">Outlived</a>!</p>
+// Function figuresOutlivedOn returns the figures that someone born on the given date
+// outlives on the given day,
+// up to the given limit.
+// If categories is non-empty,
+// only figures in those categories (see inCategories) are included.
+func (s *Server) figuresOutlivedOn(ctx context.Context, born, today outlived.Date, categories []string, limit int) ([]*outlived.Figure, error) {
+	since := today.Since(born)
+	if len(categories) == 0 {
+		figures, err := s.figures.FiguresAliveFor(ctx, since-1, limit)
+		return figures, errors.Wrapf(err, "looking up figures alive for %d days", since-1)
+	}
 
-<p>You were born on {{ .born }}, which was {{ call .numprinter .alivedays }} days ago.</p>
+	figures, err := s.figures.FiguresAliveFor(ctx, since-1, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "looking up figures alive for %d days", since-1)
+	}
+	var result []*outlived.Figure
+	for _, f := range figures {
+		if inCategories(f, categories) {
+			result = append(result, f)
+			if len(result) == limit {
+				break
+			}
+		}
+	}
+	return result, nil
+}
 
-<p>You have now outlived:</p>
+// Function inCategories tells whether f's description
+// mentions any of the given categories,
+// which are lowercase (see normalizeCategories).
+func inCategories(f *outlived.Figure, categories []string) bool {
+	desc := strings.ToLower(f.Desc)
+	for _, cat := range categories {
+		if strings.Contains(desc, cat) {
+			return true
+		}
+	}
+	return false
+}
 
-<div style="text-align: center;">
-  {{ $redir := .redir }}
-  {{ range .figures }}
-    <div style="display: inline-block; vertical-align: top; margin: 1em 2em; width: 16em;">
-      <a href="{{ call $redir .Link }}" style="font-weight: bold;" target="_blank" rel="noopener noreferrer">
-        {{ if .ImgSrc }}
-          <img style="max-width: 64px; height: auto;" src="{{ call $redir .ImgSrc }}" alt="{{ .ImgAlt }}"><br>
-        {{ end }}
-        {{ .Name }}<br>
-      </a>
-      {{ if .Desc }}
-        {{ .Desc }}<br>
-      {{ end }}
-      {{ .Born }}&mdash;{{ .Died }}
-    </div>
-  {{ end }}
-</div>
+// Function dailyMailVars produces the values
+// for the placeholders in the daily mail to u on the given day:
+//   - alivedays: u's age in days
+//   - unsubscribe: a link that unsubscribes u without logging in (see handleUnsubscribe)
+//   - browser: a link to view the message in a web browser
+func dailyMailVars(u *outlived.User, today outlived.Date) (map[string]string, error) {
+	unsubscribe, err := expiringTokenURL(u, "/s/unsubscribe", unsubscribeTokenPurpose, time.Now().Add(unsubscribeLinkLifetime))
+	if err != nil {
+		return nil, err
+	}
+	browser, err := tokenURL(u, "/s/mail", mailTokenPurpose)
+	if err != nil {
+		return nil, err
+	}
+	q := browser.Query()
+	q.Set("d", today.YYYYMMDD())
+	browser.RawQuery = q.Encode()
+
+	return map[string]string{
+		"alivedays":   outlived.FormatNum(today.Since(u.Born)),
+		"unsubscribe": unsubscribe.String(),
+		"browser":     browser.String(),
+	}, nil
+}
 
-<p>Data supplied by <a href="https://en.wikipedia.org/">Wikipedia</a>, the free encyclopedia.</p>
+// Function renderDailyMail produces the bodies of the mail
+// telling someone born on the given date
+// which figures they now outlive,
+// or (for a weekly digest) outlived in the past week.
+// The bodies contain placeholders for the variables in dailyMailVars.
+func (s *Server) renderDailyMail(born outlived.Date, figures []*outlived.Figure, digest bool) (textBody, htmlBody []byte, err error) {
+	// The HTML template gets whole href attributes for the links,
+	// since it would escape the % signs of placeholders in URLs.
+	dict := map[string]interface{}{
+		"born":            born,
+		"alivedays":       recipientVar("alivedays"),
+		"unsubscribe":     recipientVar("unsubscribe"),
+		"unsubscribeHref": htemplate.HTMLAttr(`href="` + recipientVar("unsubscribe") + `"`),
+		"browser":         recipientVar("browser"),
+		"browserHref":     htemplate.HTMLAttr(`href="` + recipientVar("browser") + `"`),
+		"figures":         figures,
+		"digest":          digest,
+	}
 
-<p style="font-size: smaller;">To stop receiving these updates, visit <a href="https://outlived.net/unsubscribe">Outlived</a>.</p>
-`
+	textBody, htmlBody, err = s.tmpl.renderMail("mail/daily", dict)
+	return textBody, htmlBody, errors.Wrap(err, "rendering daily mail")
+}
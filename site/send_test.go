@@ -0,0 +1,292 @@
+package site
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bobg/mid"
+	"github.com/pkg/errors"
+
+	"outlived"
+	"outlived/memds"
+)
+
+func TestFiguresForMail(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := outlived.OpenFileFigureStore(filepath.Join(t.TempDir(), "figures.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	err = store.ReplaceFigures(ctx, []*outlived.Figure{
+		{Link: "a", Desc: "English poet", DaysAlive: 999, Pageviews: 10, Updated: now},
+		{Link: "b", Desc: "Composer", DaysAlive: 999, Pageviews: 30, Updated: now},
+		{Link: "c", Desc: "Painter", DaysAlive: 998, Pageviews: 50, Updated: now},
+		{Link: "d", Desc: "Poet and painter", DaysAlive: 993, Pageviews: 20, Updated: now},
+		{Link: "e", Desc: "Composer", DaysAlive: 992, Pageviews: 90, Updated: now}, // more than a week ago
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{figures: store}
+
+	var (
+		born  = outlived.Date{Y: 2000, M: time.January, D: 1}
+		today = born.AddDays(1000)
+	)
+
+	cases := []struct {
+		name string
+		u    outlived.User
+		want []string
+	}{
+		{
+			name: "daily",
+			u:    outlived.User{Born: born, Frequency: outlived.FrequencyDaily, FiguresPerMail: 24},
+			want: []string{"b", "a"},
+		},
+		{
+			name: "daily_limit",
+			u:    outlived.User{Born: born, Frequency: outlived.FrequencyDaily, FiguresPerMail: 1},
+			want: []string{"b"},
+		},
+		{
+			name: "daily_categories",
+			u:    outlived.User{Born: born, Frequency: outlived.FrequencyDaily, FiguresPerMail: 24, Categories: []string{"poet"}},
+			want: []string{"a"},
+		},
+		{
+			name: "weekly",
+			u:    outlived.User{Born: born, Frequency: outlived.FrequencyWeekly, FiguresPerMail: 24},
+			want: []string{"c", "b", "d", "a"},
+		},
+		{
+			name: "weekly_limit",
+			u:    outlived.User{Born: born, Frequency: outlived.FrequencyWeekly, FiguresPerMail: 2},
+			want: []string{"c", "b"},
+		},
+		{
+			name: "weekly_categories",
+			u:    outlived.User{Born: born, Frequency: outlived.FrequencyWeekly, FiguresPerMail: 24, Categories: []string{"painter"}},
+			want: []string{"c", "d"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			figures, err := s.figuresForMail(ctx, &c.u, today)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range figures {
+				got = append(got, f.Link)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSendTZDSTGap(t *testing.T) {
+	ctx := context.Background()
+
+	dsClient, err := memds.NewClient(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dsClient.Close()
+
+	store, err := outlived.OpenFileFigureStore(filepath.Join(t.TempDir(), "figures.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{dsClient: dsClient, figures: store}
+
+	const tzname = "America/New_York"
+
+	// On this day clocks in New York skip from 2:00 to 3:00,
+	// so the 3:00 run is the first chance to mail users whose hour is 2.
+	var (
+		local = time.Date(2024, time.March, 10, 3, 0, 0, 0, loadLocation(tzname))
+		today = outlived.TimeDate(local)
+		born  = outlived.Date{Y: 2000, M: time.January, D: 1}
+	)
+
+	users := []*outlived.User{
+		{Born: born, SendHour: 2},
+		{Born: born, SendHour: 3},
+		{Born: born, SendHour: 4},
+		{Born: born, SendHour: 1, LastSent: today},
+		{Born: outlived.Date{Y: 1990, M: time.June, D: 1}, SendHour: 0},
+	}
+	for i, u := range users {
+		u.Email = fmt.Sprintf("user%d@example.com", i)
+		u.Verified = true
+		u.Active = true
+		u.TZName = tzname
+		u.Frequency = outlived.FrequencyDaily
+		u.FiguresPerMail = outlived.DefaultFiguresPerMail
+		if _, err := dsClient.Put(ctx, u.Key(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rep, err := s.sendTZ(ctx, sendOptions{dryRun: true}, tzname, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, g := range rep.Groups {
+		got = append(got, g.Recipients...)
+	}
+	sort.Strings(got)
+	if want := []string{"user0@example.com", "user1@example.com", "user4@example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got recipients %v, want %v", got, want)
+	}
+}
+
+func TestSendTZMovingMedian(t *testing.T) {
+	ctx := context.Background()
+
+	dsClient, err := memds.NewClient(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dsClient.Close()
+
+	const tzname = "UTC"
+
+	var (
+		born  = outlived.Date{Y: 2000, M: time.January, D: 1}
+		today = born.AddDays(401) // 400 days outlived
+		local = time.Date(today.Y, today.M, today.D, outlived.DefaultSendHour, 0, 0, 0, time.UTC)
+	)
+
+	s := &Server{
+		dsClient: dsClient,
+		tmpl:     testTemplates(t),
+
+		// The median was more than 395 days at earlier sends,
+		// but has since moved to 398.
+		stats:     &outlived.LifespanStats{Count: 3, Mean: 1000, Median: 398},
+		statsTime: time.Now(),
+	}
+
+	users := []*outlived.User{
+		{LastSent: today.AddDays(-1)}, // had already outlived 398 days by then
+		{LastSent: today.AddDays(-5)},
+	}
+	for i, u := range users {
+		u.Email = fmt.Sprintf("user%d@example.com", i)
+		u.Verified = true
+		u.Active = true
+		u.Born = born
+		u.TZName = tzname
+		u.SendHour = outlived.DefaultSendHour
+		u.Frequency = outlived.FrequencyMilestones
+		if _, err := dsClient.Put(ctx, u.Key(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rep, err := s.sendTZ(ctx, sendOptions{dryRun: true}, tzname, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Groups) != 1 {
+		t.Fatalf("got %d group(s), want 1", len(rep.Groups))
+	}
+	g := rep.Groups[0]
+	if want := []string{"median-398"}; !reflect.DeepEqual(g.Milestones, want) {
+		t.Errorf("got milestones %v, want %v", g.Milestones, want)
+	}
+	if want := []string{"user1@example.com"}; !reflect.DeepEqual(g.Recipients, want) {
+		t.Errorf("got recipients %v, want %v", g.Recipients, want)
+	}
+}
+
+// Type failingSender is a sender that always fails.
+type failingSender struct{}
+
+func (failingSender) send(ctx context.Context, from string, to []recipient, subject string, textR io.Reader, htmlR io.Reader) error {
+	return errors.New("cannot send")
+}
+
+func TestHandleSendFailure(t *testing.T) {
+	ctx := context.Background()
+
+	dsClient, err := memds.NewClient(ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dsClient.Close()
+
+	var (
+		now   = time.Date(2024, time.June, 1, outlived.DefaultSendHour, 0, 0, 0, time.UTC)
+		today = outlived.TimeDate(now)
+		born  = today.AddDays(-1000)
+	)
+
+	store, err := outlived.OpenFileFigureStore(filepath.Join(t.TempDir(), "figures.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.ReplaceFigures(ctx, []*outlived.Figure{{Link: "a", Name: "A", DaysAlive: 999, Pageviews: 10, Updated: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &outlived.User{
+		Born:           born,
+		Active:         true,
+		TZName:         "UTC",
+		SendHour:       outlived.DefaultSendHour,
+		Frequency:      outlived.FrequencyDaily,
+		FiguresPerMail: outlived.DefaultFiguresPerMail,
+	}
+	u.Email = "user@example.com"
+	u.Verified = true
+	if _, err := dsClient.Put(ctx, u.Key(), u); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{dsClient: dsClient, figures: store, sender: failingSender{}, tmpl: testTemplates(t)}
+
+	req := httptest.NewRequest("POST", "/t/send?at="+now.Format(time.RFC3339), nil)
+	rec := httptest.NewRecorder()
+	mid.Err(s.handleSend).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	// The body is the report and nothing else.
+	var reports []*tzReport
+	dec := json.NewDecoder(rec.Body)
+	if err := dec.Decode(&reports); err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := io.ReadAll(dec.Buffered()); len(bytes.TrimSpace(rest)) > 0 || rec.Body.Len() > 0 {
+		t.Errorf("got extra output after the report: %q", string(rest)+rec.Body.String())
+	}
+	if len(reports) != 1 || reports[0].Error == "" {
+		t.Fatalf("got %d report(s), want one failed time zone", len(reports))
+	}
+	for _, g := range reports[0].Groups {
+		if g.Status != "failed" {
+			t.Errorf("got status %q for group %s, want failed", g.Status, g.ID)
+		}
+	}
+}
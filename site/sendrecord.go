@@ -0,0 +1,150 @@
+package site
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/bobg/aesite"
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+// Type sendRecord is the datastore record of a group's message (see sendGroup):
+// who has been sent it so far.
+// Its key is the group's ID (see sendGroupID).
+type sendRecord struct {
+	Created time.Time
+	Sent    []string `datastore:",noindex"`
+}
+
+// How long sendRecords are kept (see expireSendRecords).
+const sendRecordLifetime = 7 * 24 * time.Hour
+
+// Function sendGroupID identifies the message of the given kind ("regular" or "milestone")
+// sent on the given day to users in the given time zone born on the given date,
+// with the given key distinguishing groups of users with different messages
+// (see mailGroupKey).
+func sendGroupID(today outlived.Date, tzname, kind string, born outlived.Date, key string) string {
+	return fmt.Sprintf("%s %s %s %s %s", today.YYYYMMDD(), tzname, kind, born.YYYYMMDD(), key)
+}
+
+// Function sendGroup sends a message to a group of users,
+// skipping those recorded as already sent it
+// (and, if idemKey is not nil, those for whom aesite.Idempotent fails,
+// except in resume mode),
+// and records the ones it's sent to.
+// The outcome is in g.
+// If the message is sent but its recipients can't be recorded,
+// the status is "sent-unrecorded" and the result is an error,
+// since resuming (see sendOptions) would send the message again.
+// In dry-run mode it only fills in g.
+func (s *Server) sendGroup(ctx context.Context, opts sendOptions, today outlived.Date, g *groupReport, users []*outlived.User, idemKey func(*outlived.User) string, subj string, textBody, htmlBody []byte) error {
+	key := datastore.NameKey("SendRecord", g.ID, nil)
+
+	var rec sendRecord
+	err := s.dsClient.Get(ctx, key, &rec)
+	if err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+		return g.fail(errors.Wrapf(err, "getting send record %s", g.ID))
+	}
+	sent := make(map[string]bool)
+	for _, addr := range rec.Sent {
+		sent[addr] = true
+	}
+
+	var (
+		to    []recipient
+		addrs []string
+	)
+	for _, u := range users {
+		g.Recipients = append(g.Recipients, u.Email)
+		if sent[u.Email] {
+			g.Skipped = append(g.Skipped, u.Email)
+			continue
+		}
+		if idemKey != nil && !opts.resume && !opts.dryRun {
+			err := aesite.Idempotent(ctx, s.dsClient, idemKey(u))
+			if errors.Is(err, aesite.ErrIdempotency) {
+				g.Skipped = append(g.Skipped, u.Email)
+				continue
+			}
+			if err != nil {
+				return g.fail(errors.Wrapf(err, "checking idempotency for %s", u.Email))
+			}
+		}
+		vars, err := dailyMailVars(u, today)
+		if err != nil {
+			return g.fail(errors.Wrapf(err, "computing mail variables for %s", u.Email))
+		}
+		to = append(to, recipient{addr: u.Email, vars: vars})
+		addrs = append(addrs, u.Email)
+	}
+
+	switch {
+	case len(to) == 0:
+		g.Status = "done"
+		return nil
+	case opts.dryRun:
+		g.Status = "dryrun"
+		return nil
+	}
+
+	err = s.sender.send(ctx, from, to, subj, bytes.NewReader(textBody), bytes.NewReader(htmlBody))
+	if err != nil {
+		return g.fail(errors.Wrap(err, "sending message"))
+	}
+	g.Status = "sent"
+
+	_, err = s.dsClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var rec sendRecord
+		err := tx.Get(key, &rec)
+		if errors.Is(err, datastore.ErrNoSuchEntity) {
+			rec.Created = time.Now()
+		} else if err != nil {
+			return err
+		}
+		rec.Sent = append(rec.Sent, addrs...)
+		_, err = tx.Put(key, &rec)
+		return err
+	})
+	if err != nil {
+		// The message was sent,
+		// but a resumed run would send it again.
+		g.Status = "sent-unrecorded"
+		err = errors.Wrapf(err, "recording recipients of sent message (resuming would send it again)")
+		g.Error = err.Error()
+		return err
+	}
+	return nil
+}
+
+// Function expireSendRecords deletes sendRecords older than sendRecordLifetime.
+// It returns the number deleted.
+func (s *Server) expireSendRecords(ctx context.Context) (int, error) {
+	q := datastore.NewQuery("SendRecord")
+	q = q.Filter("Created <", time.Now().Add(-sendRecordLifetime)).KeysOnly()
+	keys, err := s.dsClient.GetAll(ctx, q, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting expired send records")
+	}
+
+	count := 0
+
+	for len(keys) > 0 {
+		var nextKeys []*datastore.Key
+
+		if len(keys) > outlived.MultiLimit {
+			keys, nextKeys = keys[:outlived.MultiLimit], keys[outlived.MultiLimit:]
+		}
+		err = s.dsClient.DeleteMulti(ctx, keys)
+		if err != nil {
+			return count, errors.Wrap(err, "expiring send records")
+		}
+		count += len(keys)
+		keys = nextKeys
+	}
+	return count, nil
+}
@@ -2,11 +2,14 @@ package site
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	"cloud.google.com/go/datastore"
@@ -14,9 +17,15 @@ import (
 	"github.com/bobg/mid"
 	"github.com/pkg/errors"
 	"google.golang.org/appengine"
+
+	"outlived"
 )
 
-func NewServer(ctx context.Context, contentDir, projectID, locationID string, dsClient *datastore.Client, ctClient *cloudtasks.Client) (*Server, error) {
+// NewServer creates a new Server.
+// Static content is served from contentDir (in test mode).
+// Built-in templates may be overridden by files in templateDir,
+// if it is not empty (see loadTemplates).
+func NewServer(ctx context.Context, contentDir, templateDir, projectID, locationID string, dsClient *datastore.Client, ctClient *cloudtasks.Client, figures outlived.FigureStore) (*Server, error) {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -29,11 +38,67 @@ func NewServer(ctx context.Context, contentDir, projectID, locationID string, ds
 		projectID:  projectID,
 		locationID: locationID,
 		dsClient:   dsClient,
+		figures:    figures,
 	}
 
 	if appengine.IsAppEngine() {
 		s.tasks = (*gCloudTasks)(ctClient)
+	} else {
+		s.tasks = newLocalTasks(addr)
+	}
+
+	s.registerTasks()
+
+	var err error
+	s.tmpl, err = loadTemplates(ctx, dsClient, templateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	s.sender, err = newSender(ctx, dsClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// NewAdminServer creates a Server for admin commands,
+// which read and write the datastore
+// but send no mail and enqueue no tasks.
+// It has no mail sender or task service
+// and must not be used to Serve
+// or to call methods that send mail or enqueue tasks.
+// Built-in templates may be overridden by files in templateDir,
+// if it is not empty (see loadTemplates).
+func NewAdminServer(ctx context.Context, templateDir string, dsClient *datastore.Client, figures outlived.FigureStore) (*Server, error) {
+	s := &Server{
+		dsClient: dsClient,
+		figures:  figures,
+	}
+	var err error
+	s.tmpl, err = loadTemplates(ctx, dsClient, templateDir)
+	return s, err
+}
+
+// Function newSender creates the sender named by the mail_transport setting:
+// "mailgun", "smtp" (see newSMTPSender), or "log" (which only logs messages).
+// The default is mailgun on App Engine and log elsewhere.
+func newSender(ctx context.Context, dsClient *datastore.Client) (sender, error) {
+	transport, err := optionalSetting(ctx, dsClient, "mail_transport")
+	if err != nil {
+		return nil, err
+	}
+	if transport == "" {
+		if appengine.IsAppEngine() {
+			transport = "mailgun"
+		} else {
+			transport = "log"
+		}
+	}
 
+	switch transport {
+	case "mailgun":
 		domain, err := aesite.GetSetting(ctx, dsClient, "mailgun_domain")
 		if err != nil {
 			return nil, errors.Wrap(err, "getting setting for mailgun_domain")
@@ -42,13 +107,16 @@ func NewServer(ctx context.Context, contentDir, projectID, locationID string, ds
 		if err != nil {
 			return nil, errors.Wrap(err, "getting setting for mailgun_api_key")
 		}
-		s.sender = newMailgunSender(string(domain), string(apiKey))
-	} else {
-		s.tasks = newLocalTasks(ctx, addr)
-		s.sender = new(testSender)
+		return newMailgunSender(string(domain), string(apiKey)), nil
+
+	case "smtp":
+		return newSMTPSender(ctx, dsClient)
+
+	case "log":
+		return new(testSender), nil
 	}
 
-	return s, nil
+	return nil, fmt.Errorf("unknown mail_transport %s", transport)
 }
 
 type Server struct {
@@ -57,8 +125,17 @@ type Server struct {
 	projectID  string
 	locationID string
 	dsClient   *datastore.Client
+	figures    outlived.FigureStore
 	tasks      taskService
 	sender     sender
+	tmpl       *templates
+
+	taskHandlers taskRegistry
+
+	// Cached result of lifespanStats.
+	statsMu   sync.Mutex
+	stats     *outlived.LifespanStats
+	statsTime time.Time
 }
 
 func (s *Server) Serve(ctx context.Context) {
@@ -67,16 +144,28 @@ func (s *Server) Serve(ctx context.Context) {
 	// This is for testing. In production, / is routed by app.yaml.
 	mux.Handle("/", mid.Err(s.handleStatic))
 
+	mux.Handle("/s/calendar.ics", mid.Err(s.handleCalendar))
+	mux.Handle("/s/died.atom", mid.Err(s.handleDiedFeed))
+	mux.Handle("/s/outlived.atom", mid.Err(s.handleOutlivedFeed))
 	mux.Handle("/s/data", s.sessHandler(mid.JSON(s.handleData)))
 	mux.Handle("/s/forgot", mid.Err(s.handleForgot))
 	mux.Handle("/s/load", mid.Err(s.handleLoad))
 	mux.Handle("/s/login", mid.JSON(s.handleLogin))
 	mux.Handle("/s/logout", mid.Err(s.handleLogout))
+	mux.Handle("/s/mail", mid.Err(s.handleViewMail))
+	mux.Handle("/s/mailevent", mid.Err(s.handleMailEvent))
+	mux.Handle("/s/mailevent/mailgun", mid.Err(s.handleMailgunEvent))
+	mux.Handle("/s/previewmail", mid.Err(s.handlePreviewMail))
 	mux.Handle("/s/resetpw", mid.Err(s.handleResetPW))
 	mux.Handle("/s/reverify", s.sessHandler(mid.JSON(s.handleReverify)))
+	mux.Handle("/s/scraperuns", mid.Err(s.handleScrapeRuns))
 	mux.Handle("/s/setactive", s.sessHandler(mid.JSON(s.handleSetActive)))
 	mux.Handle("/s/setbirthdate", s.sessHandler(mid.JSON(s.handleSetBirthdate)))
+	mux.Handle("/s/setcategories", s.sessHandler(mid.JSON(s.handleSetCategories)))
+	mux.Handle("/s/setprefs", s.sessHandler(mid.JSON(s.handleSetPrefs)))
 	mux.Handle("/s/signup", mid.JSON(s.handleSignup))
+	mux.Handle("/s/unsubscribe", mid.Err(s.handleUnsubscribe))
+	mux.Handle("/s/upcoming", s.sessHandler(mid.JSON(s.handleUpcoming)))
 	mux.Handle("/s/verify", mid.Err(s.handleVerify))
 
 	mux.Handle("/unsubscribe", http.RedirectHandler("/", http.StatusMovedPermanently))
@@ -89,8 +178,13 @@ func (s *Server) Serve(ctx context.Context) {
 	mux.Handle("/t/send", mid.Err(s.handleSend))
 
 	// task-queue-initiated
-	mux.Handle("/t/scrapeday", mid.Err(s.handleScrapeday))
-	mux.Handle("/t/scrapeperson", mid.Err(s.handleScrapeperson))
+	for kind, handler := range s.taskHandlers {
+		mux.Handle("/t/"+kind, mid.Err(handler))
+	}
+
+	if lt, ok := s.tasks.(*localTasks); ok {
+		lt.start(ctx)
+	}
 
 	log.Printf("listening for requests on %s", s.addr)
 
@@ -133,6 +227,19 @@ func (w *respWriter) WriteHeader(code int) {
 	w.w.WriteHeader(code)
 }
 
+// Function checkMasterKey authorizes admin requests,
+// which must carry the master-key setting in the X-Outlived-Key header.
+func (s *Server) checkMasterKey(req *http.Request) error {
+	masterKey, err := aesite.GetSetting(req.Context(), s.dsClient, "master-key")
+	if err != nil {
+		return errors.Wrap(err, "getting master key")
+	}
+	if strings.TrimSpace(req.Header.Get("X-Outlived-Key")) != string(masterKey) {
+		return mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	return nil
+}
+
 // See
 // https://cloud.google.com/appengine/docs/standard/go112/scheduling-jobs-with-cron-yaml#validating_cron_requests.
 func (s *Server) checkCron(req *http.Request) error {
@@ -2,6 +2,7 @@ package site
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 
 	"github.com/bobg/mid"
@@ -30,9 +31,9 @@ func (s *Server) handleSetBirthdate(
 		now   = tzNow(req.TZName)
 		today = outlived.TimeDate(now)
 	)
-	born, err := outlived.ParseDate(req.NewDate)
+	born, err := parseBirthdate(req.NewDate)
 	if err != nil {
-		return nil, errors.Wrap(mid.CodeErr{C: http.StatusBadRequest, Err: err}, "parsing birthdate")
+		return nil, err
 	}
 	var u outlived.User
 	err = sess.GetUser(ctx, s.dsClient, &u)
@@ -47,3 +48,16 @@ func (s *Server) handleSetBirthdate(
 	_, d, err := s.getUserData2(ctx, sess, &u, today)
 	return d, errors.Wrap(err, "getting updated user data")
 }
+
+// Function parseBirthdate parses a user's birthdate.
+// Unlike figures, users may not be born BC.
+func parseBirthdate(s string) (outlived.Date, error) {
+	born, err := outlived.ParseDate(s)
+	if err != nil {
+		return outlived.Date{}, errors.Wrap(mid.CodeErr{C: http.StatusBadRequest, Err: err}, "parsing birthdate")
+	}
+	if born.Y < 1 {
+		return outlived.Date{}, mid.CodeErr{C: http.StatusBadRequest, Err: fmt.Errorf("birthdate %s is BC", born)}
+	}
+	return born, nil
+}
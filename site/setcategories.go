@@ -0,0 +1,59 @@
+package site
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/bobg/mid"
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+func (s *Server) handleSetCategories(
+	ctx context.Context,
+	req struct {
+		CSRF       string
+		Categories []string
+	},
+) error {
+	sess := getSess(ctx)
+	if sess == nil {
+		return mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	err := sess.CSRFCheck(req.CSRF)
+	if err != nil {
+		return errors.Wrap(err, "checking CSRF token")
+	}
+	var u outlived.User
+	err = sess.GetUser(ctx, s.dsClient, &u)
+	if err != nil {
+		return errors.Wrapf(err, "getting user for session %d", sess.ID)
+	}
+	u.Categories = normalizeCategories(req.Categories)
+	_, err = s.dsClient.Put(ctx, u.Key(), &u)
+	return errors.Wrapf(err, "updating user %s", u.Email)
+}
+
+// Function normalizeCategories lowercases and sorts categories,
+// removing blanks and duplicates,
+// so that users choosing the same categories
+// can get the same daily mail.
+func normalizeCategories(categories []string) []string {
+	var (
+		result []string
+		seen   = make(map[string]bool)
+	)
+	for _, cat := range categories {
+		cat = strings.ToLower(strings.TrimSpace(cat))
+		if cat == "" || seen[cat] {
+			continue
+		}
+		seen[cat] = true
+		result = append(result, cat)
+	}
+	sort.Strings(result)
+	return result
+}
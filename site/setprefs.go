@@ -0,0 +1,56 @@
+package site
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/bobg/mid"
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+// The most figures a user may ask for in a message.
+const maxFiguresPerMail = 100
+
+func (s *Server) handleSetPrefs(
+	ctx context.Context,
+	req struct {
+		CSRF           string
+		SendHour       int
+		Frequency      string
+		FiguresPerMail int
+	},
+) error {
+	sess := getSess(ctx)
+	if sess == nil {
+		return mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	err := sess.CSRFCheck(req.CSRF)
+	if err != nil {
+		return errors.Wrap(err, "checking CSRF token")
+	}
+
+	if req.SendHour < 0 || req.SendHour > 23 {
+		return mid.CodeErr{C: http.StatusBadRequest, Err: fmt.Errorf("send hour %d out of range", req.SendHour)}
+	}
+	freq, err := outlived.ParseFrequency(req.Frequency)
+	if err != nil {
+		return mid.CodeErr{C: http.StatusBadRequest, Err: err}
+	}
+	if req.FiguresPerMail < 1 || req.FiguresPerMail > maxFiguresPerMail {
+		return mid.CodeErr{C: http.StatusBadRequest, Err: fmt.Errorf("figures per mail %d out of range", req.FiguresPerMail)}
+	}
+
+	var u outlived.User
+	err = sess.GetUser(ctx, s.dsClient, &u)
+	if err != nil {
+		return errors.Wrapf(err, "getting user for session %d", sess.ID)
+	}
+	u.SendHour = req.SendHour
+	u.Frequency = freq
+	u.FiguresPerMail = req.FiguresPerMail
+	_, err = s.dsClient.Put(ctx, u.Key(), &u)
+	return errors.Wrapf(err, "updating user %s", u.Email)
+}
@@ -3,7 +3,6 @@ package site
 import (
 	"context"
 	"log"
-	"net/http"
 
 	"github.com/bobg/aesite"
 	"github.com/bobg/mid"
@@ -21,9 +20,9 @@ func (s *Server) handleSignup(
 		TZName   string
 	},
 ) (*userData, error) {
-	born, err := outlived.ParseDate(req.Born)
+	born, err := parseBirthdate(req.Born)
 	if err != nil {
-		return nil, errors.Wrap(mid.CodeErr{C: http.StatusBadRequest, Err: err}, "parsing birthdate")
+		return nil, err
 	}
 
 	var (
@@ -32,13 +31,13 @@ func (s *Server) handleSignup(
 		loc   = now.Location()
 	)
 
-	_, tzoffset := now.Zone()
-
 	u := &outlived.User{
-		Born:     born,
-		Active:   true,
-		TZName:   loc.String(),
-		TZSector: outlived.TZSector(tzoffset),
+		Born:           born,
+		Active:         true,
+		TZName:         loc.String(),
+		SendHour:       outlived.DefaultSendHour,
+		Frequency:      outlived.FrequencyDaily,
+		FiguresPerMail: outlived.DefaultFiguresPerMail,
 	}
 	err = aesite.NewUser(ctx, s.dsClient, req.Email, req.Password, u)
 	if err != nil {
@@ -66,22 +65,3 @@ func (s *Server) handleSignup(
 
 	return d, nil
 }
-
-const postSignupTmpl = `
-<html>
-  <head>
-    <title>
-      Outlived
-    </title>
-  </head>
-  <body>
-    <h1>Check e-mail</h1>
-
-    <p>
-      Activate your Outlived account by following the verification link
-      in the e-mail we just sent you. The link expires in one hour.
-    </p>
-
-  </body>
-</html>
-`
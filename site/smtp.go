@@ -0,0 +1,201 @@
+package site
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"github.com/bobg/aesite"
+	"github.com/pkg/errors"
+)
+
+// Type smtpSender is a sender that delivers mail to an SMTP server,
+// one message per recipient.
+// It uses STARTTLS when the server offers it,
+// and authenticates when it has a username.
+type smtpSender struct {
+	// addr is the server's host:port.
+	addr string
+
+	// username and password, if set, are used for PLAIN authentication.
+	username, password string
+
+	// requireTLS means refuse to send if the server does not offer STARTTLS.
+	requireTLS bool
+
+	// tlsConfig, if set, is used for STARTTLS
+	// (instead of a default config for the server's hostname).
+	tlsConfig *tls.Config
+}
+
+// Function newSMTPSender creates an smtpSender from these aesite settings:
+//   - smtp_addr: the server's host:port (required)
+//   - smtp_username and smtp_password: credentials (optional)
+//   - smtp_require_tls: "true" to refuse to send without STARTTLS (optional)
+func newSMTPSender(ctx context.Context, dsClient *datastore.Client) (*smtpSender, error) {
+	addr, err := aesite.GetSetting(ctx, dsClient, "smtp_addr")
+	if err != nil {
+		return nil, errors.Wrap(err, "getting setting for smtp_addr")
+	}
+	username, err := optionalSetting(ctx, dsClient, "smtp_username")
+	if err != nil {
+		return nil, err
+	}
+	password, err := optionalSetting(ctx, dsClient, "smtp_password")
+	if err != nil {
+		return nil, err
+	}
+	requireTLS, err := optionalSetting(ctx, dsClient, "smtp_require_tls")
+	if err != nil {
+		return nil, err
+	}
+	return &smtpSender{
+		addr:       string(addr),
+		username:   username,
+		password:   password,
+		requireTLS: requireTLS == "true",
+	}, nil
+}
+
+// Function optionalSetting gets the aesite setting with the given name,
+// or the empty string if there is none.
+func optionalSetting(ctx context.Context, dsClient *datastore.Client, name string) (string, error) {
+	val, err := aesite.GetSetting(ctx, dsClient, name)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return "", nil
+	}
+	return string(val), errors.Wrapf(err, "getting setting for %s", name)
+}
+
+func (ss *smtpSender) send(ctx context.Context, from string, to []recipient, subject string, textR io.Reader, htmlR io.Reader) error {
+	textBody, err := ioutil.ReadAll(textR)
+	if err != nil {
+		return errors.Wrap(err, "reading text body")
+	}
+	var htmlBody []byte
+	if htmlR != nil {
+		htmlBody, err = ioutil.ReadAll(htmlR)
+		if err != nil {
+			return errors.Wrap(err, "reading html body")
+		}
+	}
+
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return errors.Wrapf(err, "parsing from address %s", from)
+	}
+
+	c, err := ss.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var failed []string
+	for _, recip := range to {
+		msg := newMailMessage(from, recip, subject, textBody, htmlBody)
+		err = ss.deliver(c, fromAddr.Address, recip.addr, msg)
+		if err != nil {
+			// A bad recipient should not prevent delivery to the others.
+			log.Printf("sending to %s via %s: %s", recip.addr, ss.addr, err)
+			failed = append(failed, recip.addr)
+			if err := c.Reset(); err != nil {
+				return errors.Wrap(err, "resetting SMTP session")
+			}
+		}
+	}
+
+	err = c.Quit()
+	if err != nil {
+		return errors.Wrap(err, "ending SMTP session")
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not send to %d of %d recipient(s): %s", len(failed), len(to), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Function dial connects to the SMTP server,
+// starts TLS if possible,
+// and authenticates if configured to.
+func (ss *smtpSender) dial(ctx context.Context) (*smtp.Client, error) {
+	host, _, err := net.SplitHostPort(ss.addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing SMTP address %s", ss.addr)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", ss.addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connecting to %s", ss.addr)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "starting SMTP session with %s", ss.addr)
+	}
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		config := ss.tlsConfig
+		if config == nil {
+			config = &tls.Config{ServerName: host}
+		}
+		err = c.StartTLS(config)
+		if err != nil {
+			c.Close()
+			return nil, errors.Wrap(err, "starting TLS")
+		}
+	} else if ss.requireTLS {
+		c.Close()
+		return nil, fmt.Errorf("SMTP server %s does not support STARTTLS", ss.addr)
+	}
+
+	if ss.username != "" {
+		// Note, smtp.PlainAuth refuses to send credentials
+		// over an unencrypted connection except to localhost.
+		err = c.Auth(smtp.PlainAuth("", ss.username, ss.password, host))
+		if err != nil {
+			c.Close()
+			return nil, errors.Wrap(err, "authenticating")
+		}
+	}
+
+	return c, nil
+}
+
+func (ss *smtpSender) deliver(c *smtp.Client, envelopeFrom, recip string, msg *mailMessage) error {
+	b, err := msg.bytes()
+	if err != nil {
+		return errors.Wrap(err, "building message")
+	}
+	err = c.Mail(envelopeFrom)
+	if err != nil {
+		return errors.Wrap(err, "in MAIL command")
+	}
+	err = c.Rcpt(recip)
+	if err != nil {
+		return errors.Wrap(err, "in RCPT command")
+	}
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(err, "in DATA command")
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		w.Close()
+		return errors.Wrap(err, "writing message")
+	}
+	return errors.Wrap(w.Close(), "ending message")
+}
@@ -0,0 +1,136 @@
+package site
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Type fakeSMTPServer is just enough of an SMTP server to test smtpSender.
+// It rejects recipients beginning with "bad".
+type fakeSMTPServer struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	messages map[string][]byte // recipient -> message
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTPServer{ln: ln, messages: make(map[string][]byte)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	tc := textproto.NewConn(conn)
+	defer tc.Close()
+
+	tc.PrintfLine("220 localhost fake ESMTP")
+
+	var rcpt string
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			tc.PrintfLine("250-localhost")
+			tc.PrintfLine("250 8BITMIME")
+		case "MAIL", "RSET":
+			rcpt = ""
+			tc.PrintfLine("250 OK")
+		case "RCPT":
+			addr := strings.Trim(line[strings.Index(line, ":")+1:], " <>")
+			if strings.HasPrefix(addr, "bad") {
+				tc.PrintfLine("550 no such user")
+				continue
+			}
+			rcpt = addr
+			tc.PrintfLine("250 OK")
+		case "DATA":
+			tc.PrintfLine("354 go ahead")
+			msg, err := tc.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages[rcpt] = msg
+			s.mu.Unlock()
+			tc.PrintfLine("250 OK")
+		case "QUIT":
+			tc.PrintfLine("221 bye")
+			return
+		default:
+			tc.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPSender(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	ss := &smtpSender{addr: srv.ln.Addr().String()}
+
+	to := recipients("alice@example.com", "bad@example.com", "bob@example.com")
+	to[0].vars = map[string]string{"unsubscribe": "https://outlived.net/s/unsubscribe?e=1709251200&t=token&u=alice"}
+	to[2].vars = map[string]string{"unsubscribe": "https://outlived.net/s/unsubscribe?e=1709251200&t=token&u=bob"}
+	err := ss.send(context.Background(), from, to, "You have outlived!", strings.NewReader("Hello, text"), strings.NewReader(`<p>Hello, HTML</p><a href="%recipient.unsubscribe%">unsubscribe</a>`))
+	if err == nil {
+		t.Error("got no error, want one for bad@example.com")
+	} else if !strings.Contains(err.Error(), "bad@example.com") {
+		t.Errorf("got error %s, want one mentioning bad@example.com", err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if len(srv.messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(srv.messages))
+	}
+	for _, recip := range []recipient{to[0], to[2]} {
+		t.Run(recip.addr, func(t *testing.T) {
+			raw, ok := srv.messages[recip.addr]
+			if !ok {
+				t.Fatal("no message")
+			}
+			m, err := parseTestMail(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Header.Get("To"); got != recip.addr {
+				t.Errorf("got To %s, want %s", got, recip.addr)
+			}
+			unsubscribe := recip.vars["unsubscribe"]
+			if got, want := m.Header.Get("List-Unsubscribe"), "<"+unsubscribe+">"; got != want {
+				t.Errorf("got List-Unsubscribe %s, want %s", got, want)
+			}
+			if got := m.Header.Get("List-Unsubscribe-Post"); got != listUnsubscribePost {
+				t.Errorf("got List-Unsubscribe-Post %s, want %s", got, listUnsubscribePost)
+			}
+			if m.Subject != "You have outlived!" {
+				t.Errorf("got subject %s", m.Subject)
+			}
+			wantHTML := `<p>Hello, HTML</p><a href="` + unsubscribe + `">unsubscribe</a>`
+			if m.Text != "Hello, text" || m.HTML != wantHTML {
+				t.Errorf("got bodies %q and %q", m.Text, m.HTML)
+			}
+		})
+	}
+}
@@ -1,24 +1,193 @@
 package site
 
 import (
-	"container/list"
 	"context"
-	"log"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
-	"sync"
 	"time"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	"github.com/bobg/basexx"
+	"github.com/bobg/mid"
 	"github.com/pkg/errors"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type taskService interface {
 	queueEmpty(ctx context.Context, queue string) (bool, error)
-	enqueueTask(ctx context.Context, queue, taskName, url string) error
+	enqueueTask(ctx context.Context, queue string, t *task) error
+}
+
+// Type task is a request to be made to this server by a task queue.
+// It is a POST with a JSON body.
+type task struct {
+	// Name is the full name of the task
+	// (e.g. projects/P/locations/L/queues/Q/tasks/T).
+	// No two tasks with the same name can be enqueued
+	// within about an hour of each other.
+	// If Name is empty,
+	// the task service chooses a unique one.
+	Name string
+
+	URL  string // relative to the server
+	Body []byte // JSON
+
+	// ScheduleTime is when the task should run.
+	// If it is zero,
+	// the task runs as soon as possible.
+	ScheduleTime time.Time
+
+	// Deadline is how long a single attempt of the task may take.
+	// If it is zero,
+	// the task service's default applies.
+	Deadline time.Duration
+}
+
+// Type taskDef defines a kind of task,
+// whose payload is a T.
+// Tasks of each kind are handled at /t/KIND
+// by the function registered for that kind with registerTask.
+type taskDef[T any] struct {
+	Kind  string
+	Queue string // the queue ID, e.g. "scrape"
+
+	// Deadline is how long a single attempt of the task may take.
+	// Zero means the task service's default.
+	Deadline time.Duration
+
+	// Legacy, if not nil,
+	// makes a payload from the query parameters of a GET request,
+	// the form of tasks enqueued before payloads were JSON.
+	// It lets such tasks, still queued when a new version is deployed,
+	// run instead of failing (and retrying) indefinitely.
+	// TODO: remove once no tasks of the old form can remain.
+	Legacy func(url.Values) (*T, error)
+}
+
+// Function url gives the URL at which tasks of kind d are handled.
+func (d taskDef[T]) url() string {
+	return "/t/" + d.Kind
+}
+
+// Type taskOpts holds optional settings for enqueueing a task.
+type taskOpts struct {
+	// Key, if not empty,
+	// deduplicates the task:
+	// no two tasks of the same kind with the same key can be enqueued
+	// within about an hour of each other
+	// (see task.Name).
+	Key string
+
+	// Delay is how long to wait before running the task.
+	Delay time.Duration
+}
+
+// Function enqueue adds a task of kind d,
+// with the given payload,
+// to its queue.
+func (d taskDef[T]) enqueue(ctx context.Context, s *Server, payload *T, opts taskOpts) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrapf(err, "encoding %s payload", d.Kind)
+	}
+
+	queue := s.queuePath(d.Queue)
+	t := &task{
+		URL:      d.url(),
+		Body:     body,
+		Deadline: d.Deadline,
+	}
+	if opts.Key != "" {
+		t.Name = taskName(queue, d.Kind+" "+opts.Key)
+	}
+	if opts.Delay > 0 {
+		t.ScheduleTime = time.Now().Add(opts.Delay)
+	}
+
+	err = s.tasks.enqueueTask(ctx, queue, t)
+	return errors.Wrapf(err, "enqueueing %s task", d.Kind)
+}
+
+// Type taskRegistry maps task kinds to their handlers.
+type taskRegistry map[string]func(http.ResponseWriter, *http.Request) error
+
+// Limit on the size of a task payload.
+const maxTaskPayloadSize = 1 << 20
+
+// Function registerTask makes handle the handler in s for tasks of kind d.
+// It panics if the kind already has a handler.
+func registerTask[T any](s *Server, d taskDef[T], handle func(context.Context, *T) error) {
+	if _, ok := s.taskHandlers[d.Kind]; ok {
+		panic(fmt.Sprintf("duplicate registration of task kind %s", d.Kind))
+	}
+	s.taskHandlers[d.Kind] = func(w http.ResponseWriter, req *http.Request) error {
+		legacy := req.Method == "GET" && d.Legacy != nil
+		if req.Method != "POST" && !legacy {
+			return mid.CodeErr{C: http.StatusMethodNotAllowed}
+		}
+		err := s.checkTaskQueue(req, d.Queue)
+		if err != nil {
+			return err
+		}
+
+		payload := new(T)
+		if legacy {
+			payload, err = d.Legacy(req.URL.Query())
+		} else {
+			err = json.NewDecoder(io.LimitReader(req.Body, maxTaskPayloadSize)).Decode(payload)
+		}
+		if err != nil {
+			return errors.Wrap(mid.CodeErr{C: http.StatusBadRequest, Err: err}, "decoding task payload")
+		}
+
+		ctx := req.Context()
+		if d.Deadline > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d.Deadline)
+			defer cancel()
+		}
+
+		return errors.Wrapf(handle(ctx, payload), "in %s task", d.Kind)
+	}
+}
+
+// Function registerTasks registers the handlers for all kinds of task.
+func (s *Server) registerTasks() {
+	s.taskHandlers = make(taskRegistry)
+
+	registerTask(s, scrapedayTask, s.scrapeday)
+	registerTask(s, scrapepersonTask, s.scrapeperson)
+}
+
+// Function queuePath gives the full name of the queue with the given ID.
+func (s *Server) queuePath(queue string) string {
+	return fmt.Sprintf("projects/%s/locations/%s/queues/%s", s.projectID, s.locationID, queue)
+}
+
+// Function taskName gives the full name of a task in the given queue,
+// derived from inp.
+func taskName(queue, inp string) string {
+	hasher := sha256.New()
+	hasher.Write([]byte{1}) // version of this hash
+	hasher.Write([]byte(inp))
+	h := hasher.Sum(nil)
+	src := basexx.NewBuffer(h, basexx.Binary)
+	buf := make([]byte, basexx.Length(256, 50, len(h)))
+	dest := basexx.NewBuffer(buf[:], basexx.Base50)
+	_, err := basexx.Convert(dest, src)
+	if err != nil {
+		panic(err)
+	}
+	converted := dest.Written()
+	return fmt.Sprintf("%s/tasks/%s", queue, string(converted))
 }
 
 type gCloudTasks cloudtasks.Client
@@ -38,108 +207,27 @@ func (t *gCloudTasks) queueEmpty(ctx context.Context, queue string) (bool, error
 	return err == iterator.Done, nil
 }
 
-func (t *gCloudTasks) enqueueTask(ctx context.Context, queue, taskName, url string) error {
-	_, err := (*cloudtasks.Client)(t).CreateTask(ctx, &taskspb.CreateTaskRequest{
+func (t *gCloudTasks) enqueueTask(ctx context.Context, queue string, tk *task) error {
+	ctreq := &taskspb.CreateTaskRequest{
 		Parent: queue,
 		Task: &taskspb.Task{
-			Name: taskName,
+			Name: tk.Name,
 			MessageType: &taskspb.Task_AppEngineHttpRequest{
 				AppEngineHttpRequest: &taskspb.AppEngineHttpRequest{
-					HttpMethod:  taskspb.HttpMethod_GET,
-					RelativeUri: url,
+					HttpMethod:  taskspb.HttpMethod_POST,
+					RelativeUri: tk.URL,
+					Headers:     map[string]string{"Content-Type": "application/json"},
+					Body:        tk.Body,
 				},
 			},
 		},
-	})
-	return errors.Wrapf(err, "gCloudTasks: enqueueing task %s, queue %s, url %s", taskName, queue, url)
-}
-
-type localTasks struct {
-	base   *url.URL
-	ctx    context.Context
-	queues map[string]*list.List
-
-	mu sync.Mutex // protects queues
-}
-
-func newLocalTasks(ctx context.Context, host string) *localTasks {
-	return &localTasks{
-		base: &url.URL{
-			Scheme: "http",
-			Host:   host,
-		},
-		ctx:    ctx,
-		queues: make(map[string]*list.List),
 	}
-}
-
-func (t *localTasks) queueEmpty(ctx context.Context, name string) (bool, error) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	queue := t.queues[name]
-	return queue == nil || queue.Len() == 0, nil
-}
-
-func (t *localTasks) enqueueTask(ctx context.Context, queueName, _, url string) error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	queue := t.queues[queueName]
-	if queue == nil {
-		queue = list.New()
-		t.queues[queueName] = queue
-		go t.process(queueName)
-	}
-
-	queue.PushBack(url)
-	return nil
-}
-
-func (t *localTasks) process(queueName string) {
-	log.Printf("starting queue processor for %s", queueName)
-	defer log.Printf("exiting queue processor for %s", queueName)
-
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-t.ctx.Done():
-			return
-
-		case <-ticker.C:
-			urlstr := t.getTask(queueName)
-			if urlstr == "" {
-				continue
-			}
-
-			u, err := url.Parse(urlstr)
-			if err != nil {
-				log.Printf("localTasks, queue %s: parsing url %s: %s", queueName, urlstr, err)
-				continue
-			}
-
-			_, err = http.Get(t.base.ResolveReference(u).String())
-			if err != nil {
-				log.Printf("localTasks, queue %s: during GET %s: %s", queueName, urlstr, err)
-				continue
-			}
-		}
-	}
-}
-
-func (t *localTasks) getTask(queueName string) string {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	queue := t.queues[queueName]
-	if queue == nil {
-		return ""
+	if !tk.ScheduleTime.IsZero() {
+		ctreq.Task.ScheduleTime = timestamppb.New(tk.ScheduleTime)
 	}
-	el := queue.Front()
-	if el == nil {
-		return ""
+	if tk.Deadline > 0 {
+		ctreq.Task.DispatchDeadline = durationpb.New(tk.Deadline)
 	}
-	return queue.Remove(el).(string)
+	_, err := (*cloudtasks.Client)(t).CreateTask(ctx, ctreq)
+	return errors.Wrapf(err, "gCloudTasks: enqueueing task %s, queue %s, url %s", tk.Name, queue, tk.URL)
 }
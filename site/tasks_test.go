@@ -0,0 +1,158 @@
+package site
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/mid"
+)
+
+type testTaskPayload struct {
+	Text string `json:"text"`
+}
+
+func TestTaskRegistry(t *testing.T) {
+	var (
+		def = taskDef[testTaskPayload]{
+			Kind:     "test",
+			Queue:    "q",
+			Deadline: time.Second,
+		}
+		got = make(chan string, 10)
+	)
+
+	s := &Server{projectID: "p", locationID: "l"}
+	s.taskHandlers = make(taskRegistry)
+	registerTask(s, def, func(ctx context.Context, p *testTaskPayload) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("task context has no deadline")
+		}
+		got <- p.Text
+		return nil
+	})
+
+	mux := http.NewServeMux()
+	for kind, handler := range s.taskHandlers {
+		mux.Handle("/t/"+kind, mid.Err(handler))
+	}
+	lt := testLocalTasks(t, mux.ServeHTTP)
+	s.tasks = lt
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lt.start(ctx)
+
+	// Long enough to need a POST body rather than a query string.
+	long := strings.Repeat("x", 10000)
+
+	start := time.Now()
+	if err := def.enqueue(ctx, s, &testTaskPayload{Text: long}, taskOpts{Key: "a", Delay: 200 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := def.enqueue(ctx, s, &testTaskPayload{Text: "dup"}, taskOpts{Key: "a"}); err == nil {
+		t.Error("got no error enqueueing a task with a duplicate key")
+	}
+	if err := def.enqueue(ctx, s, &testTaskPayload{Text: "b"}, taskOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"b", long} {
+		select {
+		case text := <-got:
+			if text != want {
+				t.Errorf("got payload of length %d, want length %d", len(text), len(want))
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("delayed task ran after %s", elapsed)
+	}
+}
+
+func TestTaskHandler(t *testing.T) {
+	s := &Server{}
+	s.taskHandlers = make(taskRegistry)
+	registerTask(s, taskDef[testTaskPayload]{Kind: "test", Queue: "q"}, func(context.Context, *testTaskPayload) error {
+		return nil
+	})
+	handler := mid.Err(s.taskHandlers["test"])
+
+	cases := []struct {
+		method, body string
+		want         int
+	}{
+		{"POST", `{"text": "hello"}`, http.StatusNoContent},
+		{"GET", "", http.StatusMethodNotAllowed},
+		{"POST", `{"text": `, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/t/test", strings.NewReader(c.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s %s: got status %d, want %d", c.method, c.body, rec.Code, c.want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic registering a duplicate task kind")
+		}
+	}()
+	registerTask(s, taskDef[testTaskPayload]{Kind: "test"}, func(context.Context, *testTaskPayload) error { return nil })
+}
+
+func TestLegacyTask(t *testing.T) {
+	s := &Server{}
+	s.taskHandlers = make(taskRegistry)
+
+	var got string
+	def := taskDef[testTaskPayload]{
+		Kind:  "test",
+		Queue: "q",
+		Legacy: func(v url.Values) (*testTaskPayload, error) {
+			return &testTaskPayload{Text: v.Get("text")}, nil
+		},
+	}
+	registerTask(s, def, func(_ context.Context, p *testTaskPayload) error {
+		got = p.Text
+		return nil
+	})
+	handler := mid.Err(s.taskHandlers["test"])
+
+	for _, c := range []struct {
+		method, target, body, want string
+	}{
+		{"GET", "/t/test?text=old", "", "old"},
+		{"POST", "/t/test", `{"text": "new"}`, "new"},
+	} {
+		got = ""
+		req := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("%s %s: got status %d, want %d", c.method, c.target, rec.Code, http.StatusNoContent)
+		}
+		if got != c.want {
+			t.Errorf("%s %s: got payload %q, want %q", c.method, c.target, got, c.want)
+		}
+	}
+
+	p, err := legacyScrapedayPayload(url.Values{"m": {"3"}, "d": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Month != time.March || p.Day != 1 || p.Run != "" {
+		t.Errorf("got %+v, want March 1 with no run", *p)
+	}
+	if _, err = legacyScrapedayPayload(url.Values{"m": {"x"}, "d": {"1"}}); err == nil {
+		t.Error("got no error for a bad month")
+	}
+}
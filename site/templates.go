@@ -0,0 +1,195 @@
+package site
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	htemplate "html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	ttemplate "text/template"
+
+	"cloud.google.com/go/datastore"
+	"github.com/pkg/errors"
+)
+
+// The built-in mail, page, and feed templates.
+// Files in templates/layouts and templates/partials
+// define templates shared by the others:
+// the "mail" and "page" layouts,
+// and the "header", "footer", and "figure" (card) partials.
+// Each of the other files is a template of its own,
+// named by its path without the extension (e.g. mail/daily).
+// A .txt file is a text/template, and a .html file is an html/template.
+//
+//go:embed templates
+var builtinTemplates embed.FS
+
+// Type templates is a parsed set of templates (see builtinTemplates).
+type templates struct {
+	text map[string]*ttemplate.Template
+	html map[string]*htemplate.Template
+}
+
+// Functions available in templates.
+var templateFuncs = map[string]interface{}{
+	"redir": func(link string) (string, error) {
+		r, err := rlink(link)
+		if err != nil {
+			return "", errors.Wrapf(err, "making link for %s", link)
+		}
+		return r.String(), nil
+	},
+}
+
+// Function loadTemplates parses the built-in templates,
+// each of which may be overridden by a file with the same relative path in dir
+// or by the contents of the setting "template:PATH"
+// (e.g. template:mail/daily.html),
+// which takes precedence.
+// Either dir or dsClient may be omitted.
+func loadTemplates(ctx context.Context, dsClient *datastore.Client, dir string) (*templates, error) {
+	files := make(map[string]string)
+	err := fs.WalkDir(builtinTemplates, "templates", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		name := strings.TrimPrefix(p, "templates/")
+
+		b, err := builtinTemplates.ReadFile(p)
+		if err != nil {
+			return errors.Wrapf(err, "reading built-in template %s", name)
+		}
+		if dir != "" {
+			override, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+			if err == nil {
+				b = override
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return errors.Wrapf(err, "reading template %s", name)
+			}
+		}
+		if dsClient != nil {
+			override, err := optionalSetting(ctx, dsClient, "template:"+name)
+			if err != nil {
+				return err
+			}
+			if override != "" {
+				b = []byte(override)
+			}
+		}
+
+		files[name] = string(b)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "loading templates")
+	}
+	return parseTemplates(files)
+}
+
+// Function parseTemplates parses template files,
+// given as a map from relative path to contents.
+func parseTemplates(files map[string]string) (*templates, error) {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		tbase = ttemplate.New("").Funcs(templateFuncs)
+		hbase = htemplate.New("").Funcs(templateFuncs)
+		top   []string
+	)
+	for _, name := range names {
+		if dir := path.Dir(name); dir != "layouts" && dir != "partials" {
+			top = append(top, name)
+			continue
+		}
+		var err error
+		switch path.Ext(name) {
+		case ".txt":
+			_, err = tbase.New(name).Parse(files[name])
+		case ".html":
+			_, err = hbase.New(name).Parse(files[name])
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing template %s", name)
+		}
+	}
+
+	result := &templates{
+		text: make(map[string]*ttemplate.Template),
+		html: make(map[string]*htemplate.Template),
+	}
+	for _, name := range top {
+		key := strings.TrimSuffix(name, path.Ext(name))
+		switch path.Ext(name) {
+		case ".txt":
+			t, err := tbase.Clone()
+			if err != nil {
+				return nil, errors.Wrapf(err, "cloning templates for %s", name)
+			}
+			result.text[key], err = t.New(name).Parse(files[name])
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing template %s", name)
+			}
+
+		case ".html":
+			t, err := hbase.Clone()
+			if err != nil {
+				return nil, errors.Wrapf(err, "cloning templates for %s", name)
+			}
+			result.html[key], err = t.New(name).Parse(files[name])
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing template %s", name)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Function renderMail executes the plain-text and HTML templates with the given name
+// (e.g. mail/daily)
+// with the given data,
+// producing the two bodies of a message.
+func (t *templates) renderMail(name string, dict map[string]interface{}) (textBody, htmlBody []byte, err error) {
+	ttmpl, ok := t.text[name]
+	if !ok {
+		return nil, nil, errors.Errorf("no plain-text template %s", name)
+	}
+	textBuf := new(bytes.Buffer)
+	err = ttmpl.Execute(textBuf, dict)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "executing plain-text template %s", name)
+	}
+
+	htmlBuf := new(bytes.Buffer)
+	err = t.renderHTML(htmlBuf, name, dict)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return textBuf.Bytes(), htmlBuf.Bytes(), nil
+}
+
+// Function renderHTML executes the HTML template with the given name
+// (e.g. pages/forgot)
+// with the given data.
+func (t *templates) renderHTML(w io.Writer, name string, dict map[string]interface{}) error {
+	htmpl, ok := t.html[name]
+	if !ok {
+		return errors.Errorf("no HTML template %s", name)
+	}
+	err := htmpl.Execute(w, dict)
+	return errors.Wrapf(err, "executing HTML template %s", name)
+}
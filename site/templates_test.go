@@ -0,0 +1,94 @@
+package site
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"outlived"
+)
+
+// Function testTemplates loads the built-in templates.
+func testTemplates(t *testing.T) *templates {
+	t.Helper()
+	tmpl, err := loadTemplates(context.Background(), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+func TestTemplates(t *testing.T) {
+	tmpl := testTemplates(t)
+
+	for _, name := range []string{"mail/daily", "mail/milestone", "mail/verify", "mail/forgot"} {
+		if _, ok := tmpl.text[name]; !ok {
+			t.Errorf("no plain-text template %s", name)
+		}
+		if _, ok := tmpl.html[name]; !ok {
+			t.Errorf("no HTML template %s", name)
+		}
+	}
+	for _, name := range []string{"pages/forgot", "pages/unsubscribe", "pages/post-signup", "pages/post-forgot", "feed/entry"} {
+		if _, ok := tmpl.html[name]; !ok {
+			t.Errorf("no HTML template %s", name)
+		}
+	}
+
+	buf := new(strings.Builder)
+	err := tmpl.renderHTML(buf, "pages/post-signup", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.Contains(got, "<h1>Check e-mail</h1>") || !strings.Contains(got, "verification link") {
+		t.Errorf("unexpected post-signup page:\n%s", got)
+	}
+}
+
+func TestTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "partials"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "partials", "footer.txt"), []byte(`{{ define "footer" }}Bye now.{{ end }}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := loadTemplates(context.Background(), nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{tmpl: tmpl}
+
+	figures := []*outlived.Figure{{Link: "Jane_Doe", Name: "Jane Doe"}}
+	textBody, htmlBody, err := s.renderDailyMail(outlived.Date{Y: 1971, M: 1, D: 1}, figures, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(textBody); !strings.HasSuffix(strings.TrimSpace(got), "Bye now.") || !strings.Contains(got, "Jane Doe") {
+		t.Errorf("footer not overridden in plain-text body:\n%s", got)
+	}
+	if got := string(htmlBody); !strings.Contains(got, "unsubscribe</a>") {
+		t.Errorf("HTML footer missing:\n%s", got)
+	}
+}
+
+func TestRedirFunc(t *testing.T) {
+	redir := templateFuncs["redir"].(func(string) (string, error))
+
+	got, err := redir("Jane_Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(got, "/r?w=Jane_Doe") {
+		t.Errorf("got %s, want ...%s", got, "/r?w=Jane_Doe")
+	}
+
+	if _, err = redir("http://%zz"); err == nil {
+		t.Error("got no error for an unparseable link")
+	}
+}
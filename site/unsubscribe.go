@@ -0,0 +1,64 @@
+package site
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// How long the unsubscribe link in a daily mail works.
+// Mail is sometimes read long after it is sent.
+const unsubscribeLinkLifetime = 90 * 24 * time.Hour
+
+// Function handleUnsubscribe handles the unsubscribe link in the daily mail,
+// which is authenticated by an expiring token in the URL (see expiringTokenURL).
+//
+// A GET request shows a page asking for confirmation,
+// so that link scanners and prefetchers don't unsubscribe anyone.
+// A POST request deactivates the user.
+// That's what the confirmation page does,
+// and also what mail clients do for one-click unsubscribe
+// (RFC 8058, advertised in the List-Unsubscribe-Post header).
+func (s *Server) handleUnsubscribe(w http.ResponseWriter, req *http.Request) error {
+	ctx := req.Context()
+
+	u, err := s.expiringTokenUser(req, unsubscribeTokenPurpose)
+	if err != nil {
+		return err
+	}
+
+	dict := map[string]interface{}{
+		"email":  u.Email,
+		"active": u.Active,
+		"u":      req.FormValue("u"),
+		"e":      req.FormValue("e"),
+		"t":      req.FormValue("t"),
+	}
+
+	switch req.Method {
+	case "GET", "HEAD":
+		// Show the confirmation page.
+
+	case "POST":
+		if u.Active {
+			u.Active = false
+			_, err = s.dsClient.Put(ctx, u.Key(), u)
+			if err != nil {
+				return errors.Wrapf(err, "updating user %s", u.Email)
+			}
+			log.Printf("unsubscribed user %s", u.Email)
+		}
+		dict["active"] = false
+		dict["done"] = true
+
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return s.tmpl.renderHTML(w, "pages/unsubscribe", dict)
+}
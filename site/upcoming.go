@@ -0,0 +1,81 @@
+package site
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bobg/mid"
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+type (
+	upcomingResp struct {
+		Upcoming []upcomingData `json:"upcoming"`
+	}
+
+	upcomingData struct {
+		// Date is when the user outlives the figure.
+		Date         string     `json:"date"`
+		DateYYYYMMDD string     `json:"dateyyyymmdd"`
+		Figure       figureData `json:"figure"`
+	}
+)
+
+const (
+	defaultUpcomingDays  = 30
+	maxUpcomingDays      = 366
+	defaultUpcomingLimit = 24
+	maxUpcomingLimit     = 100
+)
+
+// Function handleUpcoming tells the signed-in user
+// which figures they will outlive in the coming days, and when.
+func (s *Server) handleUpcoming(
+	ctx context.Context,
+	req struct {
+		TZName string `json:"tzname"`
+		Days   int    `json:"days"`
+		Limit  int    `json:"limit"`
+	},
+) (*upcomingResp, error) {
+	sess := getSess(ctx)
+	if sess == nil {
+		return nil, mid.CodeErr{C: http.StatusUnauthorized}
+	}
+	var u outlived.User
+	err := sess.GetUser(ctx, s.dsClient, &u)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting user for session %d", sess.ID)
+	}
+
+	days := req.Days
+	if days <= 0 {
+		days = defaultUpcomingDays
+	} else if days > maxUpcomingDays {
+		days = maxUpcomingDays
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultUpcomingLimit
+	} else if limit > maxUpcomingLimit {
+		limit = maxUpcomingLimit
+	}
+
+	today := outlived.TimeDate(tzNow(req.TZName))
+	upcoming, err := outlived.UpcomingOutlivings(ctx, s.figures, u.Born, today, days, limit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting upcoming outlivings for %s", u.Email)
+	}
+
+	resp := &upcomingResp{Upcoming: []upcomingData{}}
+	for _, o := range upcoming {
+		resp.Upcoming = append(resp.Upcoming, upcomingData{
+			Date:         o.Date.String(),
+			DateYYYYMMDD: o.Date.YYYYMMDD(),
+			Figure:       s.toFigureData(o.Figure),
+		})
+	}
+	return resp, nil
+}
@@ -0,0 +1,106 @@
+package site
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/bobg/mid"
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+// Purpose strings for tokens that authenticate a user in a URL,
+// for clients (like calendar apps and feed readers) that have no session.
+// Each gets a different token,
+// so a URL for one purpose can't be used for another.
+const (
+	calendarTokenPurpose    = "calendar"
+	feedTokenPurpose        = "feed"
+	mailTokenPurpose        = "mail"
+	unsubscribeTokenPurpose = "unsubscribe"
+)
+
+// Function tokenURL produces a URL for the given path
+// that authenticates the given user for the given purpose.
+// See tokenUser.
+func tokenURL(u *outlived.User, path, purpose string) (*url.URL, error) {
+	token, err := u.SecureToken(strings.NewReader(purpose))
+	if err != nil {
+		return nil, errors.Wrapf(err, "generating %s token", purpose)
+	}
+	v := url.Values{}
+	v.Set("u", u.Key().Encode())
+	v.Set("t", token)
+	link := &url.URL{Path: path, RawQuery: v.Encode()}
+	return homeURL.ResolveReference(link), nil
+}
+
+// Function expiringTokenURL is like tokenURL,
+// but the URL stops working at the given time.
+// See expiringTokenUser.
+func expiringTokenURL(u *outlived.User, path, purpose string, exp time.Time) (*url.URL, error) {
+	expSecs := exp.Unix()
+	link, err := tokenURL(u, path, expiringPurpose(purpose, expSecs))
+	if err != nil {
+		return nil, err
+	}
+	q := link.Query()
+	q.Set("e", strconv.FormatInt(expSecs, 10))
+	link.RawQuery = q.Encode()
+	return link, nil
+}
+
+// Function expiringPurpose folds an expiration time into a token purpose,
+// so the expiration can't be altered without invalidating the token.
+func expiringPurpose(purpose string, expSecs int64) string {
+	return fmt.Sprintf("%s:%d", purpose, expSecs)
+}
+
+// Function expiringTokenUser gets the user authenticated by a URL from expiringTokenURL.
+// It is an error with status 410 (Gone) if the URL has expired.
+func (s *Server) expiringTokenUser(req *http.Request, purpose string) (*outlived.User, error) {
+	expSecsStr := req.FormValue("e")
+	expSecs, err := strconv.ParseInt(expSecsStr, 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(mid.CodeErr{C: http.StatusBadRequest, Err: err}, "parsing expiration time")
+	}
+	if time.Now().Unix() > expSecs {
+		return nil, mid.CodeErr{C: http.StatusGone, Err: fmt.Errorf("%s link expired", purpose)}
+	}
+	return s.tokenUser(req, expiringPurpose(purpose, expSecs))
+}
+
+// Function tokenUser gets the user authenticated by a URL from tokenURL.
+func (s *Server) tokenUser(req *http.Request, purpose string) (*outlived.User, error) {
+	var (
+		userKeyStr = req.FormValue("u")
+		token      = req.FormValue("t")
+	)
+
+	userKey, err := datastore.DecodeKey(userKeyStr)
+	if err != nil {
+		return nil, errors.Wrap(mid.CodeErr{C: http.StatusBadRequest, Err: err}, "decoding user key")
+	}
+
+	var u outlived.User
+	err = s.dsClient.Get(req.Context(), userKey, &u)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return nil, mid.CodeErr{C: http.StatusNotFound}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "getting user record")
+	}
+
+	err = u.CheckToken(strings.NewReader(purpose), token)
+	if err != nil {
+		return nil, errors.Wrapf(mid.CodeErr{C: http.StatusUnauthorized, Err: err}, "checking %s token", purpose)
+	}
+
+	return &u, nil
+}
@@ -0,0 +1,45 @@
+package site
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/aesite"
+
+	"outlived"
+)
+
+func TestExpiringTokenURL(t *testing.T) {
+	u := &outlived.User{User: aesite.User{Email: "alice@example.com", Secret: []byte("secret")}}
+	exp := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	link, err := expiringTokenURL(u, "/s/unsubscribe", unsubscribeTokenPurpose, exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Path != "/s/unsubscribe" {
+		t.Errorf("got path %s, want /s/unsubscribe", link.Path)
+	}
+
+	q := link.Query()
+	expSecs, err := strconv.ParseInt(q.Get("e"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expSecs != exp.Unix() {
+		t.Errorf("got expiration %d, want %d", expSecs, exp.Unix())
+	}
+
+	token := q.Get("t")
+	if err := u.CheckToken(strings.NewReader(expiringPurpose(unsubscribeTokenPurpose, expSecs)), token); err != nil {
+		t.Errorf("token does not check: %s", err)
+	}
+	if err := u.CheckToken(strings.NewReader(expiringPurpose(unsubscribeTokenPurpose, expSecs+86400)), token); err == nil {
+		t.Error("token checks with an altered expiration time")
+	}
+	if err := u.CheckToken(strings.NewReader(unsubscribeTokenPurpose), token); err == nil {
+		t.Error("token checks without an expiration time")
+	}
+}
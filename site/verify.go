@@ -4,12 +4,10 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	htemplate "html/template"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
-	ttemplate "text/template"
 
 	"cloud.google.com/go/datastore"
 	"github.com/bobg/aesite"
@@ -94,40 +92,12 @@ func (s *Server) sendVerificationMail(ctx context.Context, u *outlived.User) err
 
 	dict := map[string]interface{}{"link": link}
 
-	ttmpl, err := ttemplate.New("").Parse(vmailText)
+	textBody, htmlBody, err := s.tmpl.renderMail("mail/verify", dict)
 	if err != nil {
-		return errors.Wrap(err, "parsing plain-text template")
-	}
-	textBuf := new(bytes.Buffer)
-	err = ttmpl.Execute(textBuf, dict)
-	if err != nil {
-		return errors.Wrap(err, "executing plain-text template")
-	}
-
-	htmpl, err := htemplate.New("").Parse(vmailHTML)
-	if err != nil {
-		return errors.Wrap(err, "parsing HTML template")
-	}
-	htmlBuf := new(bytes.Buffer)
-	err = htmpl.Execute(htmlBuf, dict)
-	if err != nil {
-		return errors.Wrap(err, "executing HTML template")
+		return errors.Wrap(err, "rendering verification mail")
 	}
 
 	const subject = "Verify your Outlived e-mail address"
-	err = s.sender.send(ctx, from, []string{u.Email}, subject, textBuf, htmlBuf)
+	err = s.sender.send(ctx, from, recipients(u.Email), subject, bytes.NewReader(textBody), bytes.NewReader(htmlBody))
 	return errors.Wrap(err, "sending verification mail")
 }
-
-const vmailText = `Follow this link to verify your Outlived account:
-
-  {{ .link }}
-
-This link expires in one hour.
-`
-
-const vmailHTML = `
-<p>Follow <a href="{{ .link }}">this link</a> to verify your Outlived account:</p>
-<p><a href="{{ .link }}">{{ .link }}</a></p>
-<p>This link expires in one hour.</p>
-`
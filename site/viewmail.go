@@ -0,0 +1,47 @@
+package site
+
+import (
+	"net/http"
+
+	"github.com/bobg/mid"
+	"github.com/pkg/errors"
+
+	"outlived"
+)
+
+// Function handleViewMail serves the HTML version of a user's daily mail
+// for the day in parameter "d",
+// for the "view in your browser" link in the mail.
+// Like the calendar and feeds,
+// it is authenticated by a token in the URL (see tokenURL).
+func (s *Server) handleViewMail(w http.ResponseWriter, req *http.Request) error {
+	ctx := req.Context()
+
+	u, err := s.tokenUser(req, mailTokenPurpose)
+	if err != nil {
+		return err
+	}
+
+	dStr := req.FormValue("d")
+	d, err := outlived.ParseDate(dStr)
+	if err != nil {
+		return errors.Wrap(mid.CodeErr{C: http.StatusBadRequest, Err: err}, "parsing date")
+	}
+
+	figures, err := s.figuresForMail(ctx, u, d)
+	if err != nil {
+		return err
+	}
+	_, htmlBody, err := s.renderDailyMail(u.Born, figures, u.Frequency == outlived.FrequencyWeekly)
+	if err != nil {
+		return err
+	}
+	vars, err := dailyMailVars(u, d)
+	if err != nil {
+		return errors.Wrapf(err, "computing mail variables for %s", u.Email)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = w.Write(personalize(htmlBody, vars))
+	return err
+}
@@ -0,0 +1,88 @@
+package outlived
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// RecordingTransport is an http.RoundTripper
+// that saves every response it gets in a directory,
+// for later playback with a ReplayTransport.
+// Use it with ScrapeDay and ScrapePerson to capture test fixtures.
+type RecordingTransport struct {
+	// Dir is the directory where responses are saved.
+	Dir string
+
+	// Transport makes the actual requests.
+	// If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := t.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		resp.Body.Close()
+		return nil, errors.Wrapf(err, "dumping response from %s", req.URL)
+	}
+	filename := filepath.Join(t.Dir, transportFilename(req))
+	err = os.WriteFile(filename, dump, 0644)
+	if err != nil {
+		resp.Body.Close()
+		return nil, errors.Wrapf(err, "writing %s", filename)
+	}
+	return resp, nil
+}
+
+// ReplayTransport is an http.RoundTripper
+// that serves the responses saved by a RecordingTransport
+// instead of using the network.
+// A request with no saved response is an error.
+type ReplayTransport struct {
+	// Dir is the directory where responses were saved.
+	Dir string
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	filename := filepath.Join(t.Dir, transportFilename(req))
+	dump, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recorded response for %s %s (in %s)", req.Method, req.URL, filename)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", filename)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), req)
+	return resp, errors.Wrapf(err, "parsing %s", filename)
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Function transportFilename produces the name of the file
+// where a RecordingTransport saves the response to req.
+// It is the host and a readable (and possibly truncated) version of the URL path
+// plus a hash of the method and full URL.
+func transportFilename(req *http.Request) string {
+	h := sha256.Sum256([]byte(req.Method + " " + req.URL.String()))
+	path := unsafeFilenameChars.ReplaceAllString(req.URL.Path, "_")
+	if len(path) > 150 {
+		path = "_" + path[len(path)-150:]
+	}
+	return fmt.Sprintf("%s%s-%x.http", req.URL.Host, path, h[:6])
+}
@@ -0,0 +1,58 @@
+package outlived
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Location", "/moved"+req.URL.Path)
+		w.Write([]byte("hello from " + req.URL.Path))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+
+	var (
+		recorder = &http.Client{Transport: &RecordingTransport{Dir: dir}}
+		replayer = &http.Client{Transport: &ReplayTransport{Dir: dir}}
+	)
+
+	get := func(client *http.Client, path string) (body, loc string, err error) {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			return "", "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return string(b), resp.Header.Get("Content-Location"), err
+	}
+
+	for _, path := range []string{"/a", "/b"} {
+		if _, _, err := get(recorder, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv.Close() // make sure nothing more comes from the network
+
+	for _, path := range []string{"/a", "/b"} {
+		body, loc, err := get(replayer, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "hello from " + path; body != want {
+			t.Errorf("got body %q, want %q", body, want)
+		}
+		if want := "/moved" + path; loc != want {
+			t.Errorf("got Content-Location %q, want %q", loc, want)
+		}
+	}
+
+	if _, _, err := get(replayer, "/c"); err == nil {
+		t.Error("got no error for unrecorded request")
+	}
+}
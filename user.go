@@ -1,14 +1,80 @@
 package outlived
 
-import "github.com/bobg/aesite"
+import (
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/bobg/aesite"
+)
 
 type User struct {
 	aesite.User
-	Born     Date
-	Active   bool
-	TZName   string
-	TZOffset int // deprecated
-	TZSector int // see function TZSector
+	Born   Date
+	Active bool
+
+	// TZName is the IANA name of the user's time zone (e.g. America/Los_Angeles).
+	// The daily mail is sent according to the local time there.
+	TZName string
+
+	// LastSent is the local date (in TZName) of the most recent mail to the user
+	// (regular or milestone).
+	// It ensures the user gets mail at most once per day.
+	LastSent Date
+
+	// Categories, if non-empty, limits the daily mail
+	// to figures whose descriptions mention one of these words
+	// (e.g. "poet" or "composer").
+	// They are lowercase and sorted.
+	Categories []string
+
+	// SendHour is the local hour (0-23, in TZName) at which the user gets mail.
+	// When a daylight saving time change skips that hour,
+	// the mail comes in the next hour instead.
+	SendHour int
+
+	// Frequency is how often the user gets mail.
+	Frequency Frequency
+
+	// FiguresPerMail is the maximum number of figures in a message.
+	FiguresPerMail int
+}
+
+// Defaults for the preferences in User.
+const (
+	DefaultSendHour       = 7
+	DefaultFiguresPerMail = 24
+)
+
+// Type Frequency is how often a user gets mail.
+type Frequency string
+
+const (
+	// FrequencyDaily means a message on each day the user outlives some figures.
+	FrequencyDaily Frequency = "daily"
+
+	// FrequencyWeekly means a digest of the past seven days' figures,
+	// once a week on DigestWeekday.
+	FrequencyWeekly Frequency = "weekly"
+
+	// FrequencyMilestones means no regular messages,
+	// only ones about personal milestones.
+	FrequencyMilestones Frequency = "milestones"
+)
+
+// DigestWeekday is the (local) day of the week
+// on which users with FrequencyWeekly get mail.
+const DigestWeekday = time.Sunday
+
+// ParseFrequency parses a Frequency, where the empty string means FrequencyDaily.
+func ParseFrequency(s string) (Frequency, error) {
+	switch f := Frequency(s); f {
+	case "":
+		return FrequencyDaily, nil
+	case FrequencyDaily, FrequencyWeekly, FrequencyMilestones:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown frequency %s", s)
 }
 
 func (u *User) GetUser() *aesite.User {
@@ -19,8 +85,69 @@ func (u *User) SetUser(au *aesite.User) {
 	u.User = *au
 }
 
-// TZSector reduces a timezone offset (in seconds east of UTC)
-// to an index in the range [0 .. 12].
-func TZSector(tzoffset int) int {
-	return (tzoffset + 36000) / 7200
+// Load implements datastore.PropertyLoadSaver.
+// It ignores the properties TZOffset and TZSector,
+// which older User records have,
+// using TZOffset to set TZName if necessary.
+// It also supplies defaults for preferences that older records lack.
+// Such records are brought up to date when next saved.
+func (u *User) Load(props []datastore.Property) error {
+	var (
+		keep                           []datastore.Property
+		tzoffset                       *int64
+		hasSendHour, hasFiguresPerMail bool
+	)
+	for _, p := range props {
+		switch p.Name {
+		case "TZSector":
+			continue
+		case "TZOffset":
+			if v, ok := p.Value.(int64); ok {
+				tzoffset = &v
+			}
+			continue
+		case "SendHour":
+			hasSendHour = true
+		case "FiguresPerMail":
+			hasFiguresPerMail = true
+		}
+		keep = append(keep, p)
+	}
+	err := datastore.LoadStruct(u, keep)
+	if err != nil {
+		return err
+	}
+	if u.TZName == "" && tzoffset != nil {
+		u.TZName = offsetTZName(int(*tzoffset))
+	}
+	if !hasSendHour {
+		u.SendHour = DefaultSendHour
+	}
+	if !hasFiguresPerMail || u.FiguresPerMail <= 0 {
+		u.FiguresPerMail = DefaultFiguresPerMail
+	}
+	if u.Frequency == "" {
+		u.Frequency = FrequencyDaily
+	}
+	return nil
+}
+
+// Save implements datastore.PropertyLoadSaver.
+func (u *User) Save() ([]datastore.Property, error) {
+	return datastore.SaveStruct(u)
+}
+
+// Function offsetTZName gives the name of an IANA time zone
+// with the given fixed offset (in seconds east of UTC),
+// or UTC if there is none.
+func offsetTZName(tzoffset int) string {
+	if tzoffset == 0 || tzoffset%3600 != 0 {
+		return "UTC"
+	}
+	hours := tzoffset / 3600
+	if hours < -12 || hours > 14 {
+		return "UTC"
+	}
+	// Note, the signs of these names are the opposite of ISO 8601.
+	return fmt.Sprintf("Etc/GMT%+d", -hours)
 }
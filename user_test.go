@@ -0,0 +1,93 @@
+package outlived
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+func TestUserLoad(t *testing.T) {
+	cases := []struct {
+		props      []datastore.Property
+		wantTZName string
+	}{
+		{
+			props: []datastore.Property{
+				{Name: "Email", Value: "a@example.com"},
+				{Name: "TZName", Value: "America/Los_Angeles"},
+				{Name: "TZOffset", Value: int64(-25200)},
+				{Name: "TZSector", Value: int64(1)},
+			},
+			wantTZName: "America/Los_Angeles",
+		},
+		{
+			props: []datastore.Property{
+				{Name: "Email", Value: "b@example.com"},
+				{Name: "TZOffset", Value: int64(-28800)},
+				{Name: "TZSector", Value: int64(1)},
+			},
+			wantTZName: "Etc/GMT+8",
+		},
+		{
+			props: []datastore.Property{
+				{Name: "Email", Value: "c@example.com"},
+				{Name: "TZOffset", Value: int64(19800)},
+			},
+			wantTZName: "UTC",
+		},
+		{
+			props: []datastore.Property{
+				{Name: "Email", Value: "d@example.com"},
+			},
+			wantTZName: "",
+		},
+	}
+
+	t.Run("prefs", func(t *testing.T) {
+		var u User
+		err := u.Load([]datastore.Property{
+			{Name: "Email", Value: "e@example.com"},
+			{Name: "SendHour", Value: int64(0)},
+			{Name: "Frequency", Value: "weekly"},
+			{Name: "FiguresPerMail", Value: int64(5)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.SendHour != 0 || u.Frequency != FrequencyWeekly || u.FiguresPerMail != 5 {
+			t.Errorf("got preferences %d/%s/%d, want 0/weekly/5", u.SendHour, u.Frequency, u.FiguresPerMail)
+		}
+	})
+
+	for _, c := range cases {
+		t.Run(c.props[0].Value.(string), func(t *testing.T) {
+			var u User
+			err := u.Load(c.props)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.TZName != c.wantTZName {
+				t.Errorf("got TZName %q, want %q", u.TZName, c.wantTZName)
+			}
+			if u.SendHour != DefaultSendHour || u.Frequency != FrequencyDaily || u.FiguresPerMail != DefaultFiguresPerMail {
+				t.Errorf("got preferences %d/%s/%d, want defaults", u.SendHour, u.Frequency, u.FiguresPerMail)
+			}
+			if c.wantTZName != "" {
+				if _, err := time.LoadLocation(c.wantTZName); err != nil {
+					t.Error(err)
+				}
+			}
+
+			props, err := u.Save()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range props {
+				if p.Name == "TZOffset" || p.Name == "TZSector" {
+					t.Errorf("saved obsolete property %s", p.Name)
+				}
+			}
+		})
+	}
+}
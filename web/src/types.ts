@@ -5,6 +5,7 @@ export interface FigureData {
   died: string
   daysAlive: number
   yearsDaysAlive: string
+  approx?: boolean
   href: string
   imgAlt?: string
   imgSrc?: string
@@ -20,6 +21,18 @@ export interface UserData {
   figures: FigureData[]
   verified: boolean
   active: boolean
+  categories: string[] | null
+  sendHour: number
+  frequency: 'daily' | 'weekly' | 'milestones'
+  figuresPerMail: number
+  calendarURL: string
+  feedURL: string
+}
+
+export interface UpcomingData {
+  date: string
+  dateyyyymmdd: string
+  figure: FigureData
 }
 
 export interface Data {
@@ -0,0 +1,402 @@
+package outlived
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Source says where ScrapeFigure gets the details of a figure.
+type Source string
+
+const (
+	// SourceHTML parses the figure's Wikipedia page (see ScrapePerson).
+	SourceHTML Source = "html"
+
+	// SourceWikidata reads the figure's Wikidata entity (see ScrapeWikidata).
+	SourceWikidata Source = "wikidata"
+
+	// SourceMerge does both,
+	// preferring structured Wikidata dates
+	// and the Wikipedia page's name, image, and description.
+	// If either source fails, the other one is used alone.
+	SourceMerge Source = "merge"
+)
+
+// ParseSource parses the name of a Source.
+// The empty string means SourceHTML.
+func ParseSource(s string) (Source, error) {
+	switch src := Source(strings.ToLower(strings.TrimSpace(s))); src {
+	case "":
+		return SourceHTML, nil
+	case SourceHTML, SourceWikidata, SourceMerge:
+		return src, nil
+	}
+	return "", fmt.Errorf("unknown figure source %s", s)
+}
+
+// ScrapeFigure gets the details of the figure at the given Wikipedia href
+// (as found by ScrapeDay)
+// from the given source.
+func ScrapeFigure(ctx context.Context, client *http.Client, src Source, href, title, desc string) (*PersonResult, error) {
+	switch src {
+	case SourceHTML, "":
+		return ScrapePerson(ctx, client, href, title, desc)
+
+	case SourceWikidata:
+		return ScrapeWikidata(ctx, client, href, title, desc)
+
+	case SourceMerge:
+		hres, herr := ScrapePerson(ctx, client, href, title, desc)
+		wres, werr := scrapeWikidataPerson(ctx, client, href, title, desc)
+		if herr != nil && werr != nil {
+			return nil, errors.Wrapf(herr, "scraping HTML (and Wikidata: %s)", werr)
+		}
+		if werr != nil {
+			hres.warnf("using Wikipedia page alone: %s", werr)
+			return hres, nil
+		}
+		if herr != nil {
+			wres.warnf("using Wikidata alone: %s", herr)
+			var err error
+			wres.Figure.Pageviews, err = scrapePageviews(ctx, client, wres.Href)
+			return wres, classify(ScrapeErrorPageviews, errors.Wrap(err, "getting pageviews"))
+		}
+		mergeResults(hres, wres)
+		return hres, nil
+	}
+
+	return nil, fmt.Errorf("unknown figure source %s", src)
+}
+
+// Function mergeResults combines into hres the results of scraping a figure's Wikipedia page (hres)
+// and its Wikidata entity (wres).
+func mergeResults(hres, wres *PersonResult) {
+	var (
+		hfig = hres.Figure
+		wfig = wres.Figure
+	)
+	// Compare days, not Dates, which may be in different calendars.
+	if hfig.Born.Since(wfig.Born) != 0 {
+		hres.warnf("Wikipedia page says born %s, Wikidata says %s", hfig.Born, wfig.Born)
+	}
+	if hfig.Died.Since(wfig.Died) != 0 {
+		hres.warnf("Wikipedia page says died %s, Wikidata says %s", hfig.Died, wfig.Died)
+	}
+	hres.warnf("dates from Wikidata entity %s", wfig.QID)
+
+	hfig.QID = wfig.QID
+	hfig.Born, hfig.Died, hfig.DaysAlive = wfig.Born, wfig.Died, wfig.DaysAlive
+	if hfig.ImgSrc == "" {
+		hfig.ImgSrc, hfig.ImgAlt = wfig.ImgSrc, wfig.ImgAlt
+	}
+	if hfig.Desc == "" {
+		hfig.Desc = wfig.Desc
+	}
+}
+
+// ScrapeWikidata gets the details of the figure at the given Wikipedia href
+// (as found by ScrapeDay)
+// from the figure's Wikidata entity,
+// using its structured birth and death dates (properties P569 and P570)
+// and image (P18).
+// If desc is empty, the entity's English description is used instead.
+func ScrapeWikidata(ctx context.Context, client *http.Client, href, title, desc string) (*PersonResult, error) {
+	res, err := scrapeWikidataPerson(ctx, client, href, title, desc)
+	if err != nil {
+		return nil, err
+	}
+	res.Figure.Pageviews, err = scrapePageviews(ctx, client, res.Href)
+	return res, classify(ScrapeErrorPageviews, errors.Wrap(err, "getting pageviews"))
+}
+
+// Function scrapeWikidataPerson is ScrapeWikidata without the pageviews.
+func scrapeWikidataPerson(ctx context.Context, client *http.Client, href, title, desc string) (*PersonResult, error) {
+	page, err := url.PathUnescape(href)
+	if err != nil {
+		page = href
+	}
+
+	v := url.Values{}
+	v.Set("action", "wbgetentities")
+	v.Set("sites", "enwiki")
+	v.Set("titles", page)
+	v.Set("props", "labels|descriptions|claims|sitelinks")
+	v.Set("languages", "en")
+	v.Set("sitefilter", "enwiki")
+	v.Set("format", "json")
+	u := "https://www.wikidata.org/w/api.php?" + v.Encode()
+
+	resp, err := httpGetContext(ctx, client, u)
+	if err != nil {
+		return nil, classify(ScrapeErrorHTTP, errors.Wrapf(err, "fetching %s", u))
+	}
+	defer resp.Body.Close()
+
+	ent, err := parseWikidataEntity(resp.Body)
+	if err != nil {
+		return nil, classify(ScrapeErrorParse, errors.Wrapf(err, "parsing Wikidata entity for %s", href))
+	}
+
+	fig, err := ent.figure(title, desc)
+	if err != nil {
+		return nil, classifyParseError(errors.Wrapf(err, "interpreting Wikidata entity %s", ent.ID))
+	}
+	if link, err := url.PathUnescape(fig.Link); err == nil && link == strings.ReplaceAll(page, " ", "_") {
+		// Same page: keep the href as given,
+		// since it is the figure's key and may be escaped differently.
+		fig.Link = href
+	}
+
+	res := &PersonResult{
+		Figure:    fig,
+		Parser:    ParserWikidata,
+		Href:      fig.Link,
+		OrigHref:  href,
+		OrigTitle: title,
+	}
+	if fig.Link != href {
+		res.warnf("updated href %s -> %s", href, fig.Link)
+	}
+	return res, nil
+}
+
+type (
+	wdEntities struct {
+		Entities map[string]*wdEntity `json:"entities"`
+	}
+
+	wdEntity struct {
+		ID           string                `json:"id"`
+		Missing      *string               `json:"missing"`
+		Labels       map[string]wdText     `json:"labels"`
+		Descriptions map[string]wdText     `json:"descriptions"`
+		Claims       map[string][]wdClaim  `json:"claims"`
+		Sitelinks    map[string]wdSitelink `json:"sitelinks"`
+	}
+
+	wdText struct {
+		Value string `json:"value"`
+	}
+
+	wdSitelink struct {
+		Title string `json:"title"`
+	}
+
+	wdClaim struct {
+		Mainsnak struct {
+			Snaktype  string `json:"snaktype"`
+			Datavalue struct {
+				Value json.RawMessage `json:"value"`
+			} `json:"datavalue"`
+		} `json:"mainsnak"`
+		Rank string `json:"rank"`
+	}
+
+	wdTime struct {
+		Time          string `json:"time"`
+		Precision     int    `json:"precision"`
+		Calendarmodel string `json:"calendarmodel"`
+	}
+)
+
+// Function parseWikidataEntity parses a response in Wikidata's JSON entity format
+// (as produced by Special:EntityData or the wbgetentities API action)
+// that is expected to contain exactly one entity.
+func parseWikidataEntity(r io.Reader) (*wdEntity, error) {
+	var ents wdEntities
+	err := json.NewDecoder(r).Decode(&ents)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding JSON")
+	}
+	if len(ents.Entities) != 1 {
+		return nil, fmt.Errorf("got %d entities, want 1", len(ents.Entities))
+	}
+	for _, ent := range ents.Entities {
+		if ent.Missing != nil {
+			return nil, errNotFound
+		}
+		return ent, nil
+	}
+	return nil, errNotFound // not reached
+}
+
+func (e *wdEntity) figure(title, desc string) (*Figure, error) {
+	born, err := e.date("P569")
+	if err != nil {
+		return nil, errors.Wrap(err, "getting date of birth")
+	}
+	died, err := e.date("P570")
+	if err != nil {
+		return nil, errors.Wrap(err, "getting date of death")
+	}
+
+	name := e.Labels["en"].Value
+	if name == "" {
+		name = title
+	}
+	if desc == "" {
+		desc = e.Descriptions["en"].Value
+	}
+
+	sitelink := e.Sitelinks["enwiki"].Title
+	if sitelink == "" {
+		return nil, errors.New("no English Wikipedia sitelink")
+	}
+	link := wikiHref(sitelink)
+
+	fig := &Figure{
+		Name:      name,
+		Desc:      desc,
+		Link:      link,
+		QID:       e.ID,
+		Born:      born,
+		Died:      died,
+		DaysAlive: died.Since(born),
+		Updated:   time.Now(),
+	}
+
+	var img string
+	if e.best("P18", &img) == nil {
+		fig.ImgSrc = commonsThumb(img, 220)
+		fig.ImgAlt = name
+	}
+
+	return fig, nil
+}
+
+// Function wikiHref gives the href of the English Wikipedia page with the given title,
+// escaped like the hrefs that ScrapeDay finds
+// (e.g. "Saint_David%27s_Day").
+func wikiHref(title string) string {
+	return url.PathEscape(strings.ReplaceAll(title, " ", "_"))
+}
+
+// Function best finds the best claim for the given property
+// (the first preferred one, else the first normal one)
+// and decodes its value into v.
+func (e *wdEntity) best(prop string, v interface{}) error {
+	var found *wdClaim
+	for i, claim := range e.Claims[prop] {
+		if claim.Mainsnak.Snaktype != "value" {
+			continue
+		}
+		if claim.Rank == "preferred" {
+			found = &e.Claims[prop][i]
+			break
+		}
+		if claim.Rank == "normal" && found == nil {
+			found = &e.Claims[prop][i]
+		}
+	}
+	if found == nil {
+		return errNotFound
+	}
+	return json.Unmarshal(found.Mainsnak.Datavalue.Value, v)
+}
+
+// Wikidata time precisions.
+// See https://www.wikidata.org/wiki/Help:Dates#Precision.
+const (
+	wdPrecisionDecade = 8
+	wdPrecisionYear   = 9
+	wdPrecisionMonth  = 10
+	wdPrecisionDay    = 11
+)
+
+// The calendar model of Wikidata times in the Julian calendar.
+// (The Gregorian one is Q1985727.)
+const wdJulian = "http://www.wikidata.org/entity/Q1985786"
+
+var wdTimeRegex = regexp.MustCompile(`^([+-])(\d+)-(\d\d)-(\d\d)T`)
+
+func (e *wdEntity) date(prop string) (Date, error) {
+	var t wdTime
+	err := e.best(prop, &t)
+	if err != nil {
+		return Date{}, err
+	}
+	var prec Precision
+	switch {
+	case t.Precision >= wdPrecisionDay:
+		prec = DayPrecision
+	case t.Precision == wdPrecisionMonth:
+		prec = MonthPrecision
+	case t.Precision == wdPrecisionYear:
+		prec = YearPrecision
+	case t.Precision == wdPrecisionDecade:
+		prec = DecadePrecision
+	default:
+		return Date{}, fmt.Errorf("date %s has precision %d, want at least %d", t.Time, t.Precision, wdPrecisionDecade)
+	}
+	m := wdTimeRegex.FindStringSubmatch(t.Time)
+	if m == nil {
+		return Date{}, fmt.Errorf("cannot parse time %s", t.Time)
+	}
+	y, err := strconv.Atoi(m[2])
+	if err != nil {
+		return Date{}, errors.Wrap(err, "parsing year")
+	}
+	mon, err := strconv.Atoi(m[3])
+	if err != nil {
+		return Date{}, errors.Wrap(err, "parsing month")
+	}
+	d, err := strconv.Atoi(m[4])
+	if err != nil {
+		return Date{}, errors.Wrap(err, "parsing day")
+	}
+	// Less precise times have zeroes in the unknown parts.
+	if prec != DayPrecision {
+		d = 1
+		if prec != MonthPrecision {
+			mon = 1
+		}
+	}
+	if prec == DecadePrecision {
+		y -= y % 10
+	}
+	if m[1] == "-" {
+		// Wikidata numbers years BC historically (no year 0):
+		// -0001 is 1 BC, which is astronomical year 0.
+		if prec == DecadePrecision {
+			// The earliest year of the 40s BC is 49 BC.
+			y += 9
+		}
+		y = 1 - y
+	}
+	var cal Calendar
+	if t.Calendarmodel == wdJulian {
+		cal = Julian
+	}
+	if mon < 1 || mon > 12 || d < 1 || d > cal.daysInMonth(y, time.Month(mon)) {
+		return Date{}, fmt.Errorf("time %s out of range", t.Time)
+	}
+	return Date{Y: y, M: time.Month(mon), D: d, Cal: cal, Prec: prec}, nil
+}
+
+// Function commonsThumb produces the protocol-relative URL of a thumbnail,
+// the given number of pixels wide,
+// of the named file on Wikimedia Commons.
+// See https://www.mediawiki.org/wiki/Manual:$wgHashedUploadDirectory.
+func commonsThumb(filename string, width int) string {
+	filename = strings.ReplaceAll(filename, " ", "_")
+	sum := md5.Sum([]byte(filename))
+	h := hex.EncodeToString(sum[:])
+	escaped := url.PathEscape(filename)
+	thumb := fmt.Sprintf("%dpx-%s", width, escaped)
+	if strings.HasSuffix(strings.ToLower(filename), ".svg") {
+		thumb += ".png"
+	}
+	return fmt.Sprintf("//upload.wikimedia.org/wikipedia/commons/thumb/%s/%s/%s/%s", h[:1], h[:2], escaped, thumb)
+}
@@ -0,0 +1,148 @@
+package outlived
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWikidataEntity(t *testing.T) {
+	cases := []struct {
+		srcfile    string
+		desc       string
+		want       Figure
+		wantErrStr string
+	}{
+		{
+			srcfile: "testdata/wikidata/Q42.json",
+			want: Figure{
+				Link:      "Douglas_Adams",
+				QID:       "Q42",
+				Name:      "Douglas Adams",
+				Desc:      "English science fiction writer and humorist (1952–2001)",
+				Born:      Date{Y: 1952, M: time.March, D: 11},
+				Died:      Date{Y: 2001, M: time.May, D: 11},
+				DaysAlive: 17958,
+				ImgSrc:    "//upload.wikimedia.org/wikipedia/commons/thumb/c/c0/Douglas_adams_portrait_cropped.jpg/220px-Douglas_adams_portrait_cropped.jpg",
+				ImgAlt:    "Douglas Adams",
+			},
+		},
+		{
+			srcfile: "testdata/wikidata/Q1048.json",
+			desc:    "Roman general and statesman",
+			want: Figure{
+				Link:      "Julius_Caesar",
+				QID:       "Q1048",
+				Name:      "Julius Caesar",
+				Desc:      "Roman general and statesman",
+				Born:      Date{Y: -99, M: time.July, D: 12, Cal: Julian},
+				Died:      Date{Y: -43, M: time.March, D: 15, Cal: Julian},
+				DaysAlive: 20335,
+				ImgSrc:    "//upload.wikimedia.org/wikipedia/commons/thumb/8/8f/Gaius_Iulius_Caesar_%28Vatican_Museum%29.jpg/220px-Gaius_Iulius_Caesar_%28Vatican_Museum%29.jpg",
+				ImgAlt:    "Julius Caesar",
+			},
+		},
+		{
+			srcfile: "testdata/wikidata/Q483507.json",
+			want: Figure{
+				Link:      "Sin%C3%A9ad_O%27Connor",
+				QID:       "Q483507",
+				Name:      "Sinéad O'Connor",
+				Desc:      "Irish singer-songwriter (1966–2023)",
+				Born:      Date{Y: 1966, M: time.December, D: 8},
+				Died:      Date{Y: 2023, M: time.July, D: 26},
+				DaysAlive: 20684,
+			},
+		},
+		{
+			srcfile:    "testdata/wikidata/Q7251.json",
+			wantErrStr: "getting date of death",
+		},
+		{
+			srcfile:    "testdata/wikidata/missing.json",
+			wantErrStr: "not found",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.srcfile, func(t *testing.T) {
+			f, err := os.Open(c.srcfile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			ent, err := parseWikidataEntity(f)
+			var fig *Figure
+			if err == nil {
+				fig, err = ent.figure("", c.desc)
+			}
+			if err != nil {
+				if c.wantErrStr == "" {
+					t.Fatal(err)
+				}
+				if !strings.Contains(err.Error(), c.wantErrStr) {
+					t.Errorf("got error %s, want ...%s...", err, c.wantErrStr)
+				}
+				return
+			}
+			if c.wantErrStr != "" {
+				t.Fatalf("got no error, want ...%s...", c.wantErrStr)
+			}
+
+			fig.Updated = time.Time{}
+			if *fig != c.want {
+				t.Errorf("got %+v, want %+v", *fig, c.want)
+			}
+		})
+	}
+}
+
+// Type fileTransport responds to every request with the contents of a file.
+type fileTransport string
+
+func (t fileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f, err := os.Open(string(t))
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       f,
+		Request:    req,
+	}, nil
+}
+
+func TestWikidataHref(t *testing.T) {
+	client := &http.Client{Transport: fileTransport("testdata/wikidata/Q483507.json")}
+
+	cases := []struct {
+		href, want string
+	}{
+		// The href as ScrapeDay finds it is kept.
+		{href: "Sin%C3%A9ad_O%27Connor", want: "Sin%C3%A9ad_O%27Connor"},
+		{href: "Sin%C3%A9ad_O'Connor", want: "Sin%C3%A9ad_O'Connor"},
+
+		// A different page (e.g. a redirect) gets an escaped href for the sitelink.
+		{href: "Sinead_O%27Connor", want: "Sin%C3%A9ad_O%27Connor"},
+	}
+	for _, c := range cases {
+		t.Run(c.href, func(t *testing.T) {
+			res, err := scrapeWikidataPerson(context.Background(), client, c.href, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Href != c.want || res.Figure.Link != c.want {
+				t.Errorf("got href %s and link %s, want %s", res.Href, res.Figure.Link, c.want)
+			}
+		})
+	}
+
+	if got, want := wikiHref("AC/DC (band)?"), "AC%2FDC_%28band%29%3F"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}